@@ -15,4 +15,55 @@ const (
 
 	// BuildStateFailed indicates that a build encountered an error during the build process.
 	BuildStateFailed BuildState = "Failed"
+
+	// BuildStateTimedOut indicates that a build did not finish before its configured deadline.
+	BuildStateTimedOut BuildState = "TimedOut"
+
+	// BuildStatePartiallyPushed indicates that a build finished but its image could only be pushed to some registries.
+	BuildStatePartiallyPushed BuildState = "PartiallyPushed"
+)
+
+// IsFinished returns true when a build state will not change again.
+func (s BuildState) IsFinished() bool {
+	switch s {
+	case BuildStateCompleted, BuildStateFailed, BuildStateTimedOut, BuildStatePartiallyPushed:
+		return true
+	}
+	return false
+}
+
+// Machine-readable explanations for the current build state.
+const (
+	// BuildReasonDeadlineExceeded indicates that the build ran longer than its configured timeout.
+	BuildReasonDeadlineExceeded = "DeadlineExceeded"
+
+	// BuildReasonOOMKilled indicates that the build container was killed after exceeding its memory limit.
+	BuildReasonOOMKilled = "OOMKilled"
+
+	// BuildReasonEvicted indicates that the build pod was evicted from its node.
+	BuildReasonEvicted = "Evicted"
+
+	// BuildReasonImagePullBackOff indicates that a build pod image could not be pulled.
+	BuildReasonImagePullBackOff = "ImagePullBackOff"
+
+	// BuildReasonJobFailed indicates that the build job failed before the build could report its own outcome.
+	BuildReasonJobFailed = "JobFailed"
+
+	// BuildReasonInvalidSpec indicates that the build was rejected before launching a job due to invalid options.
+	BuildReasonInvalidSpec = "InvalidSpec"
+
+	// BuildReasonContextDigestMismatch indicates that the downloaded build context did not match its expected digest.
+	BuildReasonContextDigestMismatch = "ContextDigestMismatch"
+
+	// BuildReasonPushFailed indicates that the image was built but could not be pushed to every registry.
+	BuildReasonPushFailed = "PushFailed"
+
+	// BuildReasonTagExists indicates that image tags already existed, either failing the build or skipping it.
+	BuildReasonTagExists = "TagExists"
+
+	// BuildReasonWorkerLost indicates that the worker running the build stopped before the build finished.
+	BuildReasonWorkerLost = "WorkerLost"
+
+	// BuildReasonWorkerUnresponsive indicates that the worker a build was dispatched to never started running it.
+	BuildReasonWorkerUnresponsive = "WorkerUnresponsive"
 )
@@ -1,9 +1,20 @@
 package v1alpha1
 
 import (
+	_ "crypto/sha256"
+	_ "crypto/sha512"
 	"errors"
-
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -73,6 +84,83 @@ type Registry struct {
 	DynamicCloudCredentials bool `json:"dynamicCloudCredentials"`
 }
 
+// ContextAuth references credentials used to download remote build context archives.
+type ContextAuth struct {
+	// Name of a Secret in the build namespace. A bearer token is read from the "token" key, basic auth credentials
+	// from the "username" and "password" keys, and additional request headers from keys prefixed with "header." (e.g.
+	// "header.X-Api-Key"). An optional "ca.crt" key holds PEM certificates trusted when connecting to the context host.
+	// +kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
+}
+
+// GitAuth references credentials used to fetch git repository build contexts.
+type GitAuth struct {
+	// Name of a Secret in the build namespace. The "username" and "password" keys (kubernetes.io/basic-auth) are used
+	// with http(s) remotes, the "ssh-privatekey" and optional "known_hosts" keys (kubernetes.io/ssh-auth) are used
+	// with ssh remotes. Host keys are not verified when "known_hosts" is missing.
+	// +kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
+}
+
+// BuildSecret exposes a key from a Secret in the build namespace to RUN instructions using
+// "RUN --mount=type=secret,id=<id>". Secret values are never written into the build context or the image.
+type BuildSecret struct {
+	// Identifier used to reference the secret inside the Dockerfile.
+	// +kubebuilder:validation:MinLength=1
+	ID string `json:"id"`
+
+	// Name of the Secret in the build namespace.
+	// +kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
+
+	// Key inside the Secret whose value is exposed to the build.
+	// +kubebuilder:validation:MinLength=1
+	Key string `json:"key"`
+}
+
+// SSHAgent exposes private keys from a Secret in the build namespace as an ssh agent to RUN instructions using
+// "RUN --mount=type=ssh,id=<id>". Keys are held in memory and never written into the build context or the image.
+type SSHAgent struct {
+	// Identifier used to reference the agent inside the Dockerfile. Entries sharing an id are served by the same agent.
+	// Defaults to "default".
+	// +kubebuilder:validation:Optional
+	ID string `json:"id,omitempty"`
+
+	// Name of the Secret in the build namespace.
+	// +kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
+
+	// Key inside the Secret holding an unencrypted PEM private key. Defaults to "ssh-privatekey".
+	// +kubebuilder:validation:Optional
+	PrivateKeyKey string `json:"privateKeyKey,omitempty"`
+
+	// Optional key inside the Secret holding known_hosts entries. The value is exposed as a build secret with the id
+	// "<id>-known-hosts" so it can be mounted using "RUN --mount=type=secret,id=<id>-known-hosts,target=/root/.ssh/known_hosts".
+	// +kubebuilder:validation:Optional
+	KnownHostsKey string `json:"knownHostsKey,omitempty"`
+}
+
+// AgentID returns the identifier of the ssh agent serving this entry.
+func (a SSHAgent) AgentID() string {
+	if a.ID == "" {
+		return defaultSSHAgentID
+	}
+	return a.ID
+}
+
+// KnownHostsSecretID returns the build secret identifier used to expose known_hosts entries.
+func (a SSHAgent) KnownHostsSecretID() string {
+	return a.AgentID() + "-known-hosts"
+}
+
+// PrivateKey returns the Secret key holding the private key.
+func (a SSHAgent) PrivateKey() string {
+	if a.PrivateKeyKey == "" {
+		return corev1.SSHAuthPrivateKey
+	}
+	return a.PrivateKeyKey
+}
+
 // InitContainer specifies a container that will run before the build container.
 type InitContainer struct {
 	// Name of the init container.
@@ -102,16 +190,133 @@ type ContainerImageBuildSpec struct {
 	// +kubebuilder:validation:MinLength=1
 	ImageName string `json:"imageName"`
 
+	// Tags pushed to every registry in addition to any tag included in the image name. The image is built once and
+	// tagged before pushing. Tags may use Go template variables: {{ .Name }}, {{ .Namespace }}, {{ .Digest }},
+	// {{ .ShortDigest }}, {{ .GitCommit }}, {{ .ShortCommit }} and {{ .Timestamp }} (e.g. "sha-{{ .ShortCommit }}").
+	// {{ .GitCommit }} and {{ .ShortCommit }} require a git build context. Defaults to "latest" when neither the image
+	// name nor this list provide a tag.
+	// +kubebuilder:validation:Optional
+	Tags []string `json:"tags,omitempty"`
+
+	// Behavior when a tag already exists in a push registry. Use "overwrite" to replace existing tags, "failIfExists"
+	// to fail before building when a tag exists or "skipIfExists" to leave existing tags untouched. Builds are skipped
+	// entirely and report the existing digest when every tag exists. Tags that depend on the built image are checked
+	// after building. Defaults to "overwrite".
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=overwrite;failIfExists;skipIfExists
+	TagPolicy string `json:"tagPolicy,omitempty"`
+
 	// Build context for the image. This can be a local path or url.
-	Context string `json:"context"`
+	//
+	// Remote archives may be tarballs (optionally compressed with gzip, zstd, xz or bzip2) or zip files.
+	//
+	// Git repositories are supported using "git://", "git+https://", "git+ssh://" and "git+file://" URLs, scp-like ssh
+	// addresses (git@host:org/repo.git) and http(s) URLs ending with ".git". Append "#ref:subdir" to select a branch,
+	// tag or commit and a subdirectory used as the context root. The resolved commit is recorded in the status and
+	// added to the image as the "org.opencontainers.image.revision" label.
+	//
+	// OCI artifacts are supported using "oci://<registry>/<repository>[:tag|@digest]". The artifact must contain a single
+	// layer holding one of the archive formats above and is pulled using the credentials of the matching registry in
+	// "registries". Content is verified against the manifest and layer digests.
+	//
+	// Volumes are supported using "pvc://<claim>[/<subPath>]" and "configmap://<name>". They are mounted read-only into
+	// the build pod and used in place, unless preparer plugins or inline files need to modify the context.
+	//
+	// The context may be omitted when the build only requires an inline Dockerfile and inline files.
+	// +kubebuilder:validation:Optional
+	Context string `json:"context,omitempty"`
+
+	// Credentials used to download remote build context archives.
+	// +kubebuilder:validation:Optional
+	ContextAuth *ContextAuth `json:"contextAuth,omitempty"`
+
+	// Credentials used to fetch git repository build contexts.
+	// +kubebuilder:validation:Optional
+	GitAuth *GitAuth `json:"gitAuth,omitempty"`
 
 	// If the build context is a URL, the timeout in seconds for fetching. Defaults to 0, which disables the timeout.
 	// +kubebuilder:validation:Optional
 	ContextTimeoutSeconds uint16 `json:"contextTimeoutSeconds"`
 
-	// Push to one or more registries.
-	// +kubebuilder:validation:MinItems=1
-	PushRegistries []string `json:"pushTo"`
+	// Expected digest of a remote context archive in the format "algorithm:hex" (e.g. "sha256:..."). The downloaded
+	// archive is verified before extraction and the build fails when it does not match.
+	// +kubebuilder:validation:Optional
+	ContextDigest string `json:"contextDigest,omitempty"`
+
+	// Path to the Dockerfile relative to the root of the build context. Defaults to "Dockerfile".
+	// +kubebuilder:validation:Optional
+	DockerfilePath string `json:"dockerfilePath,omitempty"`
+
+	// Inline Dockerfile contents written to the Dockerfile path inside the build context, replacing any existing file.
+	// +kubebuilder:validation:Optional
+	Dockerfile string `json:"dockerfile,omitempty"`
+
+	// Inline files keyed by their path relative to the root of the build context. Files are written on top of a
+	// fetched context and replace existing files. The total size of inline contents is limited by the controller.
+	// +kubebuilder:validation:Optional
+	Files map[string]string `json:"files,omitempty"`
+
+	// Name of a ConfigMap in the build namespace whose keys are written as files at the root of the build context.
+	// Inline files take precedence over ConfigMap files with the same path.
+	// +kubebuilder:validation:Optional
+	FilesConfigMap string `json:"filesConfigMap,omitempty"`
+
+	// Additional named build contexts referenced by "FROM <name>" and "COPY --from=<name>" instructions, equivalent to
+	// the "--build-context" flag of "docker buildx build". Values may be any remote context supported by "context" or
+	// an image reference using "docker-image://<image>". Volume contexts are not supported.
+	//
+	// Named contexts require the "docker/dockerfile:1.4" frontend or newer, which is used unless another frontend is
+	// selected with the "BUILDKIT_SYNTAX" build arg.
+	// +kubebuilder:validation:Optional
+	AdditionalContexts map[string]string `json:"additionalContexts,omitempty"`
+
+	// Name of the build stage to target in a multi-stage Dockerfile. Defaults to the final stage.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z][a-zA-Z0-9_.-]*$`
+	Target string `json:"target,omitempty"`
+
+	// Additional "host:ip" mappings added to /etc/hosts during RUN instructions.
+	// +kubebuilder:validation:Optional
+	ExtraHosts []string `json:"extraHosts,omitempty"`
+
+	// Networking mode used by RUN instructions. Use "none" to disable network access during the build.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=default;none
+	NetworkMode string `json:"networkMode,omitempty"`
+
+	// Size of /dev/shm inside containers used by RUN instructions. Defaults to 64Mi.
+	// +kubebuilder:validation:Optional
+	ShmSize *resource.Quantity `json:"shmSize,omitempty"`
+
+	// Target platforms in the format "os/arch[/variant]" (e.g. linux/amd64, linux/arm64). When more than one platform
+	// is provided, an image index referencing every platform image is pushed. Defaults to the platform of the worker.
+	// +kubebuilder:validation:Optional
+	Platforms []string `json:"platforms,omitempty"`
+
+	// Image builder backend running the build. Use "embedded" to run buildkit inside the build pod or "remote" to run
+	// the build on the external buildkit daemon configured on the controller. Custom shm sizes are not supported by
+	// remote builds. Defaults to the backend of the controller.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=embedded;remote
+	Builder string `json:"builder,omitempty"`
+
+	// Push to one or more registries. Required unless pushing is disabled.
+	// +kubebuilder:validation:Optional
+	PushRegistries []string `json:"pushTo,omitempty"`
+
+	// Push the built image. Set to false to only build the image and validate its size, e.g. for pull request checks.
+	// The image size and digest are still reported but nothing is pushed and layer caches are not exported. Defaults
+	// to true.
+	// +kubebuilder:validation:Optional
+	Push *bool `json:"push,omitempty"`
+
+	// Behavior when the image cannot be pushed to one of the registries. Images are pushed to every registry in
+	// parallel and transient registry errors are retried. Use "failFast" to cancel the remaining pushes after the first
+	// failure or "bestEffort" to push to every registry regardless, which results in a "PartiallyPushed" state when
+	// only some pushes succeed. Defaults to "failFast".
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=failFast;bestEffort
+	PushFailurePolicy string `json:"pushFailurePolicy,omitempty"`
 
 	// Configure one or more registry hosts with special requirements.
 	// +kubebuilder:validation:Optional
@@ -121,6 +326,14 @@ type ContainerImageBuildSpec struct {
 	// +kubebuilder:validation:Optional
 	BuildArgs []string `json:"buildArgs"`
 
+	// Secrets exposed to RUN instructions. Use these instead of build arguments for sensitive values.
+	// +kubebuilder:validation:Optional
+	Secrets []BuildSecret `json:"secrets,omitempty"`
+
+	// SSH agents exposed to RUN instructions, e.g. for fetching dependencies from private git repositories.
+	// +kubebuilder:validation:Optional
+	SSH []SSHAgent `json:"ssh,omitempty"`
+
 	// Labels added to the image during build.
 	// +kubebuilder:validation:Optional
 	Labels map[string]string `json:"labels"`
@@ -137,12 +350,13 @@ type ContainerImageBuildSpec struct {
 	// +kubebuilder:validation:Optional
 	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
 
-	// Optional deadline in seconds for image build to complete.
+	// Optional deadline in seconds for image build to complete. This covers fetching the context, building and pushing
+	// the image. Builds that exceed the deadline are cancelled and transition into a "TimedOut" state.
 	// +kubebuilder:validation:Optional
 	TimeoutSeconds uint16 `json:"timeoutSeconds"`
 
-	// Prevents images larger than this size (in bytes) from being pushed to a registry. By default,
-	// an image of any size will be pushed.
+	// Prevents images larger than this size (in bytes) from being pushed to a registry. The limit applies to each
+	// platform image individually. By default, an image of any size will be pushed.
 	// +kubebuilder:validation:Optional
 	ImageSizeLimit uint64 `json:"imageSizeLimit"`
 
@@ -150,6 +364,11 @@ type ContainerImageBuildSpec struct {
 	// +kubebuilder:validation:Optional
 	PluginData map[string]string `json:"pluginData"`
 
+	// Configure the layer cache imported and exported by this build. Settings that are omitted default to the
+	// controller configuration.
+	// +kubebuilder:validation:Optional
+	Cache *BuildCache `json:"cache,omitempty"`
+
 	// Disable the use of layer caches during build.
 	// +kubebuilder:validation:Optional
 	DisableBuildCache bool `json:"disableBuildCache"`
@@ -171,15 +390,400 @@ type ContainerImageBuildSpec struct {
 	InitContainers []InitContainer `json:"initContainers"`
 }
 
+// BuildCache configures the registry layer cache of a build.
+type BuildCache struct {
+	// Import and export layer caches. Defaults to the "--enable-layer-caching" controller setting.
+	// +kubebuilder:validation:Optional
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Storage backend of the cache. "registry" stores caches as images in a registry, "local" stores them on a volume
+	// shared by build jobs and "s3" stores them in an S3-compatible bucket. The local and s3 backends must be
+	// configured on the controller. Defaults to the controller setting, which is "registry" unless configured
+	// otherwise.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=registry;local;s3
+	Backend string `json:"backend,omitempty"`
+
+	// Cache export mode. "min" only exports the layers of the final image, "max" also exports the layers of
+	// intermediate build stages and "inline" embeds the cache metadata into the pushed image instead of exporting a
+	// separate cache image. Defaults to the controller setting, which is "max" unless configured otherwise.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=min;max;inline
+	Mode string `json:"mode,omitempty"`
+
+	// Repository where caches are stored, e.g. "registry.example.com/cache/app". Defaults to the image repository in
+	// the first push registry. With the "inline" mode, cache keys refer to images in this repository. The local and s3
+	// backends keep the caches of every repository separate.
+	// +kubebuilder:validation:Optional
+	Repository string `json:"repository,omitempty"`
+
+	// Tag template of the cache that is imported and exported, e.g. "{{ .GitRef }}" to keep one cache per branch.
+	// Supports the tag variables that are known before building, i.e. every variable except the image digest.
+	// Defaults to "buildcache", or to the image tag with the "inline" mode.
+	// +kubebuilder:validation:Optional
+	Key string `json:"key,omitempty"`
+
+	// Tag templates of caches imported in order after the cache key, e.g. "main" so that new branches start from the
+	// cache of the main branch.
+	// +kubebuilder:validation:Optional
+	FallbackKeys []string `json:"fallbackKeys,omitempty"`
+
+	// Additional image references to import layer caches from, e.g. caches or images of other repositories.
+	// +kubebuilder:validation:Optional
+	ImportRefs []string `json:"importRefs,omitempty"`
+}
+
+// ssh agent id used by buildkit when a RUN instruction does not specify one
+const defaultSSHAgentID = "default"
+
+// Networking modes supported for RUN instructions.
+const (
+	NetworkModeDefault = "default"
+	NetworkModeNone    = "none"
+)
+
+// Policies applied when an image tag already exists in a registry.
+const (
+	TagPolicyOverwrite    = "overwrite"
+	TagPolicyFailIfExists = "failIfExists"
+	TagPolicySkipIfExists = "skipIfExists"
+)
+
+// Image builder backends.
+const (
+	BuilderEmbedded = "embedded"
+	BuilderRemote   = "remote"
+)
+
+// Policies applied when an image cannot be pushed to every registry.
+const (
+	PushFailurePolicyFailFast   = "failFast"
+	PushFailurePolicyBestEffort = "bestEffort"
+)
+
+// DockerImageContextScheme prefixes additional contexts that reference an image.
+const DockerImageContextScheme = "docker-image://"
+
+// additional context names are used as directory names and must be valid dockerfile stage names
+var namedContextPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// CacheModeInline embeds layer cache metadata into the built image.
+const CacheModeInline = "inline"
+
+const (
+	// CacheBackendRegistry stores layer caches in an image registry.
+	CacheBackendRegistry = "registry"
+	// CacheBackendLocal stores layer caches in a directory shared by build jobs.
+	CacheBackendLocal = "local"
+	// CacheBackendS3 stores layer caches in an S3-compatible bucket.
+	CacheBackendS3 = "s3"
+)
+
+func (c *BuildCache) validate(sample TagTemplateData) error {
+	if c == nil {
+		return nil
+	}
+
+	switch c.Mode {
+	case "", "min", "max", CacheModeInline:
+	default:
+		return fmt.Errorf("unsupported cache mode %q", c.Mode)
+	}
+
+	switch c.Backend {
+	case "", CacheBackendRegistry:
+	case CacheBackendLocal, CacheBackendS3:
+		if c.Mode == CacheModeInline {
+			return fmt.Errorf("the inline cache mode cannot be used with the %s cache backend", c.Backend)
+		}
+	default:
+		return fmt.Errorf("unsupported cache backend %q", c.Backend)
+	}
+
+	if c.Repository != "" {
+		named, err := reference.ParseNormalizedNamed(c.Repository)
+		if err != nil {
+			return fmt.Errorf("invalid cache repository %q: %v", c.Repository, err)
+		}
+		if !reference.IsNameOnly(named) {
+			return fmt.Errorf("cache repository %q cannot include a tag or digest", c.Repository)
+		}
+	}
+
+	keys := c.FallbackKeys
+	if c.Key != "" {
+		keys = append([]string{c.Key}, keys...)
+	}
+	for _, key := range keys {
+		if _, err := RenderTag(key, sample.CacheKeyData()); err != nil {
+			return fmt.Errorf("invalid cache key: %v", err)
+		}
+	}
+
+	for _, ref := range c.ImportRefs {
+		if _, err := reference.ParseNormalizedNamed(ref); err != nil {
+			return fmt.Errorf("invalid cache import reference %q: %v", ref, err)
+		}
+	}
+
+	return nil
+}
+
+// PushEnabled returns true unless pushing the built image was disabled.
+func (spec *ContainerImageBuildSpec) PushEnabled() bool {
+	return spec.Push == nil || *spec.Push
+}
+
+// Validate checks the build options that cannot be fully expressed using schema validation.
+func (spec *ContainerImageBuildSpec) Validate() error {
+	vc, err := ParseVolumeContext(spec.Context)
+	if err != nil {
+		return err
+	}
+
+	if spec.ContextDigest != "" {
+		if vc != nil {
+			return errors.New("context digests cannot be verified for volume build contexts")
+		}
+		if _, err := digest.Parse(spec.ContextDigest); err != nil {
+			return fmt.Errorf("invalid context digest %q: %v", spec.ContextDigest, err)
+		}
+	}
+
+	if spec.Context == "" && spec.Dockerfile == "" && len(spec.Files) == 0 && spec.FilesConfigMap == "" {
+		return errors.New("a build context, inline dockerfile or inline files are required")
+	}
+
+	if p := spec.DockerfilePath; p != "" {
+		if err := validateContextFilePath("dockerfile", p); err != nil {
+			return err
+		}
+	}
+	for p := range spec.Files {
+		if err := validateContextFilePath("inline file", p); err != nil {
+			return err
+		}
+	}
+
+	for name, source := range spec.AdditionalContexts {
+		if !namedContextPattern.MatchString(name) {
+			return fmt.Errorf("additional context name %q must consist of lowercase alphanumeric characters, '-', '_' or '.'", name)
+		}
+		if strings.TrimPrefix(source, DockerImageContextScheme) == "" {
+			return fmt.Errorf("additional context %q requires a source", name)
+		}
+		if vc, err := ParseVolumeContext(source); err != nil || vc != nil {
+			return fmt.Errorf("additional context %q cannot use a volume", name)
+		}
+	}
+
+	// render tag templates with placeholder values to catch syntax errors and unknown variables early
+	sample := NewTagTemplateData("build", "default", digest.Canonical.FromString("").String(), "main", strings.Repeat("0", 40), time.Unix(0, 0))
+	for _, tag := range spec.Tags {
+		if _, err := RenderTag(tag, sample); err != nil {
+			return err
+		}
+		// contexts of other kinds are checked once they are fetched
+		if (spec.Context == "" || vc != nil) && TagUsesGitCommit(tag) {
+			return fmt.Errorf("tag template %q uses the git commit but the build context is not a git repository", tag)
+		}
+	}
+
+	if err := spec.Cache.validate(sample); err != nil {
+		return err
+	}
+
+	for _, host := range spec.ExtraHosts {
+		if _, _, err := ParseExtraHost(host); err != nil {
+			return err
+		}
+	}
+
+	switch spec.NetworkMode {
+	case "", NetworkModeDefault, NetworkModeNone:
+	default:
+		return fmt.Errorf("unsupported network mode %q", spec.NetworkMode)
+	}
+
+	if spec.PushEnabled() && len(spec.PushRegistries) == 0 {
+		return errors.New("at least one push registry is required unless pushing is disabled")
+	}
+
+	switch spec.TagPolicy {
+	case "", TagPolicyOverwrite, TagPolicyFailIfExists, TagPolicySkipIfExists:
+	default:
+		return fmt.Errorf("unsupported tag policy %q", spec.TagPolicy)
+	}
+
+	switch spec.Builder {
+	case "", BuilderEmbedded, BuilderRemote:
+	default:
+		return fmt.Errorf("unsupported builder %q", spec.Builder)
+	}
+	if spec.Builder == BuilderRemote && spec.ShmSize != nil {
+		return errors.New("shm size is not supported by remote builds")
+	}
+
+	switch spec.PushFailurePolicy {
+	case "", PushFailurePolicyFailFast, PushFailurePolicyBestEffort:
+	default:
+		return fmt.Errorf("unsupported push failure policy %q", spec.PushFailurePolicy)
+	}
+
+	secretIDs := map[string]bool{}
+	for _, secret := range spec.Secrets {
+		if secret.ID == "" || secret.SecretName == "" || secret.Key == "" {
+			return errors.New("build secrets require an id, secret name and key")
+		}
+		if secretIDs[secret.ID] {
+			return fmt.Errorf("build secret id %q is specified more than once", secret.ID)
+		}
+		secretIDs[secret.ID] = true
+	}
+
+	for _, ssh := range spec.SSH {
+		if ssh.SecretName == "" {
+			return fmt.Errorf("ssh agent %q requires a secret name", ssh.AgentID())
+		}
+		if ssh.KnownHostsKey != "" && secretIDs[ssh.KnownHostsSecretID()] {
+			return fmt.Errorf("build secret id %q conflicts with the known hosts of ssh agent %q", ssh.KnownHostsSecretID(), ssh.AgentID())
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, platform := range spec.Platforms {
+		parts := strings.Split(platform, "/")
+		if len(parts) < 2 || len(parts) > 3 {
+			return fmt.Errorf("platform %q must use the format os/arch[/variant]", platform)
+		}
+		for _, part := range parts {
+			if part == "" {
+				return fmt.Errorf("platform %q must use the format os/arch[/variant]", platform)
+			}
+		}
+		if seen[platform] {
+			return fmt.Errorf("platform %q is specified more than once", platform)
+		}
+		seen[platform] = true
+	}
+
+	if spec.ShmSize != nil && spec.ShmSize.Sign() <= 0 {
+		return fmt.Errorf("shm size must be greater than zero, got %s", spec.ShmSize)
+	}
+
+	return nil
+}
+
+// ensures a path references a file inside of the build context
+func validateContextFilePath(kind, p string) error {
+	clean := path.Clean(p)
+	if path.IsAbs(p) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("%s path %q must be relative to the build context", kind, p)
+	}
+	if clean == "." || strings.HasSuffix(p, "/") {
+		return fmt.Errorf("%s path %q must reference a file", kind, p)
+	}
+	return nil
+}
+
+// InlineContextSize returns the number of bytes occupied by the inline Dockerfile and inline files.
+func (spec *ContainerImageBuildSpec) InlineContextSize() int {
+	size := len(spec.Dockerfile)
+	for p, contents := range spec.Files {
+		size += len(p) + len(contents)
+	}
+	return size
+}
+
+// ParseExtraHost splits a "host:ip" mapping into its hostname and address.
+func ParseExtraHost(entry string) (string, net.IP, error) {
+	parts := strings.SplitN(entry, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", nil, fmt.Errorf("extra host %q must use the format host:ip", entry)
+	}
+
+	ip := net.ParseIP(parts[1])
+	if ip == nil {
+		return "", nil, fmt.Errorf("extra host %q contains an invalid ip address", entry)
+	}
+
+	return parts[0], ip, nil
+}
+
+// PlatformImage describes the image built for a single platform.
+type PlatformImage struct {
+	// Platform in the format "os/arch[/variant]".
+	Platform string `json:"platform"`
+
+	// Digest of the platform-specific image manifest.
+	Digest string `json:"digest"`
+
+	// Digest of the platform-specific image config.
+	ConfigDigest string `json:"configDigest,omitempty"`
+
+	// Size of the platform image in bytes.
+	Size uint64 `json:"size"`
+}
+
+// AdditionalContextStatus records what an additional named context was built from. Contexts referencing images are
+// resolved by the frontend and are not recorded.
+type AdditionalContextStatus struct {
+	// Name of the context.
+	Name string `json:"name"`
+
+	// Digest of the fetched archive or OCI artifact.
+	Digest string `json:"digest,omitempty"`
+
+	// Commit checked out for git repository contexts.
+	GitCommit string `json:"gitCommit,omitempty"`
+}
+
+// PushState is the outcome of pushing an image to a registry.
+type PushState string
+
+const (
+	PushStatePushed  PushState = "Pushed"
+	PushStateFailed  PushState = "Failed"
+	PushStateSkipped PushState = "Skipped"
+)
+
+// RegistryPush describes the outcome of pushing the built image to a single registry.
+type RegistryPush struct {
+	// Registry host the image was pushed to.
+	Registry string `json:"registry"`
+
+	// Tagged image URL.
+	ImageURL string `json:"imageURL"`
+
+	// Image reference pinned to the manifest digest in the format "name@digest".
+	Reference string `json:"reference,omitempty"`
+
+	// Outcome of the push.
+	State PushState `json:"state"`
+
+	// Error returned when the push failed.
+	Error string `json:"error,omitempty"`
+}
+
 // ContainerImageBuildStatus defines the observed state of ContainerImageBuild
 type ContainerImageBuildStatus struct {
-	PreviousState    BuildState   `json:"-"` // NOTE: should we persist this value?
-	State            BuildState   `json:"state,omitempty"`
-	ImageURLs        []string     `json:"imageURLs,omitempty"`
-	ImageSize        uint64       `json:"imageSize,omitempty"`
-	ErrorMessage     string       `json:"errorMessage,omitempty"`
-	BuildStartedAt   *metav1.Time `json:"buildStartedAt,omitempty"`
-	BuildCompletedAt *metav1.Time `json:"buildCompletedAt,omitempty"`
+	PreviousState      BuildState                `json:"-"` // NOTE: should we persist this value?
+	State              BuildState                `json:"state,omitempty"`
+	ImageURLs          []string                  `json:"imageURLs,omitempty"`
+	ImageReferences    []string                  `json:"imageReferences,omitempty"`
+	ImageDigest        string                    `json:"imageDigest,omitempty"`
+	ConfigDigest       string                    `json:"configDigest,omitempty"`
+	ImageSize          uint64                    `json:"imageSize,omitempty"`
+	Platforms          []PlatformImage           `json:"platforms,omitempty"`
+	Pushes             []RegistryPush            `json:"pushes,omitempty"`
+	GitCommit          string                    `json:"gitCommit,omitempty"`
+	ContextDigest      string                    `json:"contextDigest,omitempty"`
+	AdditionalContexts []AdditionalContextStatus `json:"additionalContexts,omitempty"`
+	ErrorMessage       string                    `json:"errorMessage,omitempty"`
+	Reason             string                    `json:"reason,omitempty"`
+	ExitCode           int32                     `json:"exitCode,omitempty"`
+	BuildStartedAt     *metav1.Time              `json:"buildStartedAt,omitempty"`
+	BuildCompletedAt   *metav1.Time              `json:"buildCompletedAt,omitempty"`
 }
 
 // SetStatus will set a new build state and preserve the previous state in a transient field.
@@ -201,7 +805,9 @@ func (s *ContainerImageBuildStatus) SetState(state BuildState) {
 // +kubebuilder:printcolumn:name="Image Name",type="string",JSONPath=".spec.imageName"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 // +kubebuilder:printcolumn:name="State",type="string",JSONPath=".status.state"
+// +kubebuilder:printcolumn:name="Reason",type="string",priority=1,JSONPath=".status.reason"
 // +kubebuilder:printcolumn:name="Image URLs",type="string",priority=1,JSONPath=".status.imageURLs"
+// +kubebuilder:printcolumn:name="Image Digest",type="string",priority=1,JSONPath=".status.imageDigest"
 
 // ContainerImageBuild is the Schema for the containerimagebuilds API
 type ContainerImageBuild struct {
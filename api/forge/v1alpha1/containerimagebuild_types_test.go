@@ -5,6 +5,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/pointer"
 )
 
 func TestBasicAuthConfig_IsInline(t *testing.T) {
@@ -102,3 +104,141 @@ func TestBasicAuthConfig_Validate(t *testing.T) {
 		assert.Equal(t, tc.err, tc.cfg.Validate())
 	}
 }
+
+func TestContainerImageBuildSpec_Validate(t *testing.T) {
+	negative := resource.MustParse("-1")
+	shmSize := resource.MustParse("1Gi")
+
+	tests := []struct {
+		name  string
+		spec  ContainerImageBuildSpec
+		valid bool
+	}{
+		{"context_only", ContainerImageBuildSpec{}, true},
+		{"dockerfile_nested", ContainerImageBuildSpec{DockerfilePath: "services/api/Dockerfile.prod"}, true},
+		{"dockerfile_absolute", ContainerImageBuildSpec{DockerfilePath: "/etc/Dockerfile"}, false},
+		{"dockerfile_traversal", ContainerImageBuildSpec{DockerfilePath: "../Dockerfile"}, false},
+		{"dockerfile_traversal_nested", ContainerImageBuildSpec{DockerfilePath: "services/../../Dockerfile"}, false},
+		{"dockerfile_parent", ContainerImageBuildSpec{DockerfilePath: "services/../.."}, false},
+		{"dockerfile_dot_prefixed", ContainerImageBuildSpec{DockerfilePath: "..Dockerfile"}, true},
+		{"dockerfile_dot_prefixed_directory", ContainerImageBuildSpec{DockerfilePath: "..hidden/Dockerfile"}, true},
+		{"dockerfile_directory", ContainerImageBuildSpec{DockerfilePath: "services/"}, false},
+		{"extra_hosts", ContainerImageBuildSpec{ExtraHosts: []string{"db:10.0.0.1", "ipv6:::1"}}, true},
+		{"extra_hosts_missing_ip", ContainerImageBuildSpec{ExtraHosts: []string{"db"}}, false},
+		{"extra_hosts_invalid_ip", ContainerImageBuildSpec{ExtraHosts: []string{"db:nope"}}, false},
+		{"network_none", ContainerImageBuildSpec{NetworkMode: NetworkModeNone}, true},
+		{"network_host", ContainerImageBuildSpec{NetworkMode: "host"}, false},
+		{"tags", ContainerImageBuildSpec{Tags: []string{"1.4.2", "sha-{{ .ShortCommit }}"}}, true},
+		{"tags_unknown_variable", ContainerImageBuildSpec{Tags: []string{"{{ .Branch }}"}}, false},
+		{"tags_invalid", ContainerImageBuildSpec{Tags: []string{"v1/beta"}}, false},
+		{"cache", ContainerImageBuildSpec{Cache: &BuildCache{Mode: "inline", Repository: "registry.io/cache/app", Key: "{{ .GitRef }}", FallbackKeys: []string{"main"}, ImportRefs: []string{"registry.io/org/base:buildcache"}}}, true},
+		{"cache_invalid_mode", ContainerImageBuildSpec{Cache: &BuildCache{Mode: "all"}}, false},
+		{"cache_backend", ContainerImageBuildSpec{Cache: &BuildCache{Backend: "s3", Mode: "max", Key: "{{ .GitRef }}"}}, true},
+		{"cache_invalid_backend", ContainerImageBuildSpec{Cache: &BuildCache{Backend: "gcs"}}, false},
+		{"cache_inline_local", ContainerImageBuildSpec{Cache: &BuildCache{Backend: "local", Mode: "inline"}}, false},
+		{"cache_tagged_repository", ContainerImageBuildSpec{Cache: &BuildCache{Repository: "registry.io/cache/app:latest"}}, false},
+		{"cache_key_digest", ContainerImageBuildSpec{Cache: &BuildCache{Key: "{{ .ShortDigest }}"}}, false},
+		{"cache_invalid_import", ContainerImageBuildSpec{Cache: &BuildCache{ImportRefs: []string{"Invalid Ref"}}}, false},
+		{"tag_policy", ContainerImageBuildSpec{TagPolicy: TagPolicySkipIfExists}, true},
+		{"tag_policy_unknown", ContainerImageBuildSpec{TagPolicy: "immutable"}, false},
+		{"builder_remote", ContainerImageBuildSpec{Builder: BuilderRemote}, true},
+		{"builder_unknown", ContainerImageBuildSpec{Builder: "kaniko"}, false},
+		{"build_only", ContainerImageBuildSpec{PushRegistries: []string{}, Push: pointer.BoolPtr(false)}, true},
+		{"push_registries_missing", ContainerImageBuildSpec{PushRegistries: []string{}}, false},
+		{"push_best_effort", ContainerImageBuildSpec{PushFailurePolicy: PushFailurePolicyBestEffort}, true},
+		{"push_policy_unknown", ContainerImageBuildSpec{PushFailurePolicy: "retry"}, false},
+		{"secrets", ContainerImageBuildSpec{Secrets: []BuildSecret{{ID: "npmrc", SecretName: "tokens", Key: "npm"}}}, true},
+		{"secrets_missing_key", ContainerImageBuildSpec{Secrets: []BuildSecret{{ID: "npmrc", SecretName: "tokens"}}}, false},
+		{"secrets_duplicate_id", ContainerImageBuildSpec{Secrets: []BuildSecret{
+			{ID: "npmrc", SecretName: "tokens", Key: "npm"},
+			{ID: "npmrc", SecretName: "other", Key: "npm"},
+		}}, false},
+		{"ssh", ContainerImageBuildSpec{SSH: []SSHAgent{{SecretName: "deploy-key", KnownHostsKey: "known_hosts"}}}, true},
+		{"ssh_missing_secret", ContainerImageBuildSpec{SSH: []SSHAgent{{ID: "github"}}}, false},
+		{"ssh_known_hosts_conflict", ContainerImageBuildSpec{
+			Secrets: []BuildSecret{{ID: "default-known-hosts", SecretName: "tokens", Key: "hosts"}},
+			SSH:     []SSHAgent{{SecretName: "deploy-key", KnownHostsKey: "known_hosts"}},
+		}, false},
+		{"platforms", ContainerImageBuildSpec{Platforms: []string{"linux/amd64", "linux/arm/v7"}}, true},
+		{"platforms_missing_arch", ContainerImageBuildSpec{Platforms: []string{"linux"}}, false},
+		{"platforms_empty_part", ContainerImageBuildSpec{Platforms: []string{"linux//v7"}}, false},
+		{"platforms_duplicate", ContainerImageBuildSpec{Platforms: []string{"linux/amd64", "linux/amd64"}}, false},
+		{"shm_size", ContainerImageBuildSpec{ShmSize: &shmSize}, true},
+		{"shm_size_negative", ContainerImageBuildSpec{ShmSize: &negative}, false},
+		{"shm_size_remote", ContainerImageBuildSpec{Builder: BuilderRemote, ShmSize: &shmSize}, false},
+		{"context_digest", ContainerImageBuildSpec{ContextDigest: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}, true},
+		{"context_digest_malformed", ContainerImageBuildSpec{ContextDigest: "sha256:nope"}, false},
+		{"context_digest_algorithm", ContainerImageBuildSpec{ContextDigest: "md5:d41d8cd98f00b204e9800998ecf8427e"}, false},
+		{"inline_files", ContainerImageBuildSpec{Files: map[string]string{"conf/app.yaml": "key: value"}}, true},
+		{"inline_files_absolute", ContainerImageBuildSpec{Files: map[string]string{"/etc/passwd": ""}}, false},
+		{"inline_files_traversal", ContainerImageBuildSpec{Files: map[string]string{"../app.yaml": ""}}, false},
+		{"inline_files_traversal_nested", ContainerImageBuildSpec{Files: map[string]string{"conf/../../app.yaml": ""}}, false},
+		{"inline_files_dot_prefixed", ContainerImageBuildSpec{Files: map[string]string{"..app.yaml": ""}}, true},
+		{"inline_files_dot_prefixed_directory", ContainerImageBuildSpec{Files: map[string]string{"..hidden/app.yaml": ""}}, true},
+		{"additional_contexts", ContainerImageBuildSpec{AdditionalContexts: map[string]string{
+			"base":   "docker-image://alpine:3.15",
+			"shared": "https://github.com/org/shared.git#main:lib",
+			"assets": "oci://registry.example.com/assets@sha256:abc",
+		}}, true},
+		{"additional_contexts_name", ContainerImageBuildSpec{AdditionalContexts: map[string]string{"../base": "docker-image://alpine"}}, false},
+		{"additional_contexts_uppercase", ContainerImageBuildSpec{AdditionalContexts: map[string]string{"Base": "docker-image://alpine"}}, false},
+		{"additional_contexts_empty", ContainerImageBuildSpec{AdditionalContexts: map[string]string{"base": "docker-image://"}}, false},
+		{"additional_contexts_volume", ContainerImageBuildSpec{AdditionalContexts: map[string]string{"shared": "pvc://claim"}}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// cases exercise individual fields and require a context and push registry to be valid otherwise
+			spec := tc.spec
+			spec.Context = "https://example.com/context.tgz"
+			if spec.PushRegistries == nil {
+				spec.PushRegistries = []string{"registry.io"}
+			}
+
+			err := spec.Validate()
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestContainerImageBuildSpec_ValidateContextSource(t *testing.T) {
+	tests := []struct {
+		name  string
+		spec  ContainerImageBuildSpec
+		valid bool
+	}{
+		{"empty", ContainerImageBuildSpec{}, false},
+		{"context", ContainerImageBuildSpec{Context: "https://example.com/context.tgz"}, true},
+		{"inline_dockerfile", ContainerImageBuildSpec{Dockerfile: "FROM scratch"}, true},
+		{"inline_files", ContainerImageBuildSpec{Files: map[string]string{"Dockerfile": "FROM scratch"}}, true},
+		{"files_configmap", ContainerImageBuildSpec{FilesConfigMap: "build-files"}, true},
+		{"inline_commit_tags", ContainerImageBuildSpec{Dockerfile: "FROM scratch", Tags: []string{"sha-{{ .ShortCommit }}"}}, false},
+		{"inline_tags", ContainerImageBuildSpec{Dockerfile: "FROM scratch", Tags: []string{"{{ .Name }}-{{ .Timestamp }}"}}, true},
+		{"volume_commit_tags", ContainerImageBuildSpec{Context: "pvc://claim", Tags: []string{"{{ .GitCommit }}"}}, false},
+		{"git_commit_tags", ContainerImageBuildSpec{Context: "git+https://example.com/app.git", Tags: []string{"{{ .GitCommit }}"}}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := tc.spec
+			spec.PushRegistries = []string{"registry.io"}
+
+			err := spec.Validate()
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestContainerImageBuildSpec_InlineContextSize(t *testing.T) {
+	spec := ContainerImageBuildSpec{
+		Dockerfile: "FROM scratch",
+		Files:      map[string]string{"a.txt": "hello"},
+	}
+	assert.Equal(t, len("FROM scratch")+len("a.txt")+len("hello"), spec.InlineContextSize())
+}
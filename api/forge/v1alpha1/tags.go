@@ -0,0 +1,115 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// tags must be valid reference tags once rendered
+var tagPattern = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+
+var invalidTagChars = regexp.MustCompile(`[^\w.-]`)
+
+// TagTemplateData holds the variables available to image tag templates.
+type TagTemplateData struct {
+	// Name of the ContainerImageBuild.
+	Name string
+	// Namespace of the ContainerImageBuild.
+	Namespace string
+	// Hex-encoded manifest digest of the built image.
+	Digest string
+	// First 12 characters of the manifest digest.
+	ShortDigest string
+	// Branch or tag of a git build context with characters that are invalid in tags replaced by "-".
+	GitRef string
+	// Commit of a git build context.
+	GitCommit string
+	// First 7 characters of the git commit.
+	ShortCommit string
+	// Build start time in UTC using the format "20060102150405".
+	Timestamp string
+}
+
+// NewTagTemplateData returns the tag template variables of a build.
+func NewTagTemplateData(name, namespace, digest, gitRef, gitCommit string, startedAt time.Time) TagTemplateData {
+	hex := digest
+	if idx := strings.Index(digest, ":"); idx != -1 {
+		hex = digest[idx+1:]
+	}
+
+	return TagTemplateData{
+		Name:        name,
+		Namespace:   namespace,
+		Digest:      hex,
+		ShortDigest: truncate(hex, 12),
+		GitRef:      truncate(invalidTagChars.ReplaceAllString(gitRef, "-"), 128),
+		GitCommit:   gitCommit,
+		ShortCommit: truncate(gitCommit, 7),
+		Timestamp:   startedAt.UTC().Format("20060102150405"),
+	}
+}
+
+// cacheKeyData omits the variables that depend on the built image.
+type cacheKeyData struct {
+	Name        string
+	Namespace   string
+	GitRef      string
+	GitCommit   string
+	ShortCommit string
+	Timestamp   string
+}
+
+// CacheKeyData returns the variables available to cache key templates, which are rendered before building.
+func (d TagTemplateData) CacheKeyData() interface{} {
+	return cacheKeyData{
+		Name:        d.Name,
+		Namespace:   d.Namespace,
+		GitRef:      d.GitRef,
+		GitCommit:   d.GitCommit,
+		ShortCommit: d.ShortCommit,
+		Timestamp:   d.Timestamp,
+	}
+}
+
+// RenderTag executes a tag template, e.g. "sha-{{ .ShortCommit }}", and ensures the result is a valid image tag.
+func RenderTag(text string, data interface{}) (string, error) {
+	tmpl, err := template.New("tag").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid tag template %q: %v", text, err)
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("cannot render tag template %q: %v", text, err)
+	}
+
+	tag := sb.String()
+	if !tagPattern.MatchString(tag) {
+		return "", fmt.Errorf("tag template %q renders invalid tag %q", text, tag)
+	}
+	return tag, nil
+}
+
+// TagUsesGitCommit returns true when a tag template renders the git commit, which only exists for git build contexts.
+func TagUsesGitCommit(text string) bool {
+	render := func(commit string) string {
+		tag, err := RenderTag(text, NewTagTemplateData("build", "default", "", "main", commit, time.Unix(0, 0)))
+		if err != nil {
+			return "\x00" + err.Error()
+		}
+		return tag
+	}
+
+	withoutCommit := render("")
+	return render(strings.Repeat("0", 40)) != withoutCommit || render(strings.Repeat("1", 40)) != withoutCommit
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
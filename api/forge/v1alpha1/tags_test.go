@@ -0,0 +1,65 @@
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderTag(t *testing.T) {
+	data := NewTagTemplateData(
+		"build-1",
+		"ml",
+		"sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"feature/cache-keys",
+		"3f786850e387550fdab836ed7e6dc881de23001b",
+		time.Date(2021, 11, 2, 15, 4, 5, 0, time.UTC),
+	)
+
+	tests := []struct {
+		template string
+		expected string
+		valid    bool
+	}{
+		{"1.4.2", "1.4.2", true},
+		{"sha-{{ .ShortCommit }}", "sha-3f78685", true},
+		{"{{ .Namespace }}-{{ .Name }}", "ml-build-1", true},
+		{"{{ .ShortDigest }}", "e3b0c44298fc", true},
+		{"build-{{ .Timestamp }}", "build-20211102150405", true},
+		{"{{ .GitRef }}", "feature-cache-keys", true},
+		{"{{ .Branch }}", "", false},
+		{"{{ .Name", "", false},
+		{"-invalid", "", false},
+		{"has/slash", "", false},
+	}
+	for _, tc := range tests {
+		actual, err := RenderTag(tc.template, data)
+		if tc.valid {
+			assert.NoError(t, err, tc.template)
+			assert.Equal(t, tc.expected, actual)
+		} else {
+			assert.Error(t, err, tc.template)
+		}
+	}
+}
+
+func TestRenderTag_cacheKey(t *testing.T) {
+	data := NewTagTemplateData("build-1", "ml", "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", "main", "3f786850e387550fdab836ed7e6dc881de23001b", time.Now())
+
+	key, err := RenderTag("{{ .GitRef }}-{{ .ShortCommit }}", data.CacheKeyData())
+	assert.NoError(t, err)
+	assert.Equal(t, "main-3f78685", key)
+
+	_, err = RenderTag("{{ .ShortDigest }}", data.CacheKeyData())
+	assert.Error(t, err)
+}
+
+func TestTagUsesGitCommit(t *testing.T) {
+	assert.True(t, TagUsesGitCommit("sha-{{ .ShortCommit }}"))
+	assert.True(t, TagUsesGitCommit("{{ .GitCommit }}"))
+	assert.True(t, TagUsesGitCommit(`{{ if .GitCommit }}git{{ else }}other{{ end }}`))
+	assert.False(t, TagUsesGitCommit("{{ .GitRef }}-{{ .Timestamp }}"))
+	assert.False(t, TagUsesGitCommit("1.4.2"))
+	assert.False(t, TagUsesGitCommit("{{ .Branch }}"))
+}
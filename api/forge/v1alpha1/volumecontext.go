@@ -0,0 +1,62 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// VolumeContextKind identifies the type of volume serving a build context.
+type VolumeContextKind string
+
+const (
+	// VolumeContextPVC serves the build context from a PersistentVolumeClaim using "pvc://<claim>[/<subPath>]".
+	VolumeContextPVC VolumeContextKind = "pvc"
+
+	// VolumeContextConfigMap serves the build context from the keys of a ConfigMap using "configmap://<name>".
+	VolumeContextConfigMap VolumeContextKind = "configmap"
+)
+
+// VolumeContext is a build context that is mounted into the build pod instead of being downloaded.
+type VolumeContext struct {
+	Kind    VolumeContextKind
+	Name    string
+	SubPath string
+}
+
+// ParseVolumeContext parses a build context that references a volume. A nil result is returned when the context does
+// not reference a volume.
+func ParseVolumeContext(context string) (*VolumeContext, error) {
+	var kind VolumeContextKind
+	for _, k := range []VolumeContextKind{VolumeContextPVC, VolumeContextConfigMap} {
+		if strings.HasPrefix(context, string(k)+"://") {
+			kind = k
+		}
+	}
+	if kind == "" {
+		return nil, nil
+	}
+
+	ref := strings.TrimPrefix(context, string(kind)+"://")
+	name, subPath := ref, ""
+	if idx := strings.Index(ref, "/"); idx != -1 {
+		name, subPath = ref[:idx], strings.Trim(ref[idx+1:], "/")
+	}
+
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) != 0 {
+		return nil, fmt.Errorf("invalid %s name %q in build context: %s", kind, name, strings.Join(errs, ", "))
+	}
+	if subPath != "" {
+		if kind != VolumeContextPVC {
+			return nil, fmt.Errorf("sub paths are not supported for %s build contexts", kind)
+		}
+		if clean := path.Clean(subPath); clean == ".." || strings.HasPrefix(clean, "../") {
+			return nil, fmt.Errorf("build context sub path %q must not traverse outside of the volume", subPath)
+		}
+		subPath = path.Clean(subPath)
+	}
+
+	return &VolumeContext{Kind: kind, Name: name, SubPath: subPath}, nil
+}
@@ -0,0 +1,36 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVolumeContext(t *testing.T) {
+	tests := []struct {
+		context  string
+		expected *VolumeContext
+		err      bool
+	}{
+		{context: "https://example.com/context.tgz"},
+		{context: "git@github.com:org/repo.git"},
+		{context: "pvc://build-context", expected: &VolumeContext{Kind: VolumeContextPVC, Name: "build-context"}},
+		{context: "pvc://build-context/steps/one/", expected: &VolumeContext{Kind: VolumeContextPVC, Name: "build-context", SubPath: "steps/one"}},
+		{context: "pvc://build-context/../other", err: true},
+		{context: "pvc://Invalid_Name", err: true},
+		{context: "pvc://", err: true},
+		{context: "configmap://env-files", expected: &VolumeContext{Kind: VolumeContextConfigMap, Name: "env-files"}},
+		{context: "configmap://env-files/nested", err: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.context, func(t *testing.T) {
+			actual, err := ParseVolumeContext(tc.context)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,12 @@
+package v1alpha1
+
+const (
+	// WorkerLabel is added to builds dispatched to a long-running worker and holds the name of the worker pod.
+	WorkerLabel = "forge.dominodatalab.com/worker"
+
+	// WorkerDispatchedAtAnnotation is added to builds dispatched to a worker and holds the RFC 3339 dispatch time.
+	WorkerDispatchedAtAnnotation = "forge.dominodatalab.com/worker-dispatched-at"
+
+	// WorkerConcurrencyAnnotation is added to worker pods and holds the number of builds they run at once.
+	WorkerConcurrencyAnnotation = "forge.dominodatalab.com/worker-concurrency"
+)
@@ -10,6 +10,21 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AdditionalContextStatus) DeepCopyInto(out *AdditionalContextStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdditionalContextStatus.
+func (in *AdditionalContextStatus) DeepCopy() *AdditionalContextStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AdditionalContextStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BasicAuthConfig) DeepCopyInto(out *BasicAuthConfig) {
 	*out = *in
@@ -25,6 +40,51 @@ func (in *BasicAuthConfig) DeepCopy() *BasicAuthConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildCache) DeepCopyInto(out *BuildCache) {
+	*out = *in
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.FallbackKeys != nil {
+		in, out := &in.FallbackKeys, &out.FallbackKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ImportRefs != nil {
+		in, out := &in.ImportRefs, &out.ImportRefs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildCache.
+func (in *BuildCache) DeepCopy() *BuildCache {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildCache)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildSecret) DeepCopyInto(out *BuildSecret) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildSecret.
+func (in *BuildSecret) DeepCopy() *BuildSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ContainerImageBuild) DeepCopyInto(out *ContainerImageBuild) {
 	*out = *in
@@ -87,11 +147,60 @@ func (in *ContainerImageBuildList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ContainerImageBuildSpec) DeepCopyInto(out *ContainerImageBuildSpec) {
 	*out = *in
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ContextAuth != nil {
+		in, out := &in.ContextAuth, &out.ContextAuth
+		*out = new(ContextAuth)
+		**out = **in
+	}
+	if in.GitAuth != nil {
+		in, out := &in.GitAuth, &out.GitAuth
+		*out = new(GitAuth)
+		**out = **in
+	}
+	if in.Files != nil {
+		in, out := &in.Files, &out.Files
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.AdditionalContexts != nil {
+		in, out := &in.AdditionalContexts, &out.AdditionalContexts
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ExtraHosts != nil {
+		in, out := &in.ExtraHosts, &out.ExtraHosts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ShmSize != nil {
+		in, out := &in.ShmSize, &out.ShmSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Platforms != nil {
+		in, out := &in.Platforms, &out.Platforms
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	if in.PushRegistries != nil {
 		in, out := &in.PushRegistries, &out.PushRegistries
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Push != nil {
+		in, out := &in.Push, &out.Push
+		*out = new(bool)
+		**out = **in
+	}
 	if in.Registries != nil {
 		in, out := &in.Registries, &out.Registries
 		*out = make([]Registry, len(*in))
@@ -102,6 +211,16 @@ func (in *ContainerImageBuildSpec) DeepCopyInto(out *ContainerImageBuildSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Secrets != nil {
+		in, out := &in.Secrets, &out.Secrets
+		*out = make([]BuildSecret, len(*in))
+		copy(*out, *in)
+	}
+	if in.SSH != nil {
+		in, out := &in.SSH, &out.SSH
+		*out = make([]SSHAgent, len(*in))
+		copy(*out, *in)
+	}
 	if in.Labels != nil {
 		in, out := &in.Labels, &out.Labels
 		*out = make(map[string]string, len(*in))
@@ -117,6 +236,11 @@ func (in *ContainerImageBuildSpec) DeepCopyInto(out *ContainerImageBuildSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.Cache != nil {
+		in, out := &in.Cache, &out.Cache
+		*out = new(BuildCache)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.InitContainers != nil {
 		in, out := &in.InitContainers, &out.InitContainers
 		*out = make([]InitContainer, len(*in))
@@ -144,6 +268,26 @@ func (in *ContainerImageBuildStatus) DeepCopyInto(out *ContainerImageBuildStatus
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.ImageReferences != nil {
+		in, out := &in.ImageReferences, &out.ImageReferences
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Platforms != nil {
+		in, out := &in.Platforms, &out.Platforms
+		*out = make([]PlatformImage, len(*in))
+		copy(*out, *in)
+	}
+	if in.Pushes != nil {
+		in, out := &in.Pushes, &out.Pushes
+		*out = make([]RegistryPush, len(*in))
+		copy(*out, *in)
+	}
+	if in.AdditionalContexts != nil {
+		in, out := &in.AdditionalContexts, &out.AdditionalContexts
+		*out = make([]AdditionalContextStatus, len(*in))
+		copy(*out, *in)
+	}
 	if in.BuildStartedAt != nil {
 		in, out := &in.BuildStartedAt, &out.BuildStartedAt
 		*out = (*in).DeepCopy()
@@ -164,6 +308,36 @@ func (in *ContainerImageBuildStatus) DeepCopy() *ContainerImageBuildStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContextAuth) DeepCopyInto(out *ContextAuth) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContextAuth.
+func (in *ContextAuth) DeepCopy() *ContextAuth {
+	if in == nil {
+		return nil
+	}
+	out := new(ContextAuth)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitAuth) DeepCopyInto(out *GitAuth) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitAuth.
+func (in *GitAuth) DeepCopy() *GitAuth {
+	if in == nil {
+		return nil
+	}
+	out := new(GitAuth)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *InitContainer) DeepCopyInto(out *InitContainer) {
 	*out = *in
@@ -196,6 +370,21 @@ func (in *InitContainer) DeepCopy() *InitContainer {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlatformImage) DeepCopyInto(out *PlatformImage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlatformImage.
+func (in *PlatformImage) DeepCopy() *PlatformImage {
+	if in == nil {
+		return nil
+	}
+	out := new(PlatformImage)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Registry) DeepCopyInto(out *Registry) {
 	*out = *in
@@ -211,3 +400,63 @@ func (in *Registry) DeepCopy() *Registry {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistryPush) DeepCopyInto(out *RegistryPush) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistryPush.
+func (in *RegistryPush) DeepCopy() *RegistryPush {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistryPush)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SSHAgent) DeepCopyInto(out *SSHAgent) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SSHAgent.
+func (in *SSHAgent) DeepCopy() *SSHAgent {
+	if in == nil {
+		return nil
+	}
+	out := new(SSHAgent)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TagTemplateData) DeepCopyInto(out *TagTemplateData) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TagTemplateData.
+func (in *TagTemplateData) DeepCopy() *TagTemplateData {
+	if in == nil {
+		return nil
+	}
+	out := new(TagTemplateData)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VolumeContext) DeepCopyInto(out *VolumeContext) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VolumeContext.
+func (in *VolumeContext) DeepCopy() *VolumeContext {
+	if in == nil {
+		return nil
+	}
+	out := new(VolumeContext)
+	in.DeepCopyInto(out)
+	return out
+}
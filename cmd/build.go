@@ -9,6 +9,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	"github.com/dominodatalab/forge/internal/archive"
 	"github.com/dominodatalab/forge/internal/buildjob"
 )
 
@@ -39,7 +40,16 @@ var (
 				BrokerOpts:          brokerOpts,
 				PreparerPluginsPath: preparerPluginsPath,
 				EnableLayerCaching:  enableLayerCaching,
-				Debug:               debug,
+				ContextLimits: archive.Limits{
+					MaxSize:    contextMaxSize,
+					MaxEntries: contextMaxEntries,
+				},
+				LayerCache:         layerCache,
+				PushConcurrency:    pushConcurrency,
+				StateGCKeepStorage: stateGCKeepStorage,
+				Builder:            builderBackend,
+				RemoteBuildkit:     remoteBuildkit,
+				Debug:              debug,
 			}
 
 			if debug {
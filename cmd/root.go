@@ -12,9 +12,13 @@ import (
 
 	"github.com/spf13/cobra"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
 	"github.com/dominodatalab/forge/controllers"
+	"github.com/dominodatalab/forge/internal/archive"
 	"github.com/dominodatalab/forge/internal/config"
+	"github.com/dominodatalab/forge/internal/layercache"
 	"github.com/dominodatalab/forge/internal/message"
 )
 
@@ -36,7 +40,16 @@ possible, this controller can be configured to push state updates to any AMQP me
 Image layers can be exported and stored inside the target registry after a build. This shared cache will then be used by
 all controller workers. By default, the embedded image builder uses a "max" mode to ensure all intermediate and final
 image layers are exported. You can override this behavior using the EMBEDDED_BUILDER_CACHE_MODE environment variable.
-Acceptable values include "min" and "max".`
+Acceptable values include "min", "max" and "inline". These settings are defaults that individual builds can override
+using their cache configuration.
+
+Every build runs inside a dedicated Kubernetes job by default. High-volume installations can instead dispatch builds to a
+pool of long-running workers started with the "worker" command, which keep their builders and caches warm.
+
+Builds run buildkit inside their build pod by default. Installations that already operate a buildkit daemon can delegate
+builds to it using the "remote" builder instead, in which case forge fetches and prepares build contexts and the daemon
+solves and pushes the images. TLS files referenced by the buildkit flags must be mounted into build jobs, e.g. using the
+advanced config.`
 
 	examples = `
 # Watch for ContainerImageBuild resources in your namespace
@@ -49,7 +62,13 @@ forge --message-broker amqp --amqp-uri amqp://<user>:<pass>@<host>:<port/<path>
 forge --preparer-plugins-path /plugins/installed/here
 
 # Enable image build layer caching
-forge --enable-layer-caching`
+forge --enable-layer-caching
+
+# Dispatch builds to a pool of workers
+forge --execution-mode worker --worker-pool-selector app.kubernetes.io/name=forge-worker
+
+# Delegate builds to a buildkit daemon using mutual TLS
+forge --builder remote --buildkit-addr tcp://buildkitd:1234 --buildkit-tls-ca /certs/ca.pem --buildkit-tls-cert /certs/cert.pem --buildkit-tls-key /certs/key.pem`
 
 	defaultMessageQueue = "forge-status-update"
 )
@@ -81,6 +100,21 @@ var (
 	amqpQueue            string
 	preparerPluginsPath  string
 	enableLayerCaching   bool
+	contextMaxSize       int64
+	contextMaxEntries    int
+	inlineContextMaxSize int64
+	pushConcurrency      int
+	layerCache           layercache.Options
+	layerCachePVC        string
+	stateVolumePoolSize  int
+	stateVolumeSize      string
+	stateVolumeClass     string
+	stateGCKeepStorage   int64
+	builderBackend       string
+	remoteBuildkit       config.RemoteBuildkitOptions
+	executionMode        string
+	workerPoolNamespace  string
+	workerPoolSelector   map[string]string
 	brokerOpts           *message.Options
 
 	advCfg = &advancedConfig{}
@@ -92,6 +126,29 @@ var (
 		PreRunE:           processAdvancedConfig,
 		PersistentPreRunE: processBrokerOpts,
 		Run: func(cmd *cobra.Command, args []string) {
+			stateVolumeQuantity, err := resource.ParseQuantity(stateVolumeSize)
+			if err != nil {
+				fmt.Printf("invalid state volume size %q: %v\n", stateVolumeSize, err)
+				os.Exit(1)
+			}
+
+			if !isSupportedBuilder(builderBackend) {
+				fmt.Printf("unsupported builder %q, must be one of %v\n", builderBackend, controllers.SupportedBuilders)
+				os.Exit(1)
+			}
+			if builderBackend == forgev1alpha1.BuilderRemote && remoteBuildkit.Address == "" {
+				fmt.Println("the remote builder requires a buildkit daemon address")
+				os.Exit(1)
+			}
+
+			if !isSupportedExecutionMode(executionMode) {
+				fmt.Printf("unsupported execution mode %q, must be one of %v\n", executionMode, controllers.SupportedExecutionModes)
+				os.Exit(1)
+			}
+			if workerPoolNamespace == "" {
+				workerPoolNamespace = namespace
+			}
+
 			cfg := controllers.ControllerConfig{
 				Debug:                debug,
 				Namespace:            namespace,
@@ -113,6 +170,21 @@ var (
 					SecurityContextConstraints: buildJobSecurityContextConstraints,
 					GrantFullPrivilege:         buildJobGrantFullPrivilege,
 					EnableLayerCaching:         enableLayerCaching,
+					ContextMaxSize:             contextMaxSize,
+					ContextMaxEntries:          contextMaxEntries,
+					InlineContextMaxSize:       inlineContextMaxSize,
+					PushConcurrency:            pushConcurrency,
+					LayerCache:                 layerCache,
+					LayerCachePVC:              layerCachePVC,
+					StateVolumePoolSize:        stateVolumePoolSize,
+					StateVolumeSize:            stateVolumeQuantity,
+					StateVolumeStorageClass:    stateVolumeClass,
+					StateGCKeepStorage:         stateGCKeepStorage,
+					Builder:                    builderBackend,
+					RemoteBuildkit:             remoteBuildkit,
+					ExecutionMode:              executionMode,
+					WorkerNamespace:            workerPoolNamespace,
+					WorkerSelector:             workerPoolSelector,
 					BrokerOpts:                 brokerOpts,
 					EnvVar:                     advCfg.Env,
 					Volumes:                    advCfg.Volumes,
@@ -156,6 +228,24 @@ func processAdvancedConfig(cmd *cobra.Command, args []string) error {
 	return dec.Decode(advCfg)
 }
 
+func isSupportedBuilder(backend string) bool {
+	for _, supported := range controllers.SupportedBuilders {
+		if backend == supported {
+			return true
+		}
+	}
+	return false
+}
+
+func isSupportedExecutionMode(mode string) bool {
+	for _, supported := range controllers.SupportedExecutionModes {
+		if mode == supported {
+			return true
+		}
+	}
+	return false
+}
+
 func processBrokerOpts(cmd *cobra.Command, args []string) error {
 	if messageBroker == "" {
 		return nil
@@ -189,8 +279,16 @@ func init() {
 	rootCmd.Flags().BoolVar(&buildJobGrantFullPrivilege, "build-job-full-privilege", false, "Run builds jobs using a privileged root user")
 	rootCmd.Flags().StringVar(&buildAdvancedConfigFilename, "build-job-advanced-config", "", "Add volumes, volume mounts and environment variables to your build jobs using a JSON file")
 	rootCmd.Flags().BoolVar(&buildJobIstioSupport, "build-job-enable-istio-support", false, "Modifies build job resources to support Istio sidecars")
+	rootCmd.Flags().Int64Var(&inlineContextMaxSize, "inline-context-max-size", 256*1024, "Maximum size in bytes of inline Dockerfiles and files in a build spec, including files from config maps. Set to 0 to disable")
 	rootCmd.Flags().DurationVar(&gcInterval, "gc-interval", 30*time.Minute, "Run ContainerImageBuild cleanup operation according to this interval. Set to 0 to disable")
 	rootCmd.Flags().IntVar(&gcMaxKeepCount, "gc-max-keep", 5, "Delete all ContainerImageBuild resources in a 'finished' state that exceed this count")
+	rootCmd.Flags().StringVar(&executionMode, "execution-mode", controllers.ExecutionModeJob, fmt.Sprintf("Run every build in a dedicated job or dispatch builds to a pool of workers started with the worker command (supported values: %v)", controllers.SupportedExecutionModes))
+	rootCmd.Flags().StringVar(&workerPoolNamespace, "worker-pool-namespace", "", "Namespace of the build worker pods. Defaults to the watched namespace")
+	rootCmd.Flags().StringToStringVar(&workerPoolSelector, "worker-pool-selector", map[string]string{"app.kubernetes.io/name": "forge-worker"}, "Labels selecting the build worker pods")
+	rootCmd.Flags().IntVar(&stateVolumePoolSize, "state-volume-pool-size", 0, "Number of persistent volumes per namespace that are leased to builds to keep buildkit state between them. Builds fall back to an ephemeral state directory when every volume is leased. Set to 0 to disable")
+	rootCmd.Flags().StringVar(&stateVolumeSize, "state-volume-size", "50Gi", "Requested size of each persistent state volume")
+	rootCmd.Flags().StringVar(&stateVolumeClass, "state-volume-storage-class", "", "Storage class of persistent state volumes. Uses the cluster default when empty")
+	rootCmd.Flags().StringVar(&layerCachePVC, "layer-cache-pvc", "", "Persistent volume claim mounted into build jobs to store local layer caches. The claim must exist in the namespace of every build using the local cache backend")
 
 	// leveraged by both main and build commands
 	rootCmd.PersistentFlags().StringVar(&messageBroker, "message-broker", "", fmt.Sprintf("Publish resource state changes to a message broker (supported values: %v)", message.SupportedBrokers))
@@ -198,5 +296,23 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&amqpQueue, "amqp-queue", defaultMessageQueue, "AMQP broker queue name")
 	rootCmd.PersistentFlags().StringVar(&preparerPluginsPath, "preparer-plugins-path", path.Join(config.GetStateDir(), "plugins"), "Path to specific preparer plugins or directory to load them from")
 	rootCmd.PersistentFlags().BoolVar(&enableLayerCaching, "enable-layer-caching", false, "Enable image layer caching")
+	rootCmd.PersistentFlags().Int64Var(&contextMaxSize, "context-max-size", archive.DefaultMaxSize, "Maximum total uncompressed size in bytes of a build context archive")
+	rootCmd.PersistentFlags().IntVar(&contextMaxEntries, "context-max-entries", archive.DefaultMaxEntries, "Maximum number of entries in a build context archive")
+	rootCmd.PersistentFlags().IntVar(&pushConcurrency, "push-concurrency", 3, "Maximum number of registries an image is pushed to in parallel")
+	rootCmd.PersistentFlags().StringVar(&layerCache.Backend, "layer-cache-backend", forgev1alpha1.CacheBackendRegistry, "Default layer cache backend used by builds (supported values: registry, local, s3)")
+	rootCmd.PersistentFlags().StringVar(&layerCache.Local.Dir, "layer-cache-dir", "", "Directory holding local layer caches inside build jobs, e.g. a volume mounted using the advanced config")
+	rootCmd.PersistentFlags().Int64Var(&layerCache.Local.MaxSize, "layer-cache-max-size", 0, "Maximum total size in bytes of local layer caches. The least recently exported caches are removed after each build. Set to 0 to disable")
+	rootCmd.PersistentFlags().DurationVar(&layerCache.Local.MaxAge, "layer-cache-max-age", 0, "Remove local layer caches that have not been exported within this duration. Set to 0 to disable")
+	rootCmd.PersistentFlags().StringVar(&layerCache.S3.Endpoint, "layer-cache-s3-endpoint", "", "Endpoint of an S3-compatible object store holding layer caches, e.g. a MinIO instance. Defaults to AWS")
+	rootCmd.PersistentFlags().StringVar(&layerCache.S3.Region, "layer-cache-s3-region", "us-east-1", "Region of the layer cache bucket")
+	rootCmd.PersistentFlags().StringVar(&layerCache.S3.Bucket, "layer-cache-s3-bucket", "", "Bucket holding layer caches. Build jobs use the default AWS credential chain to access it")
+	rootCmd.PersistentFlags().StringVar(&layerCache.S3.Prefix, "layer-cache-s3-prefix", "", "Prefix added to every layer cache object")
+	rootCmd.PersistentFlags().Int64Var(&stateGCKeepStorage, "state-gc-keep-storage", 0, "Bytes of build cache kept in the buildkit state directory after each build. Defaults to 80% of the state volume size when a volume pool is configured. Set to 0 to disable garbage collection")
+	rootCmd.PersistentFlags().StringVar(&builderBackend, "builder", forgev1alpha1.BuilderEmbedded, fmt.Sprintf("Default image builder backend. Builds can select another backend using their spec (supported values: %v)", controllers.SupportedBuilders))
+	rootCmd.PersistentFlags().StringVar(&remoteBuildkit.Address, "buildkit-addr", "", "Address of the buildkit daemon used by remote builds, e.g. tcp://buildkitd:1234 or unix:///run/buildkit/buildkitd.sock")
+	rootCmd.PersistentFlags().StringVar(&remoteBuildkit.CACert, "buildkit-tls-ca", "", "CA certificate file used to verify the buildkit daemon. Enables TLS")
+	rootCmd.PersistentFlags().StringVar(&remoteBuildkit.Cert, "buildkit-tls-cert", "", "Client certificate file used to authenticate to the buildkit daemon")
+	rootCmd.PersistentFlags().StringVar(&remoteBuildkit.Key, "buildkit-tls-key", "", "Client key file used to authenticate to the buildkit daemon")
+	rootCmd.PersistentFlags().StringVar(&remoteBuildkit.ServerName, "buildkit-tls-server-name", "", "Server name used to verify the certificate of the buildkit daemon. Defaults to the host of its address")
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Enabled verbose logging")
 }
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/dominodatalab/forge/internal/archive"
+	"github.com/dominodatalab/forge/internal/buildjob"
+)
+
+var (
+	workerName         string
+	workerPodNamespace string
+	workerNamespace    string
+	workerConcurrency  int
+	workerPollInterval time.Duration
+
+	workerCmd = &cobra.Command{
+		Use:   "worker",
+		Short: "Run the OCI image builds dispatched to this pod",
+		Long: `Run a long-lived builder that processes the ContainerImageBuild resources dispatched to it by a controller using
+the "worker" execution mode. Builders and their caches are kept between builds.`,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			// the controller dispatches builds using the pod name, which is also its hostname
+			if workerName == "" {
+				if hostname, err := os.Hostname(); err == nil {
+					workerName = hostname
+				}
+			}
+
+			// attempt to load "current namespace" when running inside k8s
+			if workerPodNamespace == "" {
+				if bs, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace"); err == nil {
+					workerPodNamespace = string(bs)
+				}
+			}
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg := buildjob.WorkerConfig{
+				Config: buildjob.Config{
+					BrokerOpts:          brokerOpts,
+					PreparerPluginsPath: preparerPluginsPath,
+					EnableLayerCaching:  enableLayerCaching,
+					ContextLimits: archive.Limits{
+						MaxSize:    contextMaxSize,
+						MaxEntries: contextMaxEntries,
+					},
+					LayerCache:         layerCache,
+					PushConcurrency:    pushConcurrency,
+					StateGCKeepStorage: stateGCKeepStorage,
+					Builder:            builderBackend,
+					RemoteBuildkit:     remoteBuildkit,
+					Debug:              debug,
+				},
+				Name:         workerName,
+				PodNamespace: workerPodNamespace,
+				Namespace:    workerNamespace,
+				Concurrency:  workerConcurrency,
+				PollInterval: workerPollInterval,
+			}
+
+			if debug {
+				// containerd debug
+				logrus.SetLevel(logrus.TraceLevel)
+			}
+
+			worker, err := buildjob.NewWorker(cfg)
+			if err != nil {
+				panic(err)
+			}
+			defer worker.Cleanup()
+
+			// stop accepting builds on termination and finish the running ones
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			if err := worker.Run(ctx); err != nil {
+				panic(err)
+			}
+		},
+	}
+)
+
+func init() {
+	workerCmd.Flags().StringVar(&workerName, "worker-name", "", "Name of the worker pod. Defaults to the hostname")
+	workerCmd.Flags().StringVar(&workerPodNamespace, "worker-namespace", "", "Namespace of the worker pod. Defaults to the service account namespace")
+	workerCmd.Flags().StringVar(&workerNamespace, "namespace", "", "Process builds in desired namespace. Builds in every namespace are processed when empty")
+	workerCmd.Flags().IntVar(&workerConcurrency, "concurrency", 1, "Number of builds run at once. Every concurrent build uses a separate builder and state directory")
+	workerCmd.Flags().DurationVar(&workerPollInterval, "poll-interval", 2*time.Second, "Delay between searches for dispatched builds")
+
+	rootCmd.AddCommand(workerCmd)
+}
@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+)
+
+// SupportedBuilders are the image builder backends a controller can use by default.
+var SupportedBuilders = []string{forgev1alpha1.BuilderEmbedded, forgev1alpha1.BuilderRemote}
+
+// returns the image builder backend of a build, which defaults to the backend of the controller
+func (r *ContainerImageBuildReconciler) builderBackend(cib *forgev1alpha1.ContainerImageBuild) string {
+	switch {
+	case cib.Spec.Builder != "":
+		return cib.Spec.Builder
+	case r.JobConfig.Builder != "":
+		return r.JobConfig.Builder
+	default:
+		return forgev1alpha1.BuilderEmbedded
+	}
+}
+
+// build jobs connect to the buildkit daemon configured on the controller, workers use their own configuration
+func (r *ContainerImageBuildReconciler) validateBuilder(cib *forgev1alpha1.ContainerImageBuild) error {
+	if r.workerMode() || r.builderBackend(cib) != forgev1alpha1.BuilderRemote {
+		return nil
+	}
+	if r.JobConfig.RemoteBuildkit.Address == "" {
+		return errors.New("remote builds require a buildkit daemon address to be configured on the controller")
+	}
+	return nil
+}
+
+// builds cli args selecting the builder backend of a build job
+func (r *ContainerImageBuildReconciler) builderArgs(cib *forgev1alpha1.ContainerImageBuild) []string {
+	if r.builderBackend(cib) != forgev1alpha1.BuilderRemote {
+		return nil
+	}
+
+	rb := r.JobConfig.RemoteBuildkit
+	args := []string{
+		fmt.Sprintf("--builder=%s", forgev1alpha1.BuilderRemote),
+		fmt.Sprintf("--buildkit-addr=%s", rb.Address),
+	}
+	if rb.CACert != "" {
+		args = append(args, fmt.Sprintf("--buildkit-tls-ca=%s", rb.CACert))
+	}
+	if rb.Cert != "" {
+		args = append(args, fmt.Sprintf("--buildkit-tls-cert=%s", rb.Cert))
+	}
+	if rb.Key != "" {
+		args = append(args, fmt.Sprintf("--buildkit-tls-key=%s", rb.Key))
+	}
+	if rb.ServerName != "" {
+		args = append(args, fmt.Sprintf("--buildkit-tls-server-name=%s", rb.ServerName))
+	}
+	return args
+}
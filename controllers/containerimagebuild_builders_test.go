@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+	"github.com/dominodatalab/forge/internal/config"
+)
+
+func TestContainerImageBuildReconciler_builderBackend(t *testing.T) {
+	r := &ContainerImageBuildReconciler{JobConfig: &BuildJobConfig{}}
+	cib := &forgev1alpha1.ContainerImageBuild{}
+	assert.Equal(t, forgev1alpha1.BuilderEmbedded, r.builderBackend(cib))
+
+	r.JobConfig.Builder = forgev1alpha1.BuilderRemote
+	assert.Equal(t, forgev1alpha1.BuilderRemote, r.builderBackend(cib))
+
+	cib.Spec.Builder = forgev1alpha1.BuilderEmbedded
+	assert.Equal(t, forgev1alpha1.BuilderEmbedded, r.builderBackend(cib))
+}
+
+func TestContainerImageBuildReconciler_validateBuilder(t *testing.T) {
+	r := &ContainerImageBuildReconciler{JobConfig: &BuildJobConfig{}}
+	cib := &forgev1alpha1.ContainerImageBuild{
+		Spec: forgev1alpha1.ContainerImageBuildSpec{Builder: forgev1alpha1.BuilderRemote},
+	}
+	assert.EqualError(t, r.validateBuilder(cib), "remote builds require a buildkit daemon address to be configured on the controller")
+
+	// workers connect to their own buildkit daemon
+	r.JobConfig.ExecutionMode = ExecutionModeWorker
+	assert.NoError(t, r.validateBuilder(cib))
+
+	r.JobConfig.ExecutionMode = ExecutionModeJob
+	r.JobConfig.RemoteBuildkit = config.RemoteBuildkitOptions{Address: "unix:///run/buildkit/buildkitd.sock"}
+	assert.NoError(t, r.validateBuilder(cib))
+}
@@ -4,22 +4,33 @@ import (
 	"context"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/newrelic/go-agent/v3/newrelic"
 	"github.com/prometheus/client_golang/prometheus"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
 	"github.com/dominodatalab/forge/internal/cloud"
+	"github.com/dominodatalab/forge/internal/config"
+	"github.com/dominodatalab/forge/internal/layercache"
 	"github.com/dominodatalab/forge/internal/message"
 )
 
@@ -37,6 +48,21 @@ type BuildJobConfig struct {
 	TolerationKey              string
 	GrantFullPrivilege         bool
 	EnableLayerCaching         bool
+	InlineContextMaxSize       int64
+	ContextMaxSize             int64
+	ContextMaxEntries          int
+	PushConcurrency            int
+	LayerCache                 layercache.Options
+	LayerCachePVC              string
+	StateVolumePoolSize        int
+	StateVolumeSize            resource.Quantity
+	StateVolumeStorageClass    string
+	StateGCKeepStorage         int64
+	Builder                    string
+	RemoteBuildkit             config.RemoteBuildkitOptions
+	ExecutionMode              string
+	WorkerNamespace            string
+	WorkerSelector             map[string]string
 	PodSecurityPolicy          string
 	SecurityContextConstraints string
 	BrokerOpts                 *message.Options
@@ -64,14 +90,19 @@ type ControllerConfig struct {
 type ContainerImageBuildReconciler struct {
 	client.Client
 	*kubernetes.Clientset
-	Log      logr.Logger
-	Scheme   *runtime.Scheme
-	Recorder record.EventRecorder
+	APIReader client.Reader
+	Log       logr.Logger
+	Scheme    *runtime.Scheme
+	Recorder  record.EventRecorder
 
 	NewRelic *newrelic.Application
 
 	JobConfig *BuildJobConfig
 	registry  *cloud.Registry
+
+	// last build state counted for every build, so that each state is counted once
+	countedMu     sync.Mutex
+	countedStates map[types.NamespacedName]forgev1alpha1.BuildState
 }
 
 var (
@@ -123,11 +154,21 @@ func init() {
 func (r *ContainerImageBuildReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&forgev1alpha1.ContainerImageBuild{}).
+		Owns(&batchv1.Job{}).
+		Watches(
+			&source.Kind{Type: &corev1.Pod{}},
+			handler.EnqueueRequestsFromMapFunc(mapPodToBuild),
+			builder.WithPredicates(predicate.NewPredicateFuncs(isBuildPod)),
+		).
 		Complete(r)
 }
 
 // +kubebuilder:rbac:groups=forge.dominodatalab.com,resources=containerimagebuilds,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=forge.dominodatalab.com,resources=containerimagebuilds/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update
 
 func (r *ContainerImageBuildReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	txn := r.NewRelic.StartTransaction("Reconcile")
@@ -139,20 +180,87 @@ func (r *ContainerImageBuildReconciler) Reconcile(ctx context.Context, req ctrl.
 	// attempt to load resource by name and ignore not-found errors
 	build := &forgev1alpha1.ContainerImageBuild{}
 	if err := r.Get(ctx, req.NamespacedName, build); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.V(1).Info("Resource not found, ignoring")
+			r.forgetCountedState(req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
 		log.Error(err, "Unable to find resource")
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+		return ctrl.Result{}, err
 	}
 
 	if build.DeletionTimestamp != nil {
 		containerImageBuildsCount.WithLabelValues("deleted").Inc()
+	} else if build.Status.State != "" {
+		r.countState(build, strings.ToLower(string(build.Status.State)))
+	}
+
+	// builds that never report back (oom kills, evictions, lost nodes, etc.) are failed using their job or worker status
+	dispatched := build.Labels[forgev1alpha1.WorkerLabel] != ""
+	if !build.Status.State.IsFinished() && build.DeletionTimestamp == nil {
+		check := r.checkBuildJob
+		if dispatched {
+			check = r.checkBuildWorker
+		}
+
+		failed, err := check(ctx, build)
+		if err != nil {
+			log.Error(err, "Failed to check build job", "Name", build.Name, "Namespace", build.Namespace)
+			return ctrl.Result{}, err
+		}
+		if failed {
+			log.Info("Build job terminated abnormally", "Reason", build.Status.Reason, "ExitCode", build.Status.ExitCode)
+			r.countState(build, strings.ToLower(string(build.Status.State)))
+			return ctrl.Result{}, nil
+		}
 	}
 
+	if build.Status.State.IsFinished() {
+		if err := r.releaseStateVolume(ctx, build); err != nil {
+			log.Error(err, "Failed to release state volume", "Name", build.Name, "Namespace", build.Namespace)
+			return ctrl.Result{}, err
+		}
+	}
+
+	if dispatched && !build.Status.State.IsFinished() {
+		return ctrl.Result{RequeueAfter: workerCheckInterval}, nil
+	}
 	if build.Status.State != "" {
-		containerImageBuildsCount.WithLabelValues(strings.ToLower(string(build.Status.State))).Inc()
 		return ctrl.Result{}, nil
 	}
 
 	log.Info("Reconciling build job", "Name", build.Name, "Namespace", build.Namespace)
+
+	invalid, err := r.validateBuild(ctx, build)
+	if err != nil {
+		log.Error(err, "Failed to validate build", "Name", build.Name, "Namespace", build.Namespace)
+		return ctrl.Result{}, err
+	}
+	if invalid != nil {
+		log.Info("Rejecting invalid build", "Name", build.Name, "Namespace", build.Namespace, "error", invalid.Error())
+		failure := &jobFailure{Reason: forgev1alpha1.BuildReasonInvalidSpec, Message: invalid.Error()}
+		if err := r.failBuild(ctx, build, failure); err != nil {
+			log.Error(err, "Failed to update build status", "Name", build.Name, "Namespace", build.Namespace)
+			return ctrl.Result{}, err
+		}
+		r.countState(build, "invalid")
+		return ctrl.Result{}, nil
+	}
+
+	if r.workerMode() {
+		ok, err := r.dispatchBuild(ctx, build)
+		if err != nil {
+			log.Error(err, "Failed to dispatch build", "Name", build.Name, "Namespace", build.Namespace)
+			return ctrl.Result{}, err
+		}
+		if !ok {
+			log.Info("Every build worker is busy, retrying", "Name", build.Name, "Namespace", build.Namespace)
+			return ctrl.Result{RequeueAfter: workerDispatchRetryInterval}, nil
+		}
+		containerImageBuildsCount.WithLabelValues("initializing").Inc()
+		return ctrl.Result{RequeueAfter: workerCheckInterval}, nil
+	}
+
 	containerImageBuildsCount.WithLabelValues("initializing").Inc()
 
 	if err := r.checkPrerequisites(ctx, build); err != nil {
@@ -168,8 +276,33 @@ func (r *ContainerImageBuildReconciler) Reconcile(ctx context.Context, req ctrl.
 	return ctrl.Result{}, nil
 }
 
-// RunGC will delete ContainerImageBuild resources that are in a "completed" or "failed" state. The oldest resources
-// will be deleted first and the retentionCount will preserve N of resources for inspection.
+// increments the build counter when the state of a build differs from the last one counted for it. builds transition
+// through every state once, so each state is counted when the reconciler first sees it rather than on every event.
+func (r *ContainerImageBuildReconciler) countState(build *forgev1alpha1.ContainerImageBuild, status string) {
+	r.countedMu.Lock()
+	defer r.countedMu.Unlock()
+
+	key := client.ObjectKeyFromObject(build)
+	if state, ok := r.countedStates[key]; ok && state == build.Status.State {
+		return
+	}
+	if r.countedStates == nil {
+		r.countedStates = map[types.NamespacedName]forgev1alpha1.BuildState{}
+	}
+	r.countedStates[key] = build.Status.State
+
+	containerImageBuildsCount.WithLabelValues(status).Inc()
+}
+
+func (r *ContainerImageBuildReconciler) forgetCountedState(key types.NamespacedName) {
+	r.countedMu.Lock()
+	defer r.countedMu.Unlock()
+
+	delete(r.countedStates, key)
+}
+
+// RunGC will delete ContainerImageBuild resources that are in a "completed", "failed" or "timed out" state. The oldest
+// resources will be deleted first and the retentionCount will preserve N of resources for inspection.
 func (r *ContainerImageBuildReconciler) RunGC(retentionCount int) {
 	txn := r.NewRelic.StartTransaction("GarbageCollection")
 	defer txn.End()
@@ -197,12 +330,12 @@ func (r *ContainerImageBuildReconciler) RunGC(retentionCount int) {
 	log.Info("Fetched all build resources", "count", listLen)
 
 	log.V(1).Info("Filtering builds by state", "states", []forgev1alpha1.BuildState{
-		forgev1alpha1.BuildStateCompleted, forgev1alpha1.BuildStateFailed,
+		forgev1alpha1.BuildStateCompleted, forgev1alpha1.BuildStateFailed, forgev1alpha1.BuildStateTimedOut,
+		forgev1alpha1.BuildStatePartiallyPushed,
 	})
 	var builds []forgev1alpha1.ContainerImageBuild
 	for _, cib := range list.Items {
-		state := cib.Status.State
-		if state == forgev1alpha1.BuildStateCompleted || state == forgev1alpha1.BuildStateFailed {
+		if cib.Status.State.IsFinished() {
 			builds = append(builds, cib)
 		}
 	}
@@ -6,11 +6,14 @@ import (
 	"testing"
 	"time"
 
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -168,6 +171,29 @@ func TestContainerImageBuildReconciler_RunGC(t *testing.T) {
 			testObjs:  testObjs(true),
 			expected:  []string{"test-cib-new", "test-cib-initialized", "test-cib-building"},
 		},
+		{
+			name:      "timed_out_eligible",
+			retention: 0,
+			testObjs: []runtime.Object{
+				&forgev1alpha1.ContainerImageBuild{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "test-cib-building",
+					},
+					Status: forgev1alpha1.ContainerImageBuildStatus{
+						State: forgev1alpha1.BuildStateBuilding,
+					},
+				},
+				&forgev1alpha1.ContainerImageBuild{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "test-cib-timed-out",
+					},
+					Status: forgev1alpha1.ContainerImageBuildStatus{
+						State: forgev1alpha1.BuildStateTimedOut,
+					},
+				},
+			},
+			expected: []string{"test-cib-building"},
+		},
 		{
 			name:     "list_errors",
 			listErr:  true,
@@ -215,3 +241,76 @@ func TestContainerImageBuildReconciler_RunGC(t *testing.T) {
 		})
 	}
 }
+
+func TestContainerImageBuildReconciler_ReconcileInvalidSpec(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, forgev1alpha1.AddToScheme(scheme))
+
+	cib := &forgev1alpha1.ContainerImageBuild{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cib", Namespace: "test-ns"},
+		Spec: forgev1alpha1.ContainerImageBuildSpec{
+			Context:        "https://example.com/context.tgz",
+			DockerfilePath: "../Dockerfile",
+		},
+	}
+	controller := &ContainerImageBuildReconciler{
+		Log:      log.NullLogger{},
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(cib).Build(),
+		Recorder: record.NewFakeRecorder(10),
+	}
+
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cib)}
+	_, err := controller.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+
+	actual := &forgev1alpha1.ContainerImageBuild{}
+	require.NoError(t, controller.Get(context.Background(), req.NamespacedName, actual))
+	assert.Equal(t, forgev1alpha1.BuildStateFailed, actual.Status.State)
+	assert.Equal(t, forgev1alpha1.BuildReasonInvalidSpec, actual.Status.Reason)
+	assert.Contains(t, actual.Status.ErrorMessage, "must be relative to the build context")
+}
+
+func buildCount(t *testing.T, status string) float64 {
+	t.Helper()
+
+	var m dto.Metric
+	require.NoError(t, containerImageBuildsCount.WithLabelValues(status).Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+func TestContainerImageBuildReconciler_ReconcileCountsStatesOnce(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, forgev1alpha1.AddToScheme(scheme))
+
+	cib := &forgev1alpha1.ContainerImageBuild{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cib", Namespace: "test-ns"},
+		Status:     forgev1alpha1.ContainerImageBuildStatus{State: forgev1alpha1.BuildStateCompleted},
+	}
+	controller := &ContainerImageBuildReconciler{
+		Log:       log.NullLogger{},
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(cib).Build(),
+		Recorder:  record.NewFakeRecorder(10),
+		JobConfig: &BuildJobConfig{},
+	}
+
+	completed := buildCount(t, "completed")
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cib)}
+	for i := 0; i < 3; i++ {
+		_, err := controller.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, completed+1, buildCount(t, "completed"))
+
+	// builds that are recreated with the same name are counted again
+	require.NoError(t, controller.Delete(context.Background(), cib))
+	_, err := controller.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+
+	cib.ResourceVersion = ""
+	require.NoError(t, controller.Create(context.Background(), cib))
+	_, err = controller.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, completed+2, buildCount(t, "completed"))
+}
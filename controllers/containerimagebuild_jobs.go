@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+)
+
+const (
+	// label added to pods by the job controller, build jobs share the name of their container image build
+	jobNameLabel = "job-name"
+	// label added to build pods by this controller, holds the name of their container image build
+	buildPodLabel = "forge.dominodatalab.com/build"
+)
+
+// jobFailure describes a build job that terminated before the build process could record its own outcome.
+type jobFailure struct {
+	// State the build transitions into, defaults to failed
+	State    forgev1alpha1.BuildState
+	Reason   string
+	Message  string
+	ExitCode int32
+}
+
+// inspects a build job and its pods for terminal failures. a nil result means the job is either healthy or still
+// running. pod-level causes are preferred over the generic job condition since they carry the exit code.
+func detectJobFailure(job *batchv1.Job, pods []corev1.Pod) *jobFailure {
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue && cond.Reason == "DeadlineExceeded" {
+			return &jobFailure{
+				State:   forgev1alpha1.BuildStateTimedOut,
+				Reason:  forgev1alpha1.BuildReasonDeadlineExceeded,
+				Message: fmt.Sprintf("build job exceeded its active deadline: %s", cond.Message),
+			}
+		}
+	}
+
+	for _, pod := range pods {
+		if failure := detectPodFailure(&pod); failure != nil {
+			return failure
+		}
+	}
+
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue {
+			failure := &jobFailure{
+				Reason:  forgev1alpha1.BuildReasonJobFailed,
+				Message: fmt.Sprintf("build job failed (%s): %s", cond.Reason, cond.Message),
+			}
+			if state := terminatedBuildContainer(pods); state != nil {
+				failure.ExitCode = state.ExitCode
+			}
+			return failure
+		}
+	}
+
+	return nil
+}
+
+func detectPodFailure(pod *corev1.Pod) *jobFailure {
+	if pod.Status.Phase == corev1.PodFailed && pod.Status.Reason == forgev1alpha1.BuildReasonEvicted {
+		return &jobFailure{
+			Reason:  forgev1alpha1.BuildReasonEvicted,
+			Message: fmt.Sprintf("build pod %s was evicted: %s", pod.Name, pod.Status.Message),
+		}
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		if term := cs.State.Terminated; term != nil && term.Reason == forgev1alpha1.BuildReasonOOMKilled {
+			return &jobFailure{
+				Reason:   forgev1alpha1.BuildReasonOOMKilled,
+				Message:  fmt.Sprintf("container %s in build pod %s was killed after exceeding its memory limit", cs.Name, pod.Name),
+				ExitCode: term.ExitCode,
+			}
+		}
+		if wait := cs.State.Waiting; wait != nil && wait.Reason == forgev1alpha1.BuildReasonImagePullBackOff {
+			return &jobFailure{
+				Reason:  forgev1alpha1.BuildReasonImagePullBackOff,
+				Message: fmt.Sprintf("container %s in build pod %s cannot pull image %s: %s", cs.Name, pod.Name, cs.Image, wait.Message),
+			}
+		}
+	}
+
+	return nil
+}
+
+// returns the terminated state of the first failed container found across all pods
+func terminatedBuildContainer(pods []corev1.Pod) *corev1.ContainerStateTerminated {
+	for _, pod := range pods {
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, cs := range statuses {
+			if term := cs.State.Terminated; term != nil && term.ExitCode != 0 {
+				return term
+			}
+		}
+	}
+	return nil
+}
+
+// checks the job owned by an unfinished build for terminal failures and records them in the build status. returns
+// true when the build was transitioned into a failed state.
+func (r *ContainerImageBuildReconciler) checkBuildJob(ctx context.Context, cib *forgev1alpha1.ContainerImageBuild) (bool, error) {
+	job := &batchv1.Job{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(cib), job); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(job, cib) {
+		return false, nil
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{jobNameLabel: job.Name}); err != nil {
+		return false, err
+	}
+
+	failure := detectJobFailure(job, pods.Items)
+	if failure == nil {
+		return false, nil
+	}
+
+	if err := r.failBuild(ctx, cib, failure); err != nil {
+		return false, err
+	}
+
+	// pods stuck pulling images never terminate on their own, so remove the job to release its resources
+	if job.Status.Active > 0 {
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !apierrors.IsNotFound(err) {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+// transitions a build into a failed or timed out state without the involvement of its build job
+func (r *ContainerImageBuildReconciler) failBuild(ctx context.Context, cib *forgev1alpha1.ContainerImageBuild, failure *jobFailure) error {
+	state := failure.State
+	if state == "" {
+		state = forgev1alpha1.BuildStateFailed
+	}
+
+	now := metav1.Now()
+	cib.Status.SetState(state)
+	cib.Status.Reason = failure.Reason
+	cib.Status.ErrorMessage = failure.Message
+	cib.Status.ExitCode = failure.ExitCode
+	cib.Status.BuildCompletedAt = &now
+	if err := r.Status().Update(ctx, cib); err != nil {
+		return err
+	}
+	r.Recorder.Event(cib, corev1.EventTypeWarning, failure.Reason, failure.Message)
+
+	return nil
+}
+
+// selects the pods of build jobs, every other pod in the cluster is ignored by the controller
+func buildPodSelector() labels.Selector {
+	selector := labels.NewSelector()
+	for _, key := range []string{buildPodLabel, jobNameLabel} {
+		req, err := labels.NewRequirement(key, selection.Exists, nil)
+		if err != nil {
+			panic(err)
+		}
+		selector = selector.Add(*req)
+	}
+	return selector
+}
+
+func isBuildPod(obj client.Object) bool {
+	return buildPodSelector().Matches(labels.Set(obj.GetLabels()))
+}
+
+// maps build pods onto the container image build that owns their job
+func mapPodToBuild(obj client.Object) []reconcile.Request {
+	name, ok := obj.GetLabels()[buildPodLabel]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Name: name, Namespace: obj.GetNamespace()}},
+	}
+}
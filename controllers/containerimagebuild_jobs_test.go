@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+)
+
+func TestDetectJobFailure(t *testing.T) {
+	failedJob := func(reason string) *batchv1.Job {
+		return &batchv1.Job{
+			Status: batchv1.JobStatus{
+				Conditions: []batchv1.JobCondition{
+					{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: reason, Message: "oops"},
+				},
+			},
+		}
+	}
+	podWithStatus := func(status corev1.PodStatus) []corev1.Pod {
+		return []corev1.Pod{{ObjectMeta: metav1.ObjectMeta{Name: "test-pod"}, Status: status}}
+	}
+
+	testCases := []struct {
+		name     string
+		job      *batchv1.Job
+		pods     []corev1.Pod
+		state    forgev1alpha1.BuildState
+		reason   string
+		exitCode int32
+	}{
+		{
+			name: "running",
+			job:  &batchv1.Job{Status: batchv1.JobStatus{Active: 1}},
+			pods: podWithStatus(corev1.PodStatus{Phase: corev1.PodRunning}),
+		},
+		{
+			name:   "deadline_exceeded",
+			job:    failedJob("DeadlineExceeded"),
+			state:  forgev1alpha1.BuildStateTimedOut,
+			reason: forgev1alpha1.BuildReasonDeadlineExceeded,
+		},
+		{
+			name: "oom_killed",
+			job:  failedJob("BackoffLimitExceeded"),
+			pods: podWithStatus(corev1.PodStatus{
+				Phase: corev1.PodFailed,
+				ContainerStatuses: []corev1.ContainerStatus{
+					{
+						Name: "forge-build",
+						State: corev1.ContainerState{
+							Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137},
+						},
+					},
+				},
+			}),
+			reason:   forgev1alpha1.BuildReasonOOMKilled,
+			exitCode: 137,
+		},
+		{
+			name: "evicted",
+			job:  failedJob("BackoffLimitExceeded"),
+			pods: podWithStatus(corev1.PodStatus{
+				Phase:   corev1.PodFailed,
+				Reason:  "Evicted",
+				Message: "The node was low on resource: ephemeral-storage.",
+			}),
+			reason: forgev1alpha1.BuildReasonEvicted,
+		},
+		{
+			name: "image_pull_backoff",
+			job:  &batchv1.Job{Status: batchv1.JobStatus{Active: 1}},
+			pods: podWithStatus(corev1.PodStatus{
+				Phase: corev1.PodPending,
+				InitContainerStatuses: []corev1.ContainerStatus{
+					{
+						Name: "init",
+						State: corev1.ContainerState{
+							Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"},
+						},
+					},
+				},
+			}),
+			reason: forgev1alpha1.BuildReasonImagePullBackOff,
+		},
+		{
+			name: "job_failed",
+			job:  failedJob("BackoffLimitExceeded"),
+			pods: podWithStatus(corev1.PodStatus{
+				Phase: corev1.PodFailed,
+				ContainerStatuses: []corev1.ContainerStatus{
+					{
+						Name: "forge-build",
+						State: corev1.ContainerState{
+							Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 2},
+						},
+					},
+				},
+			}),
+			reason:   forgev1alpha1.BuildReasonJobFailed,
+			exitCode: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			failure := detectJobFailure(tc.job, tc.pods)
+			if tc.reason == "" {
+				assert.Nil(t, failure)
+				return
+			}
+
+			require.NotNil(t, failure)
+			assert.Equal(t, tc.state, failure.State)
+			assert.Equal(t, tc.reason, failure.Reason)
+			assert.Equal(t, tc.exitCode, failure.ExitCode)
+			assert.NotEmpty(t, failure.Message)
+		})
+	}
+}
+
+func TestContainerImageBuildReconciler_ReconcileFailedJob(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, forgev1alpha1.AddToScheme(scheme))
+
+	cib := &forgev1alpha1.ContainerImageBuild{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cib", Namespace: "test-ns", UID: "test-uid"},
+		Status:     forgev1alpha1.ContainerImageBuildStatus{State: forgev1alpha1.BuildStateBuilding},
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cib.Name,
+			Namespace: cib.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: forgev1alpha1.SchemeGroupVersion.String(),
+					Kind:       "ContainerImageBuild",
+					Name:       cib.Name,
+					UID:        cib.UID,
+					Controller: pointer.BoolPtr(true),
+				},
+			},
+		},
+		Status: batchv1.JobStatus{Failed: 1},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-cib-abcde",
+			Namespace: cib.Namespace,
+			Labels:    map[string]string{jobNameLabel: cib.Name, buildPodLabel: cib.Name},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodFailed,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name: "forge-build",
+					State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137},
+					},
+				},
+			},
+		},
+	}
+
+	fakeRecorder := record.NewFakeRecorder(10)
+	controller := &ContainerImageBuildReconciler{
+		Log:      log.NullLogger{},
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(cib, job, pod).Build(),
+		Recorder: fakeRecorder,
+	}
+
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cib)}
+	_, err := controller.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+
+	actual := &forgev1alpha1.ContainerImageBuild{}
+	require.NoError(t, controller.Get(context.Background(), req.NamespacedName, actual))
+	assert.Equal(t, forgev1alpha1.BuildStateFailed, actual.Status.State)
+	assert.Equal(t, forgev1alpha1.BuildReasonOOMKilled, actual.Status.Reason)
+	assert.Equal(t, int32(137), actual.Status.ExitCode)
+	assert.NotNil(t, actual.Status.BuildCompletedAt)
+	assert.Len(t, fakeRecorder.Events, 1)
+}
+
+func TestContainerImageBuildReconciler_ReconcileJobDeadlineExceeded(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, forgev1alpha1.AddToScheme(scheme))
+
+	cib := &forgev1alpha1.ContainerImageBuild{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cib", Namespace: "test-ns", UID: "test-uid"},
+		Status:     forgev1alpha1.ContainerImageBuildStatus{State: forgev1alpha1.BuildStateBuilding},
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            cib.Name,
+			Namespace:       cib.Namespace,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(cib, forgev1alpha1.SchemeGroupVersion.WithKind("ContainerImageBuild"))},
+		},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "DeadlineExceeded", Message: "Job was active longer than specified deadline"},
+			},
+		},
+	}
+
+	controller := &ContainerImageBuildReconciler{
+		Log:      log.NullLogger{},
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(cib, job).Build(),
+		Recorder: record.NewFakeRecorder(10),
+	}
+
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cib)}
+	_, err := controller.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+
+	actual := &forgev1alpha1.ContainerImageBuild{}
+	require.NoError(t, controller.Get(context.Background(), req.NamespacedName, actual))
+	assert.Equal(t, forgev1alpha1.BuildStateTimedOut, actual.Status.State)
+	assert.Equal(t, forgev1alpha1.BuildReasonDeadlineExceeded, actual.Status.Reason)
+	assert.Contains(t, actual.Status.ErrorMessage, "exceeded its active deadline")
+}
+
+func TestMapPodToBuild(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "some-pod", Namespace: "test-ns"}}
+	assert.False(t, isBuildPod(pod))
+	assert.Empty(t, mapPodToBuild(pod))
+
+	pod.Labels = map[string]string{jobNameLabel: "other-job"}
+	assert.False(t, isBuildPod(pod))
+
+	pod.Labels = map[string]string{jobNameLabel: "test-cib", buildPodLabel: "test-cib"}
+	assert.True(t, isBuildPod(pod))
+	reqs := mapPodToBuild(pod)
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "test-cib", reqs[0].Name)
+	assert.Equal(t, "test-ns", reqs[0].Namespace)
+}
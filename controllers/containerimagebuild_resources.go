@@ -29,6 +29,12 @@ const (
 	istioCmdArg               = "\nEXIT_CODE=$?; wget -qO- --post-data \"\" http://localhost:15020/quitquitquit; exit $EXIT_CODE"
 	buildContextDirVolumeName = "build-context-dir"
 	stateDirVolumeName        = "state-dir"
+	contextVolumeName         = "build-context-source"
+	layerCacheVolumeName      = "layer-cache"
+
+	// extra time granted to build jobs on top of the build timeout to account for scheduling, image pulls, init
+	// containers and status updates. the build process enforces the actual timeout and this acts as a backstop.
+	jobDeadlineHeadroomSeconds = 300
 )
 
 // creates all supporting resources required by build job
@@ -87,6 +93,15 @@ func (r *ContainerImageBuildReconciler) checkRole(ctx context.Context, cib *forg
 		},
 	}
 
+	if cib.Spec.FilesConfigMap != "" {
+		role.Rules = append(role.Rules, rbacv1.PolicyRule{
+			APIGroups:     []string{""},
+			Resources:     []string{"configmaps"},
+			Verbs:         []string{"get"},
+			ResourceNames: []string{cib.Spec.FilesConfigMap},
+		})
+	}
+
 	if r.JobConfig.PodSecurityPolicy != "" {
 		role.Rules = append(role.Rules, rbacv1.PolicyRule{
 			APIGroups:     []string{"policy"},
@@ -161,7 +176,7 @@ func (r *ContainerImageBuildReconciler) checkCloudRegistrySecrets(ctx context.Co
 	}
 
 	// build and create new secret for consumption by build job
-	name := fmt.Sprintf("%s-%s", cib.Name, cloudCredentialsID)
+	name := config.DynamicCredentialsSecretName(cib.Name)
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
@@ -201,6 +216,16 @@ func (r *ContainerImageBuildReconciler) checkCloudRegistrySecrets(ctx context.Co
 	return nil
 }
 
+// returns a copy of the labels that identifies pods of the given build, the original labels may be shared with the build
+func withBuildPodLabel(labels map[string]string, name string) map[string]string {
+	result := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		result[k] = v
+	}
+	result[buildPodLabel] = name
+	return result
+}
+
 // generates build job definition using container image build spec
 func (r *ContainerImageBuildReconciler) createJobForBuild(ctx context.Context, cib *forgev1alpha1.ContainerImageBuild) error {
 	// reset dynamic volumes created by other funcs after use
@@ -225,6 +250,7 @@ func (r *ContainerImageBuildReconciler) createJobForBuild(ctx context.Context, c
 	for k, v := range r.JobConfig.Labels {
 		podMeta.Labels[k] = v
 	}
+	podMeta.Labels = withBuildPodLabel(podMeta.Labels, cib.Name)
 	for k, v := range cib.Annotations {
 		podMeta.Annotations[k] = v
 	}
@@ -264,6 +290,22 @@ func (r *ContainerImageBuildReconciler) createJobForBuild(ctx context.Context, c
 			EmptyDir: &corev1.EmptyDirVolumeSource{},
 		},
 	}
+	// remote builds keep their state in the buildkit daemon
+	remote := r.builderBackend(cib) == forgev1alpha1.BuilderRemote
+	var stateVolume string
+	if !remote {
+		var err error
+		if stateVolume, err = r.leaseStateVolume(ctx, cib); err != nil {
+			return errors.Wrap(err, "cannot lease state volume")
+		}
+	}
+	if stateVolume != "" {
+		stateDirVolume.VolumeSource = corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: stateVolume},
+		}
+	} else if r.JobConfig.StateVolumePoolSize > 0 && !remote {
+		r.Recorder.Event(cib, corev1.EventTypeNormal, "StateVolumePoolExhausted", "All state volumes are leased, building without persistent state")
+	}
 	volumes := []corev1.Volume{
 		buildContextDirVolume,
 		stateDirVolume,
@@ -286,6 +328,48 @@ func (r *ContainerImageBuildReconciler) createJobForBuild(ctx context.Context, c
 	volumeMounts = append(volumeMounts, r.JobConfig.VolumeMounts...)
 	volumeMounts = append(volumeMounts, r.JobConfig.DynamicVolumeMounts...)
 
+	// mount the volume shared by builds using the local layer cache backend
+	if r.JobConfig.LayerCachePVC != "" {
+		volumes = append(volumes, corev1.Volume{
+			Name: layerCacheVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: r.JobConfig.LayerCachePVC},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      layerCacheVolumeName,
+			MountPath: config.LayerCachePath,
+		})
+	}
+
+	// mount volume build contexts read-only so that the build can use them in place
+	vc, err := forgev1alpha1.ParseVolumeContext(cib.Spec.Context)
+	if err != nil {
+		return err
+	}
+	if vc != nil {
+		contextVol := corev1.Volume{Name: contextVolumeName}
+		switch vc.Kind {
+		case forgev1alpha1.VolumeContextPVC:
+			contextVol.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: vc.Name,
+				ReadOnly:  true,
+			}
+		case forgev1alpha1.VolumeContextConfigMap:
+			contextVol.ConfigMap = &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: vc.Name},
+			}
+		}
+		volumes = append(volumes, contextVol)
+
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      contextVol.Name,
+			MountPath: config.VolumeContextPath,
+			SubPath:   vc.SubPath,
+			ReadOnly:  true,
+		})
+	}
+
 	// optionally configure the custom CA bundle w/ additional volumes/mounts
 	if r.JobConfig.CustomCAConfigMap != "" {
 		caBundleVol := corev1.Volume{
@@ -368,6 +452,11 @@ func (r *ContainerImageBuildReconciler) createJobForBuild(ctx context.Context, c
 		})
 	}
 
+	var activeDeadlineSeconds *int64
+	if cib.Spec.TimeoutSeconds > 0 {
+		activeDeadlineSeconds = pointer.Int64Ptr(int64(cib.Spec.TimeoutSeconds) + jobDeadlineHeadroomSeconds)
+	}
+
 	// construct job object
 	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
@@ -376,7 +465,8 @@ func (r *ContainerImageBuildReconciler) createJobForBuild(ctx context.Context, c
 			Labels:    cib.Labels,
 		},
 		Spec: batchv1.JobSpec{
-			BackoffLimit: pointer.Int32Ptr(0),
+			BackoffLimit:          pointer.Int32Ptr(0),
+			ActiveDeadlineSeconds: activeDeadlineSeconds,
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: podMeta,
 				Spec: corev1.PodSpec{
@@ -422,6 +512,24 @@ func (r *ContainerImageBuildReconciler) prepareJobArgs(cib *forgev1alpha1.Contai
 		args = append(args, fmt.Sprintf("--preparer-plugins-path=%s", r.JobConfig.PreparerPluginPath))
 	}
 
+	if r.JobConfig.ContextMaxSize > 0 {
+		args = append(args, fmt.Sprintf("--context-max-size=%d", r.JobConfig.ContextMaxSize))
+	}
+	if r.JobConfig.ContextMaxEntries > 0 {
+		args = append(args, fmt.Sprintf("--context-max-entries=%d", r.JobConfig.ContextMaxEntries))
+	}
+	if r.JobConfig.PushConcurrency > 0 {
+		args = append(args, fmt.Sprintf("--push-concurrency=%d", r.JobConfig.PushConcurrency))
+	}
+
+	args = append(args, r.layerCacheArgs()...)
+
+	remote := r.builderBackend(cib) == forgev1alpha1.BuilderRemote
+	if keep := r.stateGCKeepStorage(); keep > 0 && !remote {
+		args = append(args, fmt.Sprintf("--state-gc-keep-storage=%d", keep))
+	}
+	args = append(args, r.builderArgs(cib)...)
+
 	if r.JobConfig.BrokerOpts != nil {
 		opts := r.JobConfig.BrokerOpts
 
@@ -438,7 +546,8 @@ func (r *ContainerImageBuildReconciler) prepareJobArgs(cib *forgev1alpha1.Contai
 		args = append(args, bs...)
 	}
 
-	if !r.JobConfig.GrantFullPrivilege {
+	// remote builds do not run buildkit in the job, so they do not need rootlesskit
+	if !r.JobConfig.GrantFullPrivilege && !remote {
 		args = append([]string{rootlesskitCommand}, args...)
 	}
 
@@ -449,6 +558,45 @@ func (r *ContainerImageBuildReconciler) prepareJobArgs(cib *forgev1alpha1.Contai
 	return append([]string{"-c"}, strings.Join(args, " "))
 }
 
+// builds cli args configuring the layer cache backends of a build job
+func (r *ContainerImageBuildReconciler) layerCacheArgs() []string {
+	lc := r.JobConfig.LayerCache
+
+	var args []string
+	if lc.Backend != "" {
+		args = append(args, fmt.Sprintf("--layer-cache-backend=%s", lc.Backend))
+	}
+
+	dir := lc.Local.Dir
+	if r.JobConfig.LayerCachePVC != "" {
+		dir = config.LayerCachePath
+	}
+	if dir != "" {
+		args = append(args, fmt.Sprintf("--layer-cache-dir=%s", dir))
+	}
+	if lc.Local.MaxSize > 0 {
+		args = append(args, fmt.Sprintf("--layer-cache-max-size=%d", lc.Local.MaxSize))
+	}
+	if lc.Local.MaxAge > 0 {
+		args = append(args, fmt.Sprintf("--layer-cache-max-age=%s", lc.Local.MaxAge))
+	}
+
+	if lc.S3.Configured() {
+		args = append(args, fmt.Sprintf("--layer-cache-s3-bucket=%s", lc.S3.Bucket))
+		if lc.S3.Endpoint != "" {
+			args = append(args, fmt.Sprintf("--layer-cache-s3-endpoint=%s", lc.S3.Endpoint))
+		}
+		if lc.S3.Region != "" {
+			args = append(args, fmt.Sprintf("--layer-cache-s3-region=%s", lc.S3.Region))
+		}
+		if lc.S3.Prefix != "" {
+			args = append(args, fmt.Sprintf("--layer-cache-s3-prefix=%s", lc.S3.Prefix))
+		}
+	}
+
+	return args
+}
+
 // checks if runtime object exists. if it does not exist, ownership is assigned to a container image build resource and
 // the object is then created. otherwise, this procedure results in a no-op.
 func (r *ContainerImageBuildReconciler) withOwnedResource(ctx context.Context, cib *forgev1alpha1.ContainerImageBuild, obj client.Object) error {
@@ -3,6 +3,7 @@ package controllers
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -18,6 +19,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+	"github.com/dominodatalab/forge/internal/config"
+	"github.com/dominodatalab/forge/internal/layercache"
 	"github.com/dominodatalab/forge/internal/message"
 )
 
@@ -270,6 +273,102 @@ func TestContainerImageBuildReconciler_custom_ca_secret(t *testing.T) {
 	})
 }
 
+func TestContainerImageBuildReconciler_activeDeadline(t *testing.T) {
+	controller := makeController(t)
+
+	testCases := []struct {
+		name     string
+		timeout  uint16
+		expected *int64
+	}{
+		{"test-cib-no-timeout", 0, nil},
+		{"test-cib-timeout", 600, pointer.Int64Ptr(900)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cib := &forgev1alpha1.ContainerImageBuild{
+				ObjectMeta: metav1.ObjectMeta{Name: tc.name},
+				Spec:       forgev1alpha1.ContainerImageBuildSpec{TimeoutSeconds: tc.timeout},
+			}
+			require.NoError(t, controller.createJobForBuild(context.Background(), cib))
+
+			job := &batchv1.Job{}
+			require.NoError(t, controller.Client.Get(context.Background(), types.NamespacedName{Name: cib.Name}, job))
+			assert.Equal(t, tc.expected, job.Spec.ActiveDeadlineSeconds)
+		})
+	}
+}
+
+func TestContainerImageBuildReconciler_volumeContext(t *testing.T) {
+	controller := makeController(t)
+
+	testCases := []struct {
+		name    string
+		context string
+		source  corev1.VolumeSource
+		subPath string
+	}{
+		{
+			name:    "test-cib-pvc",
+			context: "pvc://pipeline-workspace/step-1/output",
+			source: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "pipeline-workspace", ReadOnly: true},
+			},
+			subPath: "step-1/output",
+		},
+		{
+			name:    "test-cib-configmap",
+			context: "configmap://build-files",
+			source: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "build-files"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cib := &forgev1alpha1.ContainerImageBuild{
+				ObjectMeta: metav1.ObjectMeta{Name: tc.name},
+				Spec:       forgev1alpha1.ContainerImageBuildSpec{Context: tc.context},
+			}
+			require.NoError(t, controller.createJobForBuild(context.Background(), cib))
+
+			job := &batchv1.Job{}
+			require.NoError(t, controller.Client.Get(context.Background(), types.NamespacedName{Name: cib.Name}, job))
+			podSpec := job.Spec.Template.Spec
+
+			assert.Contains(t, podSpec.Volumes, corev1.Volume{Name: contextVolumeName, VolumeSource: tc.source})
+			assert.Contains(t, podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+				Name:      contextVolumeName,
+				MountPath: config.VolumeContextPath,
+				SubPath:   tc.subPath,
+				ReadOnly:  true,
+			})
+		})
+	}
+}
+
+func TestContainerImageBuildReconciler_layerCacheVolume(t *testing.T) {
+	controller := makeController(t)
+	controller.JobConfig.LayerCachePVC = "shared-layer-cache"
+
+	cib := &forgev1alpha1.ContainerImageBuild{ObjectMeta: metav1.ObjectMeta{Name: "test-cib-layer-cache"}}
+	require.NoError(t, controller.createJobForBuild(context.Background(), cib))
+
+	job := &batchv1.Job{}
+	require.NoError(t, controller.Client.Get(context.Background(), types.NamespacedName{Name: cib.Name}, job))
+	podSpec := job.Spec.Template.Spec
+
+	assert.Contains(t, podSpec.Volumes, corev1.Volume{
+		Name: layerCacheVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "shared-layer-cache"},
+		},
+	})
+	assert.Contains(t, podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: layerCacheVolumeName, MountPath: config.LayerCachePath})
+}
+
 func TestContainerImageBuildReconciler_prepareJobArgs(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -305,6 +404,44 @@ func TestContainerImageBuildReconciler_prepareJobArgs(t *testing.T) {
 			jobConfig: &BuildJobConfig{PreparerPluginPath: "/path/to/plugins"},
 			want:      "rootlesskit /usr/bin/forge build --resource=test-cib --enable-layer-caching=false --preparer-plugins-path=/path/to/plugins",
 		},
+		{
+			name:      "context limits",
+			jobConfig: &BuildJobConfig{ContextMaxSize: 1024, ContextMaxEntries: 10, PushConcurrency: 2},
+			want:      "rootlesskit /usr/bin/forge build --resource=test-cib --enable-layer-caching=false --context-max-size=1024 --context-max-entries=10 --push-concurrency=2",
+		},
+		{
+			name: "local layer cache",
+			jobConfig: &BuildJobConfig{
+				LayerCache:    layercache.Options{Backend: "local", Local: layercache.LocalOptions{MaxSize: 1 << 30, MaxAge: 72 * time.Hour}},
+				LayerCachePVC: "layer-cache",
+			},
+			want: "rootlesskit /usr/bin/forge build --resource=test-cib --enable-layer-caching=false --layer-cache-backend=local --layer-cache-dir=/mnt/layer-cache --layer-cache-max-size=1073741824 --layer-cache-max-age=72h0m0s",
+		},
+		{
+			name:      "s3 layer cache",
+			jobConfig: &BuildJobConfig{LayerCache: layercache.Options{Backend: "s3", S3: layercache.S3Options{Endpoint: "http://minio:9000", Region: "us-east-1", Bucket: "cache"}}},
+			want:      "rootlesskit /usr/bin/forge build --resource=test-cib --enable-layer-caching=false --layer-cache-backend=s3 --layer-cache-s3-bucket=cache --layer-cache-s3-endpoint=http://minio:9000 --layer-cache-s3-region=us-east-1",
+		},
+		{
+			name:      "state volume pool",
+			jobConfig: &BuildJobConfig{StateVolumePoolSize: 2, StateVolumeSize: resource.MustParse("10Gi")},
+			want:      "rootlesskit /usr/bin/forge build --resource=test-cib --enable-layer-caching=false --state-gc-keep-storage=8589934592",
+		},
+		{
+			name:      "state gc keep storage",
+			jobConfig: &BuildJobConfig{StateVolumePoolSize: 2, StateVolumeSize: resource.MustParse("10Gi"), StateGCKeepStorage: 1 << 30},
+			want:      "rootlesskit /usr/bin/forge build --resource=test-cib --enable-layer-caching=false --state-gc-keep-storage=1073741824",
+		},
+		{
+			name: "remote builder",
+			jobConfig: &BuildJobConfig{
+				Builder:             forgev1alpha1.BuilderRemote,
+				RemoteBuildkit:      config.RemoteBuildkitOptions{Address: "tcp://buildkitd:1234", CACert: "/certs/ca.pem", Cert: "/certs/cert.pem", Key: "/certs/key.pem"},
+				StateVolumePoolSize: 2,
+				StateVolumeSize:     resource.MustParse("10Gi"),
+			},
+			want: "/usr/bin/forge build --resource=test-cib --enable-layer-caching=false --builder=remote --buildkit-addr=tcp://buildkitd:1234 --buildkit-tls-ca=/certs/ca.pem --buildkit-tls-cert=/certs/cert.pem --buildkit-tls-key=/certs/key.pem",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+)
+
+const (
+	stateVolumePrefix          = "forge-state-"
+	stateVolumeLabel           = "forge.dominodatalab.com/state-volume"
+	stateVolumeLeaseAnnotation = "forge.dominodatalab.com/leased-by"
+)
+
+// leases a persistent state volume from the pool to a build. volumes are created on demand until the pool size is
+// reached and existing leases held by the build are returned as-is. an empty name is returned when the pool is
+// disabled or every volume is leased by a running build.
+func (r *ContainerImageBuildReconciler) leaseStateVolume(ctx context.Context, cib *forgev1alpha1.ContainerImageBuild) (string, error) {
+	if r.JobConfig.StateVolumePoolSize <= 0 {
+		return "", nil
+	}
+
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, pvcs, client.InNamespace(cib.Namespace), client.HasLabels{stateVolumeLabel}); err != nil {
+		return "", err
+	}
+
+	existing := map[string]bool{}
+	for i := range pvcs.Items {
+		existing[pvcs.Items[i].Name] = true
+		if pvcs.Items[i].Annotations[stateVolumeLeaseAnnotation] == cib.Name {
+			return pvcs.Items[i].Name, nil
+		}
+	}
+
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if pvc.DeletionTimestamp != nil {
+			continue
+		}
+
+		free, err := r.stateVolumeLeaseExpired(ctx, pvc)
+		if err != nil {
+			return "", err
+		}
+		if !free {
+			continue
+		}
+
+		if pvc.Annotations == nil {
+			pvc.Annotations = map[string]string{}
+		}
+		pvc.Annotations[stateVolumeLeaseAnnotation] = cib.Name
+
+		// updates are rejected when another reconcile leased the volume in the meantime
+		if err := r.Update(ctx, pvc); err != nil {
+			if apierrors.IsConflict(err) {
+				continue
+			}
+			return "", err
+		}
+		return pvc.Name, nil
+	}
+
+	for i := 0; i < r.JobConfig.StateVolumePoolSize; i++ {
+		name := fmt.Sprintf("%s%d", stateVolumePrefix, i)
+		if existing[name] {
+			continue
+		}
+
+		if err := r.Create(ctx, r.newStateVolume(cib, name)); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				continue
+			}
+			return "", err
+		}
+		return name, nil
+	}
+
+	return "", nil
+}
+
+// releases the state volume leased by a finished build once its pod has stopped using it
+func (r *ContainerImageBuildReconciler) releaseStateVolume(ctx context.Context, cib *forgev1alpha1.ContainerImageBuild) error {
+	if r.JobConfig.StateVolumePoolSize <= 0 {
+		return nil
+	}
+
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, pvcs, client.InNamespace(cib.Namespace), client.HasLabels{stateVolumeLabel}); err != nil {
+		return err
+	}
+
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if pvc.Annotations[stateVolumeLeaseAnnotation] != cib.Name {
+			continue
+		}
+
+		active, err := r.buildJobActive(ctx, cib)
+		if err != nil || active {
+			return err
+		}
+
+		delete(pvc.Annotations, stateVolumeLeaseAnnotation)
+		if err := r.Update(ctx, pvc); err != nil && !apierrors.IsConflict(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// determines whether the build holding a state volume lease is done with it. leases are left behind when builds are
+// deleted or the controller misses their completion.
+func (r *ContainerImageBuildReconciler) stateVolumeLeaseExpired(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (bool, error) {
+	holder := pvc.Annotations[stateVolumeLeaseAnnotation]
+	if holder == "" {
+		return true, nil
+	}
+
+	cib := &forgev1alpha1.ContainerImageBuild{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: pvc.Namespace, Name: holder}, cib); err != nil {
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if !cib.Status.State.IsFinished() {
+		return false, nil
+	}
+
+	active, err := r.buildJobActive(ctx, cib)
+	return !active, err
+}
+
+// reports whether the job of a build still has running pods
+func (r *ContainerImageBuildReconciler) buildJobActive(ctx context.Context, cib *forgev1alpha1.ContainerImageBuild) (bool, error) {
+	job := &batchv1.Job{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(cib), job); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return job.Status.Active > 0, nil
+}
+
+// generates a pool volume leased to a build. volumes are not owned by builds so that they outlive them.
+func (r *ContainerImageBuildReconciler) newStateVolume(cib *forgev1alpha1.ContainerImageBuild, name string) *corev1.PersistentVolumeClaim {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   cib.Namespace,
+			Labels:      map[string]string{stateVolumeLabel: "true"},
+			Annotations: map[string]string{stateVolumeLeaseAnnotation: cib.Name},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: r.JobConfig.StateVolumeSize},
+			},
+		},
+	}
+	if sc := r.JobConfig.StateVolumeStorageClass; sc != "" {
+		pvc.Spec.StorageClassName = &sc
+	}
+
+	return pvc
+}
+
+// returns the number of bytes of build cache a build job may keep in its state directory
+func (r *ContainerImageBuildReconciler) stateGCKeepStorage() int64 {
+	if r.JobConfig.StateGCKeepStorage > 0 {
+		return r.JobConfig.StateGCKeepStorage
+	}
+	if r.JobConfig.StateVolumePoolSize > 0 {
+		// leave headroom for the image being built and pushed
+		return r.JobConfig.StateVolumeSize.Value() / 10 * 8
+	}
+	return 0
+}
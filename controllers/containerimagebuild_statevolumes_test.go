@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+	"github.com/dominodatalab/forge/internal/config"
+)
+
+func makeStateVolumeController(t *testing.T, poolSize int, objs ...client.Object) *ContainerImageBuildReconciler {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, forgev1alpha1.AddToScheme(scheme))
+
+	return &ContainerImageBuildReconciler{
+		Log:      log.NullLogger{},
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		Recorder: record.NewFakeRecorder(10),
+		Scheme:   scheme,
+		JobConfig: &BuildJobConfig{
+			StateVolumePoolSize:     poolSize,
+			StateVolumeSize:         resource.MustParse("20Gi"),
+			StateVolumeStorageClass: "fast",
+		},
+	}
+}
+
+func stateVolumeBuild(name string, state forgev1alpha1.BuildState) *forgev1alpha1.ContainerImageBuild {
+	return &forgev1alpha1.ContainerImageBuild{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test-ns"},
+		Status:     forgev1alpha1.ContainerImageBuildStatus{State: state},
+	}
+}
+
+func stateVolumeLeasedBy(t *testing.T, r *ContainerImageBuildReconciler, name string) string {
+	pvc := &corev1.PersistentVolumeClaim{}
+	require.NoError(t, r.Get(context.Background(), client.ObjectKey{Namespace: "test-ns", Name: name}, pvc))
+	return pvc.Annotations[stateVolumeLeaseAnnotation]
+}
+
+func TestContainerImageBuildReconciler_leaseStateVolume(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("disabled", func(t *testing.T) {
+		r := makeStateVolumeController(t, 0)
+
+		name, err := r.leaseStateVolume(ctx, stateVolumeBuild("build-1", ""))
+		require.NoError(t, err)
+		assert.Empty(t, name)
+	})
+
+	t.Run("exclusive", func(t *testing.T) {
+		build1 := stateVolumeBuild("build-1", forgev1alpha1.BuildStateBuilding)
+		build2 := stateVolumeBuild("build-2", forgev1alpha1.BuildStateBuilding)
+		build3 := stateVolumeBuild("build-3", "")
+		r := makeStateVolumeController(t, 2, build1, build2, build3)
+
+		name, err := r.leaseStateVolume(ctx, build1)
+		require.NoError(t, err)
+		assert.Equal(t, "forge-state-0", name)
+
+		pvc := &corev1.PersistentVolumeClaim{}
+		require.NoError(t, r.Get(ctx, client.ObjectKey{Namespace: "test-ns", Name: name}, pvc))
+		assert.Equal(t, "true", pvc.Labels[stateVolumeLabel])
+		assert.Equal(t, "fast", *pvc.Spec.StorageClassName)
+		assert.Equal(t, resource.MustParse("20Gi"), pvc.Spec.Resources.Requests[corev1.ResourceStorage])
+		assert.Empty(t, pvc.OwnerReferences)
+
+		// retried reconciles keep their lease
+		name, err = r.leaseStateVolume(ctx, build1)
+		require.NoError(t, err)
+		assert.Equal(t, "forge-state-0", name)
+
+		name, err = r.leaseStateVolume(ctx, build2)
+		require.NoError(t, err)
+		assert.Equal(t, "forge-state-1", name)
+
+		name, err = r.leaseStateVolume(ctx, build3)
+		require.NoError(t, err)
+		assert.Empty(t, name, "exhausted pools fall back to ephemeral state")
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		finished := stateVolumeBuild("finished", forgev1alpha1.BuildStateCompleted)
+		running := stateVolumeBuild("running", forgev1alpha1.BuildStateCompleted)
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: running.Name, Namespace: running.Namespace},
+			Status:     batchv1.JobStatus{Active: 1},
+		}
+		pvc := func(name, holder string) *corev1.PersistentVolumeClaim {
+			return &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "test-ns",
+				Labels:      map[string]string{stateVolumeLabel: "true"},
+				Annotations: map[string]string{stateVolumeLeaseAnnotation: holder},
+			}}
+		}
+		build := stateVolumeBuild("build", "")
+		other := stateVolumeBuild("other", "")
+
+		r := makeStateVolumeController(t, 3, finished, running, job, build, other,
+			pvc("forge-state-0", running.Name), pvc("forge-state-1", "deleted"), pvc("forge-state-2", finished.Name))
+
+		name, err := r.leaseStateVolume(ctx, build)
+		require.NoError(t, err)
+		assert.Equal(t, "forge-state-1", name)
+		assert.Equal(t, build.Name, stateVolumeLeasedBy(t, r, name))
+
+		name, err = r.leaseStateVolume(ctx, other)
+		require.NoError(t, err)
+		assert.Equal(t, "forge-state-2", name)
+
+		name, err = r.leaseStateVolume(ctx, stateVolumeBuild("another", ""))
+		require.NoError(t, err)
+		assert.Empty(t, name, "builds with active pods keep their lease after finishing")
+	})
+}
+
+func TestContainerImageBuildReconciler_releaseStateVolume(t *testing.T) {
+	ctx := context.Background()
+
+	build := stateVolumeBuild("build", forgev1alpha1.BuildStateCompleted)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: build.Name, Namespace: build.Namespace},
+		Status:     batchv1.JobStatus{Active: 1},
+	}
+	r := makeStateVolumeController(t, 1, build, job)
+
+	name, err := r.leaseStateVolume(ctx, build)
+	require.NoError(t, err)
+
+	require.NoError(t, r.releaseStateVolume(ctx, build))
+	assert.Equal(t, build.Name, stateVolumeLeasedBy(t, r, name))
+
+	job.Status.Active = 0
+	require.NoError(t, r.Update(ctx, job))
+
+	require.NoError(t, r.releaseStateVolume(ctx, build))
+	assert.Empty(t, stateVolumeLeasedBy(t, r, name))
+}
+
+func TestContainerImageBuildReconciler_stateVolumeMount(t *testing.T) {
+	cib := stateVolumeBuild("test-cib-state", "")
+	r := makeStateVolumeController(t, 1, cib)
+	require.NoError(t, r.createJobForBuild(context.Background(), cib))
+
+	job := &batchv1.Job{}
+	require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(cib), job))
+
+	assert.Contains(t, job.Spec.Template.Spec.Volumes, corev1.Volume{
+		Name: stateDirVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "forge-state-0"},
+		},
+	})
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{Name: stateDirVolumeName, MountPath: config.GetStateDir()})
+}
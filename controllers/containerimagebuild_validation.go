@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+)
+
+// validates a build before launching its job. the first return value describes why a build is invalid, the second is
+// an error encountered while validating that should be retried.
+func (r *ContainerImageBuildReconciler) validateBuild(ctx context.Context, cib *forgev1alpha1.ContainerImageBuild) (invalid error, err error) {
+	if err := cib.Spec.Validate(); err != nil {
+		return err, nil
+	}
+	if r.workerMode() {
+		if err := validateWorkerBuild(cib); err != nil {
+			return err, nil
+		}
+	}
+	if err := r.validateBuilder(cib); err != nil {
+		return err, nil
+	}
+
+	return r.validateInlineContext(ctx, cib)
+}
+
+// enforces the inline context size limit across inline files, the inline dockerfile and the files config map
+func (r *ContainerImageBuildReconciler) validateInlineContext(ctx context.Context, cib *forgev1alpha1.ContainerImageBuild) (invalid error, err error) {
+	size := int64(cib.Spec.InlineContextSize())
+
+	if name := cib.Spec.FilesConfigMap; name != "" {
+		// read directly from the api server to avoid caching every config map in the cluster
+		cm := &corev1.ConfigMap{}
+		if err := r.APIReader.Get(ctx, types.NamespacedName{Name: name, Namespace: cib.Namespace}, cm); err != nil {
+			if apierrors.IsNotFound(err) {
+				return fmt.Errorf("files config map %q does not exist", name), nil
+			}
+			return nil, err
+		}
+
+		for key, value := range cm.Data {
+			size += int64(len(key) + len(value))
+		}
+		for key, value := range cm.BinaryData {
+			size += int64(len(key) + len(value))
+		}
+	}
+
+	if limit := r.JobConfig.InlineContextMaxSize; limit > 0 && size > limit {
+		return fmt.Errorf("inline build context is %d bytes which exceeds the limit of %d bytes", size, limit), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+)
+
+func TestContainerImageBuildReconciler_validateBuild(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "build-files", Namespace: "test-ns"},
+		Data:       map[string]string{"requirements.txt": "numpy==1.21.0"},
+		BinaryData: map[string][]byte{"blob.bin": make([]byte, 64)},
+	}
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cm).Build()
+
+	testCases := []struct {
+		name    string
+		spec    forgev1alpha1.ContainerImageBuildSpec
+		limit   int64
+		invalid string
+	}{
+		{
+			name:  "inline_within_limit",
+			spec:  forgev1alpha1.ContainerImageBuildSpec{Dockerfile: "FROM scratch"},
+			limit: 100,
+		},
+		{
+			name:    "inline_exceeds_limit",
+			spec:    forgev1alpha1.ContainerImageBuildSpec{Dockerfile: "FROM scratch", Files: map[string]string{"app.py": "print('hello world')"}},
+			limit:   20,
+			invalid: "exceeds the limit of 20 bytes",
+		},
+		{
+			name:  "no_limit",
+			spec:  forgev1alpha1.ContainerImageBuildSpec{Dockerfile: "FROM scratch"},
+			limit: 0,
+		},
+		{
+			name:    "config_map_exceeds_limit",
+			spec:    forgev1alpha1.ContainerImageBuildSpec{FilesConfigMap: "build-files"},
+			limit:   64,
+			invalid: "exceeds the limit of 64 bytes",
+		},
+		{
+			name:    "config_map_missing",
+			spec:    forgev1alpha1.ContainerImageBuildSpec{FilesConfigMap: "missing"},
+			limit:   1024,
+			invalid: `files config map "missing" does not exist`,
+		},
+		{
+			name:    "invalid_spec",
+			spec:    forgev1alpha1.ContainerImageBuildSpec{},
+			invalid: "are required",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &ContainerImageBuildReconciler{
+				APIReader: reader,
+				JobConfig: &BuildJobConfig{InlineContextMaxSize: tc.limit},
+			}
+			cib := &forgev1alpha1.ContainerImageBuild{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cib", Namespace: "test-ns"},
+				Spec:       tc.spec,
+			}
+			cib.Spec.PushRegistries = []string{"registry.io"}
+
+			invalid, err := r.validateBuild(context.Background(), cib)
+			require.NoError(t, err)
+			if tc.invalid == "" {
+				assert.NoError(t, invalid)
+			} else {
+				require.Error(t, invalid)
+				assert.Contains(t, invalid.Error(), tc.invalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+)
+
+const (
+	// ExecutionModeJob runs every build inside a dedicated job.
+	ExecutionModeJob = "job"
+	// ExecutionModeWorker dispatches builds to a pool of long-running worker pods.
+	ExecutionModeWorker = "worker"
+
+	// delay between checks for idle workers while every worker is busy
+	workerDispatchRetryInterval = 10 * time.Second
+	// delay between checks that the worker running a build is still alive
+	workerCheckInterval = 30 * time.Second
+	// time a worker has to start running a dispatched build before the build is failed
+	workerPickupTimeout = 2 * time.Minute
+)
+
+// SupportedExecutionModes lists the ways builds can be run.
+var SupportedExecutionModes = []string{ExecutionModeJob, ExecutionModeWorker}
+
+// reports whether builds are dispatched to workers instead of being run in jobs
+func (r *ContainerImageBuildReconciler) workerMode() bool {
+	return r.JobConfig.ExecutionMode == ExecutionModeWorker
+}
+
+// rejects builds relying on features of dedicated build pods
+func validateWorkerBuild(cib *forgev1alpha1.ContainerImageBuild) error {
+	vc, err := forgev1alpha1.ParseVolumeContext(cib.Spec.Context)
+	if err != nil {
+		return err
+	}
+	if vc != nil {
+		return fmt.Errorf("volume contexts are not supported by build workers")
+	}
+	if len(cib.Spec.InitContainers) != 0 {
+		return fmt.Errorf("init containers are not supported by build workers")
+	}
+
+	return nil
+}
+
+// assigns a build to the least busy worker with spare capacity. returns false when every worker is busy.
+func (r *ContainerImageBuildReconciler) dispatchBuild(ctx context.Context, cib *forgev1alpha1.ContainerImageBuild) (bool, error) {
+	// workers read the generated credentials secret directly, the volumes created for jobs are unused
+	defer func() {
+		r.JobConfig.DynamicVolumes = []corev1.Volume{}
+		r.JobConfig.DynamicVolumeMounts = []corev1.VolumeMount{}
+	}()
+	if err := r.checkCloudRegistrySecrets(ctx, cib); err != nil {
+		return false, err
+	}
+
+	worker, err := r.selectWorker(ctx)
+	if err != nil || worker == "" {
+		return false, err
+	}
+
+	if cib.Labels == nil {
+		cib.Labels = map[string]string{}
+	}
+	cib.Labels[forgev1alpha1.WorkerLabel] = worker
+	if cib.Annotations == nil {
+		cib.Annotations = map[string]string{}
+	}
+	cib.Annotations[forgev1alpha1.WorkerDispatchedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	if err := r.Update(ctx, cib); err != nil {
+		return false, err
+	}
+
+	r.Recorder.Event(cib, corev1.EventTypeNormal, "Dispatched", fmt.Sprintf("Build dispatched to worker %s", worker))
+	return true, nil
+}
+
+// returns the name of the ready worker running the fewest builds relative to its concurrency
+func (r *ContainerImageBuildReconciler) selectWorker(ctx context.Context) (string, error) {
+	// worker pods may live outside of the namespace cached by the manager
+	pods := &corev1.PodList{}
+	if err := r.APIReader.List(ctx, pods, client.InNamespace(r.JobConfig.WorkerNamespace), client.MatchingLabels(r.JobConfig.WorkerSelector)); err != nil {
+		return "", err
+	}
+
+	builds := &forgev1alpha1.ContainerImageBuildList{}
+	if err := r.List(ctx, builds, client.HasLabels{forgev1alpha1.WorkerLabel}); err != nil {
+		return "", err
+	}
+	load := map[string]int{}
+	for _, build := range builds.Items {
+		if !build.Status.State.IsFinished() {
+			load[build.Labels[forgev1alpha1.WorkerLabel]]++
+		}
+	}
+
+	sort.Slice(pods.Items, func(i, j int) bool { return pods.Items[i].Name < pods.Items[j].Name })
+
+	var selected string
+	var selectedUsage float64
+	for _, pod := range pods.Items {
+		if !workerReady(&pod) {
+			continue
+		}
+
+		usage := float64(load[pod.Name]) / float64(workerConcurrency(&pod))
+		if usage < 1 && (selected == "" || usage < selectedUsage) {
+			selected, selectedUsage = pod.Name, usage
+		}
+	}
+
+	return selected, nil
+}
+
+// checks that the worker running an unfinished build is still alive and started running the build in time, and fails
+// the build otherwise. returns true when the build was transitioned into a failed state.
+func (r *ContainerImageBuildReconciler) checkBuildWorker(ctx context.Context, cib *forgev1alpha1.ContainerImageBuild) (bool, error) {
+	worker := cib.Labels[forgev1alpha1.WorkerLabel]
+
+	pod := &corev1.Pod{}
+	err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: r.JobConfig.WorkerNamespace, Name: worker}, pod)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, err
+	}
+	if err != nil || pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+		failure := &jobFailure{
+			Reason:  forgev1alpha1.BuildReasonWorkerLost,
+			Message: fmt.Sprintf("worker %s stopped before the build finished", worker),
+		}
+		return true, r.failBuild(ctx, cib, failure)
+	}
+
+	// workers that are alive can still miss a build, e.g. when they are stuck or watching another namespace
+	if !pickupExpired(cib) {
+		return false, nil
+	}
+	failure := &jobFailure{
+		Reason:  forgev1alpha1.BuildReasonWorkerUnresponsive,
+		Message: fmt.Sprintf("worker %s did not start the build within %s of dispatching it", worker, workerPickupTimeout),
+	}
+	return true, r.failBuild(ctx, cib, failure)
+}
+
+// reports whether a dispatched build is still waiting for its worker after the pickup timeout. builds dispatched
+// without a recorded dispatch time are never considered expired.
+func pickupExpired(cib *forgev1alpha1.ContainerImageBuild) bool {
+	switch cib.Status.State {
+	case "", forgev1alpha1.BuildStateInitialized:
+	default:
+		return false
+	}
+
+	dispatchedAt, err := time.Parse(time.RFC3339, cib.Annotations[forgev1alpha1.WorkerDispatchedAtAnnotation])
+	if err != nil {
+		return false
+	}
+	return time.Since(dispatchedAt) > workerPickupTimeout
+}
+
+func workerReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// workers advertise their concurrency once they have started, assume one build until then
+func workerConcurrency(pod *corev1.Pod) int {
+	if n, err := strconv.Atoi(pod.Annotations[forgev1alpha1.WorkerConcurrencyAnnotation]); err == nil && n > 0 {
+		return n
+	}
+	return 1
+}
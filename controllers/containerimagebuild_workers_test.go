@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+)
+
+func makeWorkerController(t *testing.T, objs ...client.Object) *ContainerImageBuildReconciler {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, forgev1alpha1.AddToScheme(scheme))
+
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	return &ContainerImageBuildReconciler{
+		Log:       log.NullLogger{},
+		Client:    fakeClient,
+		APIReader: fakeClient,
+		Recorder:  record.NewFakeRecorder(10),
+		Scheme:    scheme,
+		JobConfig: &BuildJobConfig{
+			ExecutionMode:   ExecutionModeWorker,
+			WorkerNamespace: "forge",
+			WorkerSelector:  map[string]string{"app": "forge-worker"},
+		},
+	}
+}
+
+func workerPod(name string, concurrency string, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "forge",
+			Labels:    map[string]string{"app": "forge-worker"},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+	if concurrency != "" {
+		pod.Annotations = map[string]string{forgev1alpha1.WorkerConcurrencyAnnotation: concurrency}
+	}
+	return pod
+}
+
+func workerBuild(name, worker string, state forgev1alpha1.BuildState) *forgev1alpha1.ContainerImageBuild {
+	cib := &forgev1alpha1.ContainerImageBuild{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test-ns"},
+		Spec:       forgev1alpha1.ContainerImageBuildSpec{Context: "https://example.com/context.tgz", ImageName: "app", PushRegistries: []string{"registry.io"}},
+		Status:     forgev1alpha1.ContainerImageBuildStatus{State: state},
+	}
+	if worker != "" {
+		cib.Labels = map[string]string{forgev1alpha1.WorkerLabel: worker}
+	}
+	return cib
+}
+
+func TestContainerImageBuildReconciler_selectWorker(t *testing.T) {
+	testCases := []struct {
+		name     string
+		objs     []client.Object
+		expected string
+	}{
+		{
+			name: "no workers",
+		},
+		{
+			name:     "least busy",
+			objs:     []client.Object{workerPod("worker-a", "2", true), workerPod("worker-b", "2", true), workerBuild("b1", "worker-a", forgev1alpha1.BuildStateBuilding)},
+			expected: "worker-b",
+		},
+		{
+			name:     "relative to concurrency",
+			objs:     []client.Object{workerPod("worker-a", "4", true), workerPod("worker-b", "", true), workerBuild("b1", "worker-a", forgev1alpha1.BuildStateBuilding), workerBuild("b2", "worker-b", forgev1alpha1.BuildStateBuilding)},
+			expected: "worker-a",
+		},
+		{
+			name:     "finished builds",
+			objs:     []client.Object{workerPod("worker-a", "", true), workerBuild("b1", "worker-a", forgev1alpha1.BuildStateCompleted)},
+			expected: "worker-a",
+		},
+		{
+			name: "busy",
+			objs: []client.Object{workerPod("worker-a", "", true), workerBuild("b1", "worker-a", "")},
+		},
+		{
+			name: "not ready",
+			objs: []client.Object{workerPod("worker-a", "", false)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := makeWorkerController(t, tc.objs...)
+
+			worker, err := r.selectWorker(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, worker)
+		})
+	}
+}
+
+func TestContainerImageBuildReconciler_ReconcileWorker(t *testing.T) {
+	ctx := context.Background()
+	cib := workerBuild("test-cib", "", "")
+	r := makeWorkerController(t, cib, workerPod("worker-a", "", true))
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(cib)}
+
+	res, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, workerCheckInterval, res.RequeueAfter)
+
+	actual := &forgev1alpha1.ContainerImageBuild{}
+	require.NoError(t, r.Get(ctx, req.NamespacedName, actual))
+	assert.Equal(t, "worker-a", actual.Labels[forgev1alpha1.WorkerLabel])
+	assert.NotEmpty(t, actual.Annotations[forgev1alpha1.WorkerDispatchedAtAnnotation])
+
+	// dispatched builds are only checked until the worker reports back
+	res, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, workerCheckInterval, res.RequeueAfter)
+
+	other := workerBuild("other-cib", "", "")
+	require.NoError(t, r.Create(ctx, other))
+	res, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(other)})
+	require.NoError(t, err)
+	assert.Equal(t, workerDispatchRetryInterval, res.RequeueAfter, "busy workers delay dispatching")
+}
+
+func TestContainerImageBuildReconciler_checkBuildWorker(t *testing.T) {
+	ctx := context.Background()
+
+	stopped := workerPod("worker-b", "", false)
+	stopped.Status.Phase = corev1.PodFailed
+
+	testCases := []struct {
+		name   string
+		worker string
+		failed bool
+	}{
+		{"running", "worker-a", false},
+		{"stopped", "worker-b", true},
+		{"deleted", "worker-c", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cib := workerBuild("test-cib", tc.worker, forgev1alpha1.BuildStateBuilding)
+			r := makeWorkerController(t, cib, workerPod("worker-a", "", true), stopped)
+
+			failed, err := r.checkBuildWorker(ctx, cib)
+			require.NoError(t, err)
+			assert.Equal(t, tc.failed, failed)
+
+			actual := &forgev1alpha1.ContainerImageBuild{}
+			require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cib), actual))
+			if tc.failed {
+				assert.Equal(t, forgev1alpha1.BuildStateFailed, actual.Status.State)
+				assert.Equal(t, forgev1alpha1.BuildReasonWorkerLost, actual.Status.Reason)
+			} else {
+				assert.Equal(t, forgev1alpha1.BuildStateBuilding, actual.Status.State)
+			}
+		})
+	}
+}
+
+func TestContainerImageBuildReconciler_checkBuildWorkerPickup(t *testing.T) {
+	ctx := context.Background()
+	expired := time.Now().Add(-workerPickupTimeout - time.Minute).UTC().Format(time.RFC3339)
+	recent := time.Now().UTC().Format(time.RFC3339)
+
+	testCases := []struct {
+		name         string
+		state        forgev1alpha1.BuildState
+		dispatchedAt string
+		failed       bool
+	}{
+		{"waiting", "", recent, false},
+		{"expired", "", expired, true},
+		{"expired_initialized", forgev1alpha1.BuildStateInitialized, expired, true},
+		{"started", forgev1alpha1.BuildStateBuilding, expired, false},
+		{"unknown_dispatch_time", "", "", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cib := workerBuild("test-cib", "worker-a", tc.state)
+			if tc.dispatchedAt != "" {
+				cib.Annotations = map[string]string{forgev1alpha1.WorkerDispatchedAtAnnotation: tc.dispatchedAt}
+			}
+			r := makeWorkerController(t, cib, workerPod("worker-a", "", true))
+
+			failed, err := r.checkBuildWorker(ctx, cib)
+			require.NoError(t, err)
+			assert.Equal(t, tc.failed, failed)
+
+			actual := &forgev1alpha1.ContainerImageBuild{}
+			require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(cib), actual))
+			if tc.failed {
+				assert.Equal(t, forgev1alpha1.BuildStateFailed, actual.Status.State)
+				assert.Equal(t, forgev1alpha1.BuildReasonWorkerUnresponsive, actual.Status.Reason)
+			} else {
+				assert.Equal(t, tc.state, actual.Status.State)
+			}
+		})
+	}
+}
+
+func TestValidateWorkerBuild(t *testing.T) {
+	cib := workerBuild("test-cib", "", "")
+	assert.NoError(t, validateWorkerBuild(cib))
+
+	cib.Spec.Context = "pvc://sources"
+	assert.Error(t, validateWorkerBuild(cib))
+
+	cib = workerBuild("test-cib", "", "")
+	cib.Spec.InitContainers = []forgev1alpha1.InitContainer{{Name: "init", Image: "alpine"}}
+	assert.Error(t, validateWorkerBuild(cib))
+}
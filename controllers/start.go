@@ -9,10 +9,12 @@ import (
 	"github.com/newrelic/go-agent/v3/newrelic"
 	"go.uber.org/zap"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	ctrlzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
@@ -76,6 +78,12 @@ func StartManager(cfg ControllerConfig) error {
 		LeaderElectionID:   leaderElectionID,
 		Port:               9443,
 		Namespace:          cfg.Namespace,
+		// only build pods are watched, caching every pod in the cluster is wasteful
+		NewCache: cache.BuilderWithOptions(cache.Options{
+			SelectorsByObject: cache.SelectorsByObject{
+				&corev1.Pod{}: {Label: buildPodSelector()},
+			},
+		}),
 	})
 	if err != nil {
 		setupLog.Error(err, "Unable to start manager")
@@ -85,6 +93,7 @@ func StartManager(cfg ControllerConfig) error {
 	controller := &ContainerImageBuildReconciler{
 		Log:       ctrl.Log.WithName("controllers").WithName("ContainerImageBuild"),
 		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
 		Scheme:    mgr.GetScheme(),
 		Recorder:  mgr.GetEventRecorderFor("containerimagebuild-controller"),
 		JobConfig: cfg.JobConfig,
@@ -0,0 +1,95 @@
+package archive
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// matches the redirect limit of the default client
+const maxRedirects = 10
+
+// Auth holds credentials and TLS settings used when downloading context archives.
+type Auth struct {
+	// BearerToken is sent using the Authorization header.
+	BearerToken string
+	// Username and Password are sent using basic authentication.
+	Username string
+	Password string
+	// Headers are added to every request.
+	Headers map[string]string
+	// CABundle contains PEM-encoded certificates trusted in addition to the system roots.
+	CABundle []byte
+}
+
+// apply adds credentials to a request. Clients created by newHTTPClient drop them when a redirect leads to another
+// host, so pre-signed redirect targets do not receive them.
+func (a *Auth) apply(req *http.Request) {
+	if a == nil {
+		return
+	}
+
+	for k, v := range a.Headers {
+		req.Header.Set(k, v)
+	}
+
+	switch {
+	case a.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+a.BearerToken)
+	case a.Username != "" || a.Password != "":
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+}
+
+// headers holds the names of every header set by apply
+func (a *Auth) headers() []string {
+	names := []string{"Authorization"}
+	for k := range a.Headers {
+		names = append(names, k)
+	}
+	return names
+}
+
+// checkRedirect drops credentials from redirects to another host or scheme. Go only strips its own sensitive headers
+// on host changes and would forward custom headers to any host, and all of them over an https to http downgrade.
+func (a *Auth) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	if req.URL.Scheme != via[0].URL.Scheme || req.URL.Host != via[0].URL.Host {
+		for _, name := range a.headers() {
+			req.Header.Del(name)
+		}
+	}
+	return nil
+}
+
+// newHTTPClient returns the default client unless credentials or a custom CA bundle are used.
+func newHTTPClient(auth *Auth) (*http.Client, error) {
+	if auth == nil {
+		return http.DefaultClient, nil
+	}
+
+	client := &http.Client{CheckRedirect: auth.checkRedirect}
+	if len(auth.CABundle) == 0 {
+		return client, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(auth.CABundle) {
+		return nil, errors.New("context CA bundle does not contain any valid PEM certificates")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+	client.Transport = transport
+
+	return client, nil
+}
@@ -0,0 +1,154 @@
+package archive
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchAndExtract_Auth(t *testing.T) {
+	bs, err := ioutil.ReadFile("testdata/simple-app.tgz")
+	require.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" || r.Header.Get("X-Tenant") != "forge" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write(bs)
+	}))
+	// untrusted clients trigger handshake errors that are expected
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	testCases := []struct {
+		name string
+		auth *Auth
+		ok   bool
+	}{
+		{"authorized", &Auth{BearerToken: "s3cr3t", Headers: map[string]string{"X-Tenant": "forge"}, CABundle: caBundle}, true},
+		{"missing_headers", &Auth{BearerToken: "s3cr3t", CABundle: caBundle}, false},
+		{"untrusted_ca", &Auth{BearerToken: "s3cr3t", Headers: map[string]string{"X-Tenant": "forge"}}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wd, err := ioutil.TempDir("", "forge-")
+			require.NoError(t, err)
+			defer os.RemoveAll(wd)
+
+			_, err = FetchAndExtract(logger, context.TODO(), srv.URL, wd, Options{Auth: tc.auth})
+			if tc.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	t.Run("invalid_ca_bundle", func(t *testing.T) {
+		_, err := newHTTPClient(&Auth{CABundle: []byte("garbage")})
+		assert.Error(t, err)
+	})
+}
+
+func TestAuth_apply(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/context.tgz", nil)
+	(&Auth{Username: "user", Password: "pass"}).apply(req)
+
+	username, password, ok := req.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "pass", password)
+
+	req = httptest.NewRequest(http.MethodGet, "https://example.com/context.tgz", nil)
+	var auth *Auth
+	auth.apply(req)
+	assert.Empty(t, req.Header)
+}
+
+func TestFetchAndExtract_AuthRedirect(t *testing.T) {
+	bs, err := ioutil.ReadFile("testdata/simple-app.tgz")
+	require.NoError(t, err)
+
+	var received http.Header
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		_, _ = w.Write(bs)
+	}))
+	defer target.Close()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/same-host" {
+			received = r.Header.Clone()
+			_, _ = w.Write(bs)
+			return
+		}
+		if r.URL.Query().Get("host") == "same" {
+			http.Redirect(w, r, "/same-host", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, target.URL+"/presigned", http.StatusFound)
+	}))
+	defer origin.Close()
+
+	auth := &Auth{BearerToken: "s3cr3t", Headers: map[string]string{"X-Api-Key": "k3y"}}
+
+	t.Run("other_host", func(t *testing.T) {
+		_, err := FetchAndExtract(logger, context.TODO(), origin.URL, t.TempDir(), Options{Auth: auth})
+		require.NoError(t, err)
+		assert.Empty(t, received.Get("Authorization"))
+		assert.Empty(t, received.Get("X-Api-Key"))
+	})
+
+	t.Run("same_host", func(t *testing.T) {
+		_, err := FetchAndExtract(logger, context.TODO(), origin.URL+"?host=same", t.TempDir(), Options{Auth: auth})
+		require.NoError(t, err)
+		assert.Equal(t, "Bearer s3cr3t", received.Get("Authorization"))
+		assert.Equal(t, "k3y", received.Get("X-Api-Key"))
+	})
+}
+
+func TestAuth_checkRedirect(t *testing.T) {
+	auth := &Auth{BearerToken: "s3cr3t", Headers: map[string]string{"X-Api-Key": "k3y"}}
+
+	testcases := []struct {
+		name  string
+		from  string
+		to    string
+		strip bool
+	}{
+		{"same_origin", "https://example.com/context.tgz", "https://example.com/other.tgz", false},
+		{"other_host", "https://example.com/context.tgz", "https://storage.example.com/context.tgz", true},
+		{"scheme_downgrade", "https://example.com/context.tgz", "http://example.com/context.tgz", true},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			via := httptest.NewRequest(http.MethodGet, tc.from, nil)
+			auth.apply(via)
+
+			req := httptest.NewRequest(http.MethodGet, tc.to, nil)
+			req.Header = via.Header.Clone()
+			require.NoError(t, auth.checkRedirect(req, []*http.Request{via}))
+
+			if tc.strip {
+				assert.Empty(t, req.Header.Get("Authorization"))
+				assert.Empty(t, req.Header.Get("X-Api-Key"))
+			} else {
+				assert.Equal(t, "Bearer s3cr3t", req.Header.Get("Authorization"))
+				assert.Equal(t, "k3y", req.Header.Get("X-Api-Key"))
+			}
+		})
+	}
+}
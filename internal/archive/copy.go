@@ -0,0 +1,60 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// CopyTree copies the contents of src into dst with the same checks applied to archive entries. Symlinks are copied
+// as links and must resolve inside of dst, special files are skipped.
+func CopyTree(src, dst string, limits Limits) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	u, err := newUnpacker(dst, limits)
+	if err != nil {
+		return err
+	}
+
+	err = filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		name, err := u.entry(rel)
+		if err != nil || name == "" {
+			return err
+		}
+
+		switch mode := fi.Mode(); {
+		case mode.IsDir():
+			return u.dir(name, mode, fi.ModTime())
+		case mode.IsRegular():
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			return u.file(name, f, fi.Size(), mode, fi.ModTime())
+		case mode&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return u.symlink(name, target)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return u.finish()
+}
@@ -0,0 +1,54 @@
+package archive
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyTree(t *testing.T) {
+	root, err := ioutil.TempDir("", "forge-copy-")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	src := filepath.Join(root, "src")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "bin"), 0750))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "Dockerfile"), []byte("FROM scratch"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "bin", "run.sh"), []byte("#!/bin/sh"), 0755))
+	require.NoError(t, os.Symlink("bin/run.sh", filepath.Join(src, "run")))
+
+	dst := filepath.Join(root, "dst")
+	require.NoError(t, CopyTree(src, dst, Limits{}))
+
+	bs, err := ioutil.ReadFile(filepath.Join(dst, "Dockerfile"))
+	require.NoError(t, err)
+	assert.Equal(t, "FROM scratch", string(bs))
+
+	fi, err := os.Stat(filepath.Join(dst, "bin", "run.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+
+	fi, err = os.Stat(filepath.Join(dst, "bin"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), fi.Mode().Perm())
+
+	target, err := os.Readlink(filepath.Join(dst, "run"))
+	require.NoError(t, err)
+	assert.Equal(t, "bin/run.sh", target)
+
+	t.Run("escaping_symlink", func(t *testing.T) {
+		require.NoError(t, os.Symlink("../../etc", filepath.Join(src, "escape")))
+		err := CopyTree(src, filepath.Join(root, "escaped"), Limits{})
+		assert.True(t, errors.Is(err, ErrUnsafePath), "expected unsafe path error, got %v", err)
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		err := CopyTree(src, filepath.Join(root, "limited"), Limits{MaxSize: 4})
+		assert.True(t, errors.Is(err, ErrLimitExceeded), "expected limit error, got %v", err)
+	})
+}
@@ -1,10 +1,13 @@
 package archive
 
 import (
-	"archive/tar"
 	"bufio"
+	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
 	"context"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
 	"errors"
 	"fmt"
 	"io"
@@ -15,8 +18,12 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/containerd/containerd/remotes/docker"
 	"github.com/go-logr/logr"
 	"github.com/h2non/filetype"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	"github.com/ulikunitz/xz"
 	"k8s.io/apimachinery/pkg/util/wait"
 
 	"github.com/dominodatalab/forge/internal/util"
@@ -25,10 +32,26 @@ import (
 type mimeType string
 
 const (
-	mimeTypeTar  = mimeType("application/x-tar")
-	mimeTypeGzip = mimeType("application/gzip")
+	mimeTypeTar   = mimeType("application/x-tar")
+	mimeTypeGzip  = mimeType("application/gzip")
+	mimeTypeZstd  = mimeType("application/zstd")
+	mimeTypeXz    = mimeType("application/x-xz")
+	mimeTypeBzip2 = mimeType("application/x-bzip2")
+	mimeTypeZip   = mimeType("application/zip")
 )
 
+var supportedMimeTypes = map[mimeType]bool{
+	mimeTypeTar:   true,
+	mimeTypeGzip:  true,
+	mimeTypeZstd:  true,
+	mimeTypeXz:    true,
+	mimeTypeBzip2: true,
+	mimeTypeZip:   true,
+}
+
+// zstd frames are not recognized by the filetype library
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
 var defaultBackoff = wait.Backoff{
 	Duration: time.Second,
 	Factor:   2,
@@ -38,20 +61,46 @@ var defaultBackoff = wait.Backoff{
 }
 
 type fileDownloader interface {
-	Get(string) (*http.Response, error)
+	Do(*http.Request) (*http.Response, error)
 }
 
-type Extractor func(logr.Logger, context.Context, string, string, time.Duration) (*Extraction, error)
+type Extractor func(logr.Logger, context.Context, string, string, Options) (*Extraction, error)
+
+// Options control how remote archives are fetched and unpacked.
+type Options struct {
+	// Timeout applied to the download and extraction of an archive. Zero means no timeout.
+	Timeout time.Duration
+	// Limits enforced while unpacking an archive.
+	Limits Limits
+	// Digest the downloaded archive must match before it is unpacked. No verification is done when empty.
+	Digest digest.Digest
+	// Auth used when downloading an archive. Optional.
+	Auth *Auth
+	// RegistryHosts provides registry endpoints and credentials used to pull OCI artifacts. Defaults to anonymous
+	// access over https when empty.
+	RegistryHosts docker.RegistryHosts
+}
+
+// DigestMismatchError is returned when a downloaded archive does not match its expected digest.
+type DigestMismatchError struct {
+	Expected digest.Digest
+	Actual   digest.Digest
+}
+
+func (e *DigestMismatchError) Error() string {
+	return fmt.Sprintf("archive digest mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
 
 type Extraction struct {
 	Archive     string
 	ContentsDir string
+	Digest      digest.Digest
 }
 
-func FetchAndExtract(log logr.Logger, ctx context.Context, url, wd string, timeout time.Duration) (*Extraction, error) {
-	if timeout > 0 {
+func FetchAndExtract(log logr.Logger, ctx context.Context, url, wd string, opts Options) (*Extraction, error) {
+	if opts.Timeout > 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, timeout)
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
 		defer cancel()
 	}
 
@@ -61,7 +110,12 @@ func FetchAndExtract(log logr.Logger, ctx context.Context, url, wd string, timeo
 
 	archive := filepath.Join(wd, "archive")
 
-	err := wait.ExponentialBackoff(defaultBackoff, func() (bool, error) {
+	client, err := newHTTPClient(opts.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	err = wait.ExponentialBackoff(defaultBackoff, func() (bool, error) {
 		// TODO in client-go v0.21.0 ExponentialBackoffWithContext can handle this for us
 		select {
 		case <-ctx.Done():
@@ -69,17 +123,34 @@ func FetchAndExtract(log logr.Logger, ctx context.Context, url, wd string, timeo
 		default:
 		}
 
-		return downloadFile(log, http.DefaultClient, url, archive)
+		return downloadFile(log, ctx, client, url, archive, opts.Auth)
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	return unpackArchive(archive, wd, opts)
+}
+
+// verifies and extracts a downloaded archive into the working directory
+func unpackArchive(archive, wd string, opts Options) (*Extraction, error) {
+	algorithm := digest.Canonical
+	if opts.Digest != "" {
+		algorithm = opts.Digest.Algorithm()
+	}
+	observed, err := digestFile(archive, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Digest != "" && observed != opts.Digest {
+		return nil, &DigestMismatchError{Expected: opts.Digest, Actual: observed}
+	}
+
 	ct, err := getFileContentType(archive)
 	if err != nil {
 		return nil, err
 	}
-	if ct != mimeTypeGzip && ct != mimeTypeTar {
+	if !supportedMimeTypes[ct] {
 		return nil, fmt.Errorf("unsupported file type %q", ct)
 	}
 
@@ -87,16 +158,31 @@ func FetchAndExtract(log logr.Logger, ctx context.Context, url, wd string, timeo
 	if err := os.MkdirAll(dest, 0755); err != nil {
 		return nil, err
 	}
-	if err := extract(archive, ct, dest); err != nil {
+	if err := extract(archive, ct, dest, opts.Limits); err != nil {
 		return nil, err
 	}
 
 	return &Extraction{
 		Archive:     archive,
 		ContentsDir: dest,
+		Digest:      observed,
 	}, nil
 }
 
+func digestFile(fp string, algorithm digest.Algorithm) (digest.Digest, error) {
+	if !algorithm.Available() {
+		return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+
+	f, err := os.Open(fp)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return algorithm.FromReader(f)
+}
+
 func retryable(err *url.Error) bool {
 	// If we get any sort of operational error before an HTTP response we retry it.
 	var opError *net.OpError
@@ -104,9 +190,15 @@ func retryable(err *url.Error) bool {
 }
 
 // downloadFile takes a file URL and local location to download it to.
-// It returns "done" (retryable or not) and an error.
-func downloadFile(log logr.Logger, c fileDownloader, fileUrl, fp string) (bool, error) {
-	resp, err := c.Get(fileUrl)
+// It returns "done" (retryable or not) and an error. The download is aborted when the context is done.
+func downloadFile(log logr.Logger, ctx context.Context, c fileDownloader, fileUrl, fp string, auth *Auth) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileUrl, nil)
+	if err != nil {
+		return false, err
+	}
+	auth.apply(req)
+
+	resp, err := c.Do(req)
 	if err != nil {
 		var urlError *url.Error
 		if errors.As(err, &urlError) && retryable(urlError) {
@@ -149,6 +241,10 @@ func getFileContentType(fp string) (ct mimeType, err error) {
 		return
 	}
 
+	if bytes.HasPrefix(buf, zstdMagic) {
+		return mimeTypeZstd, nil
+	}
+
 	kind, err := filetype.Match(buf)
 	if err != nil {
 		return
@@ -157,7 +253,11 @@ func getFileContentType(fp string) (ct mimeType, err error) {
 	return mimeType(kind.MIME.Value), nil
 }
 
-func extract(fp string, ct mimeType, dst string) error {
+func extract(fp string, ct mimeType, dst string, limits Limits) error {
+	if ct == mimeTypeZip {
+		return unzip(fp, dst, limits)
+	}
+
 	f, err := os.Open(fp)
 	if err != nil {
 		return err
@@ -165,7 +265,8 @@ func extract(fp string, ct mimeType, dst string) error {
 	defer f.Close()
 
 	var r io.Reader
-	if ct == mimeTypeGzip {
+	switch ct {
+	case mimeTypeGzip:
 		gzr, err := gzip.NewReader(f)
 		if err != nil {
 			return err
@@ -173,43 +274,26 @@ func extract(fp string, ct mimeType, dst string) error {
 		defer gzr.Close()
 
 		r = gzr
-	} else {
-		r = bufio.NewReader(f)
-	}
-
-	tr := tar.NewReader(r)
-
-	for {
-		header, err := tr.Next()
-
-		switch {
-		case err == io.EOF:
-			return nil
-		case err != nil:
+	case mimeTypeZstd:
+		zr, err := zstd.NewReader(f)
+		if err != nil {
 			return err
-		case header == nil:
-			continue
 		}
+		defer zr.Close()
 
-		target := filepath.Join(dst, header.Name)
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(target, 0755); err != nil {
-				return err
-			}
-		case tar.TypeReg:
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
-			if err != nil {
-				return err
-			}
-
-			_, err = io.Copy(f, tr)
-			f.Close()
-
-			if err != nil {
-				return err
-			}
+		r = zr
+	case mimeTypeXz:
+		xzr, err := xz.NewReader(bufio.NewReader(f))
+		if err != nil {
+			return err
 		}
+
+		r = xzr
+	case mimeTypeBzip2:
+		r = bzip2.NewReader(bufio.NewReader(f))
+	default:
+		r = bufio.NewReader(f)
 	}
+
+	return untar(r, dst, limits)
 }
@@ -2,6 +2,7 @@ package archive
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -12,7 +13,9 @@ import (
 	"path/filepath"
 	"syscall"
 	"testing"
+	"time"
 
+	"github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
@@ -21,7 +24,7 @@ type errClient struct {
 	err error
 }
 
-func (e *errClient) Get(_ string) (*http.Response, error) {
+func (e *errClient) Do(_ *http.Request) (*http.Response, error) {
 	return nil, &url.Error{Err: e.err}
 }
 
@@ -49,6 +52,10 @@ func TestFetchAndExtract(t *testing.T) {
 	}{
 		{"tarball", "testdata/simple-app.tar", []string{"Dockerfile", "app.py"}},
 		{"gzipped-tarball", "testdata/simple-app.tgz", []string{"Dockerfile", "app.py"}},
+		{"zstd-tarball", "testdata/simple-app.tar.zst", []string{"Dockerfile", "app.py"}},
+		{"xz-tarball", "testdata/simple-app.tar.xz", []string{"Dockerfile", "app.py"}},
+		{"bzip2-tarball", "testdata/simple-app.tar.bz2", []string{"Dockerfile", "app.py"}},
+		{"zip", "testdata/simple-app.zip", []string{"Dockerfile", "app.py"}},
 	}
 
 	for _, tc := range cases {
@@ -69,7 +76,7 @@ func TestFetchAndExtract(t *testing.T) {
 			}
 			defer os.RemoveAll(wd)
 
-			ext, err := FetchAndExtract(logger, context.TODO(), srv.URL, wd, 0)
+			ext, err := FetchAndExtract(logger, context.TODO(), srv.URL, wd, Options{})
 			if err != nil {
 				t.Error(err)
 			}
@@ -105,6 +112,65 @@ func TestFetchAndExtract(t *testing.T) {
 		})
 	}
 
+	t.Run("digest", func(t *testing.T) {
+		bs, err := ioutil.ReadFile("testdata/simple-app.tgz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(bs)
+		})
+		expected := digest.FromBytes(bs)
+
+		wd, err := ioutil.TempDir("", "forge-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(wd)
+
+		ext, err := FetchAndExtract(logger, context.TODO(), srv.URL, wd, Options{Digest: expected})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, expected, ext.Digest)
+
+		wrong := digest.FromString("something else")
+		_, err = FetchAndExtract(logger, context.TODO(), srv.URL, wd, Options{Digest: wrong})
+
+		var mismatch *DigestMismatchError
+		if !errors.As(err, &mismatch) {
+			t.Fatalf("expected digest mismatch error, got %v", err)
+		}
+		assert.Equal(t, wrong, mismatch.Expected)
+		assert.Equal(t, expected, mismatch.Actual)
+	})
+
+	t.Run("timeout-during-download", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+		})
+
+		wd, err := ioutil.TempDir("", "forge-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(wd)
+
+		start := time.Now()
+		_, err = FetchAndExtract(logger, context.TODO(), srv.URL, wd, Options{Timeout: 100 * time.Millisecond})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+
 	t.Run("unsupported-format", func(t *testing.T) {
 		t.SkipNow()
 	})
@@ -115,21 +181,21 @@ func Test_downloadFile(t *testing.T) {
 	defer srv.Close()
 
 	t.Run("timeout", func(t *testing.T) {
-		done, err := downloadFile(logger, &errClient{context.DeadlineExceeded}, "http://my-fake-url", "")
+		done, err := downloadFile(logger, context.TODO(), &errClient{context.DeadlineExceeded}, "http://my-fake-url", "", nil)
 		if done || err != nil {
 			t.Errorf("Expected download timeout to retry: %v", err)
 		}
 	})
 
 	t.Run("temporary failure", func(t *testing.T) {
-		done, err := downloadFile(logger, &errClient{tempError{}}, "http://my-fake-url", "")
+		done, err := downloadFile(logger, context.TODO(), &errClient{tempError{}}, "http://my-fake-url", "", nil)
 		if done || err != nil {
 			t.Errorf("Expected temporary failure to retry: %v", err)
 		}
 	})
 
 	t.Run("connection refused", func(t *testing.T) {
-		done, err := downloadFile(logger, &errClient{&net.OpError{
+		done, err := downloadFile(logger, context.TODO(), &errClient{&net.OpError{
 			Op:   "dial",
 			Net:  "tcp",
 			Addr: nil,
@@ -137,7 +203,7 @@ func Test_downloadFile(t *testing.T) {
 				Syscall: "connect",
 				Err:     syscall.ECONNREFUSED,
 			},
-		}}, "http://my-fake-url", "")
+		}}, "http://my-fake-url", "", nil)
 		if done || err != nil {
 			t.Errorf("Expected temporary failure to retry: %v", err)
 		}
@@ -163,7 +229,7 @@ func Test_downloadFile(t *testing.T) {
 			}))
 			defer srv.Close()
 
-			done, err := downloadFile(logger, srv.Client(), srv.URL, filepath.Join(os.TempDir(), fmt.Sprintf("test-%d.tar", tc.statusCode)))
+			done, err := downloadFile(logger, context.TODO(), srv.Client(), srv.URL, filepath.Join(os.TempDir(), fmt.Sprintf("test-%d.tar", tc.statusCode)), nil)
 			if done != tc.retry && (err != nil) != tc.error {
 				t.Errorf("Expected status code %d (retry=%v, error=%v): got (done=%v, error=%v)", tc.statusCode, tc.retry, tc.error, done, err)
 			}
@@ -0,0 +1,41 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// WriteFiles writes files keyed by their path relative to dst, replacing existing files. Paths are subject to the same
+// checks as archive entries so files cannot be written outside of dst.
+func WriteFiles(dst string, files map[string][]byte, limits Limits) error {
+	u, err := newUnpacker(dst, limits)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	now := time.Now()
+	for _, p := range paths {
+		name, err := u.entry(p)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			return fmt.Errorf("%w: %q does not reference a file", ErrUnsafePath, p)
+		}
+
+		contents := files[p]
+		if err := u.file(name, bytes.NewReader(contents), int64(len(contents)), 0644, now); err != nil {
+			return err
+		}
+	}
+
+	return u.finish()
+}
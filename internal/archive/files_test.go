@@ -0,0 +1,44 @@
+package archive
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFiles(t *testing.T) {
+	root, err := ioutil.TempDir("", "forge-files-")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	dst := filepath.Join(root, "context")
+	require.NoError(t, os.MkdirAll(dst, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dst, "Dockerfile"), []byte("FROM busybox"), 0644))
+	require.NoError(t, os.Symlink(root, filepath.Join(dst, "escape")))
+
+	err = WriteFiles(dst, map[string][]byte{
+		"Dockerfile":    []byte("FROM scratch"),
+		"conf/app.yaml": []byte("key: value"),
+	}, Limits{})
+	require.NoError(t, err)
+
+	bs, err := ioutil.ReadFile(filepath.Join(dst, "Dockerfile"))
+	require.NoError(t, err)
+	assert.Equal(t, "FROM scratch", string(bs))
+
+	bs, err = ioutil.ReadFile(filepath.Join(dst, "conf", "app.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "key: value", string(bs))
+
+	for _, p := range []string{"../evil", "escape/evil", "."} {
+		err = WriteFiles(dst, map[string][]byte{p: []byte("x")}, Limits{})
+		assert.True(t, errors.Is(err, ErrUnsafePath), "expected unsafe path error for %q, got %v", p, err)
+	}
+	_, err = os.Stat(filepath.Join(root, "evil"))
+	assert.True(t, os.IsNotExist(err), "file written outside of destination")
+}
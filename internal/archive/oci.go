@@ -0,0 +1,152 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/docker/distribution/reference"
+	"github.com/go-logr/logr"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/dominodatalab/forge/internal/util"
+)
+
+// OCIScheme prefixes build contexts stored as OCI artifacts in a registry.
+const OCIScheme = "oci://"
+
+// manifests are small documents, this guards against registries serving arbitrary content
+const maxManifestSize = 4 << 20
+
+// IsOCIReference returns true when a build context references an OCI artifact.
+func IsOCIReference(context string) bool {
+	return strings.HasPrefix(context, OCIScheme)
+}
+
+// FetchOCIAndExtract pulls the single layer of an OCI artifact, e.g. "oci://registry/repo@sha256:...", and extracts it
+// like a downloaded archive. Manifest and layer contents are verified against their descriptor digests.
+func FetchOCIAndExtract(log logr.Logger, ctx context.Context, ref, wd string, opts Options) (*Extraction, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	if err := util.AssertDir(wd); err != nil {
+		return nil, fmt.Errorf("invalid build context directory: %w", err)
+	}
+
+	named, err := reference.ParseNormalizedNamed(strings.TrimPrefix(ref, OCIScheme))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCI artifact reference %q: %w", ref, err)
+	}
+	named = reference.TagNameOnly(named)
+
+	hosts := opts.RegistryHosts
+	if hosts == nil {
+		hosts = docker.ConfigureDefaultRegistries()
+	}
+	resolver := docker.NewResolver(docker.ResolverOptions{Hosts: hosts})
+
+	name, desc, err := resolver.Resolve(ctx, named.String())
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve OCI artifact %q: %w", named, err)
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	layer, err := fetchArtifactLayer(ctx, fetcher, desc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCI artifact %q: %w", named, err)
+	}
+	log.Info("Pulling build context layer", "artifact", named.String(), "digest", layer.Digest, "size", layer.Size)
+
+	archive := filepath.Join(wd, "archive")
+	if err := fetchBlob(ctx, fetcher, layer, archive, opts.Limits.withDefaults().MaxSize); err != nil {
+		return nil, fmt.Errorf("cannot pull OCI artifact layer %s: %w", layer.Digest, err)
+	}
+
+	return unpackArchive(archive, wd, opts)
+}
+
+// reads an image manifest and returns its only layer
+func fetchArtifactLayer(ctx context.Context, fetcher fetcher, desc ocispec.Descriptor) (ocispec.Descriptor, error) {
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+	default:
+		return ocispec.Descriptor{}, fmt.Errorf("unsupported manifest media type %q", desc.MediaType)
+	}
+	if desc.Size > maxManifestSize {
+		return ocispec.Descriptor{}, fmt.Errorf("manifest size %d exceeds %d bytes", desc.Size, maxManifestSize)
+	}
+
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	defer rc.Close()
+
+	bs, err := ioutil.ReadAll(io.LimitReader(rc, maxManifestSize))
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if actual := desc.Digest.Algorithm().FromBytes(bs); actual != desc.Digest {
+		return ocispec.Descriptor{}, fmt.Errorf("manifest digest mismatch: expected %s, got %s", desc.Digest, actual)
+	}
+
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(bs, &manifest); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if len(manifest.Layers) != 1 {
+		return ocispec.Descriptor{}, fmt.Errorf("build context artifacts must contain exactly 1 layer, found %d", len(manifest.Layers))
+	}
+
+	return manifest.Layers[0], nil
+}
+
+// downloads a blob of at most maxSize bytes into a file, verifying its size and digest
+func fetchBlob(ctx context.Context, fetcher fetcher, desc ocispec.Descriptor, fp string, maxSize int64) error {
+	if desc.Size < 0 || desc.Size > maxSize {
+		return fmt.Errorf("%w: blob size %d exceeds %d bytes", ErrLimitExceeded, desc.Size, maxSize)
+	}
+
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.Create(fp)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	verifier := desc.Digest.Verifier()
+	n, err := io.Copy(io.MultiWriter(out, verifier), io.LimitReader(rc, desc.Size+1))
+	if err != nil {
+		return err
+	}
+	if n != desc.Size {
+		return fmt.Errorf("blob size mismatch: expected %d bytes, got %d", desc.Size, n)
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("blob content does not match digest %s", desc.Digest)
+	}
+
+	return out.Close()
+}
+
+type fetcher interface {
+	Fetch(context.Context, ocispec.Descriptor) (io.ReadCloser, error)
+}
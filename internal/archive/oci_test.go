@@ -0,0 +1,167 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serves a single artifact the same way a registry:2 instance would
+func newTestRegistry(t *testing.T, layers ...[]byte) (*httptest.Server, digest.Digest) {
+	blobs := map[digest.Digest][]byte{}
+	manifest := ocispec.Manifest{}
+	manifest.SchemaVersion = 2
+
+	config := []byte("{}")
+	manifest.Config = ocispec.Descriptor{MediaType: "application/vnd.forge.context.config.v1+json", Digest: digest.FromBytes(config), Size: int64(len(config))}
+	blobs[manifest.Config.Digest] = config
+	for _, layer := range layers {
+		desc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromBytes(layer), Size: int64(len(layer))}
+		manifest.Layers = append(manifest.Layers, desc)
+		blobs[desc.Digest] = layer
+	}
+
+	bs, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	manifestDigest := digest.FromBytes(bs)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/v2/contexts/app/manifests/latest" || r.URL.Path == "/v2/contexts/app/manifests/"+manifestDigest.String():
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+			w.Header().Set("Docker-Content-Digest", manifestDigest.String())
+			w.Header().Set("Content-Length", fmt.Sprint(len(bs)))
+			if r.Method != http.MethodHead {
+				_, _ = w.Write(bs)
+			}
+		case strings.HasPrefix(r.URL.Path, "/v2/contexts/app/blobs/"):
+			blob, ok := blobs[digest.Digest(strings.TrimPrefix(r.URL.Path, "/v2/contexts/app/blobs/"))]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+			if r.Method != http.MethodHead {
+				_, _ = w.Write(blob)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return srv, manifestDigest
+}
+
+func testRegistryHosts(username, password string) docker.RegistryHosts {
+	return docker.ConfigureDefaultRegistries(
+		docker.WithAuthorizer(docker.NewDockerAuthorizer(docker.WithAuthCreds(func(string) (string, string, error) {
+			return username, password, nil
+		}))),
+		docker.WithPlainHTTP(docker.MatchAllHosts),
+	)
+}
+
+func TestFetchOCIAndExtract(t *testing.T) {
+	layer, err := ioutil.ReadFile("testdata/simple-app.tgz")
+	require.NoError(t, err)
+
+	srv, manifestDigest := newTestRegistry(t, layer)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	for _, ref := range []string{"contexts/app", "contexts/app@" + manifestDigest.String()} {
+		t.Run(ref, func(t *testing.T) {
+			wd, err := ioutil.TempDir("", "forge-")
+			require.NoError(t, err)
+			defer os.RemoveAll(wd)
+
+			ext, err := FetchOCIAndExtract(logger, context.TODO(), fmt.Sprintf("oci://%s/%s", host, ref), wd, Options{
+				Digest:        digest.FromBytes(layer),
+				RegistryHosts: testRegistryHosts("user", "pass"),
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, digest.FromBytes(layer), ext.Digest)
+			assert.FileExists(t, filepath.Join(ext.ContentsDir, "Dockerfile"))
+			assert.FileExists(t, filepath.Join(ext.ContentsDir, "app.py"))
+		})
+	}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		wd, err := ioutil.TempDir("", "forge-")
+		require.NoError(t, err)
+		defer os.RemoveAll(wd)
+
+		_, err = FetchOCIAndExtract(logger, context.TODO(), fmt.Sprintf("oci://%s/contexts/app", host), wd, Options{
+			RegistryHosts: testRegistryHosts("user", "wrong"),
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("digest_mismatch", func(t *testing.T) {
+		wd, err := ioutil.TempDir("", "forge-")
+		require.NoError(t, err)
+		defer os.RemoveAll(wd)
+
+		_, err = FetchOCIAndExtract(logger, context.TODO(), fmt.Sprintf("oci://%s/contexts/app", host), wd, Options{
+			Digest:        digest.FromString("other"),
+			RegistryHosts: testRegistryHosts("user", "pass"),
+		})
+		var mismatch *DigestMismatchError
+		assert.ErrorAs(t, err, &mismatch)
+	})
+}
+
+func TestFetchOCIAndExtractSizeLimit(t *testing.T) {
+	layer, err := ioutil.ReadFile("testdata/simple-app.tgz")
+	require.NoError(t, err)
+
+	srv, _ := newTestRegistry(t, layer)
+	defer srv.Close()
+
+	_, err = FetchOCIAndExtract(logger, context.TODO(), fmt.Sprintf("oci://%s/contexts/app", strings.TrimPrefix(srv.URL, "http://")), t.TempDir(), Options{
+		Limits:        Limits{MaxSize: int64(len(layer)) - 1},
+		RegistryHosts: testRegistryHosts("user", "pass"),
+	})
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+}
+
+func TestFetchOCIAndExtractMultipleLayers(t *testing.T) {
+	srv, _ := newTestRegistry(t, []byte("one"), []byte("two"))
+	defer srv.Close()
+
+	wd, err := ioutil.TempDir("", "forge-")
+	require.NoError(t, err)
+	defer os.RemoveAll(wd)
+
+	_, err = FetchOCIAndExtract(logger, context.TODO(), fmt.Sprintf("oci://%s/contexts/app", strings.TrimPrefix(srv.URL, "http://")), wd, Options{
+		RegistryHosts: testRegistryHosts("user", "pass"),
+	})
+	assert.EqualError(t, err, fmt.Sprintf(`invalid OCI artifact "%s/contexts/app:latest": build context artifacts must contain exactly 1 layer, found 2`, strings.TrimPrefix(srv.URL, "http://")))
+}
+
+func TestIsOCIReference(t *testing.T) {
+	assert.True(t, IsOCIReference("oci://registry.example.com/contexts/app@sha256:abc"))
+	assert.False(t, IsOCIReference("https://example.com/context.tgz"))
+}
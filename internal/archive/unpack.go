@@ -0,0 +1,341 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dominodatalab/forge/internal/util"
+)
+
+const (
+	// DefaultMaxSize is the default limit on the total uncompressed size of an archive.
+	DefaultMaxSize int64 = 10 << 30
+	// DefaultMaxEntries is the default limit on the number of entries in an archive.
+	DefaultMaxEntries = 1_000_000
+)
+
+var (
+	// ErrUnsafePath is returned when an archive entry would be written outside of the destination directory.
+	ErrUnsafePath = errors.New("unsafe archive entry path")
+	// ErrLimitExceeded is returned when an archive exceeds its configured size or entry limits.
+	ErrLimitExceeded = errors.New("archive limit exceeded")
+)
+
+// Limits guard against decompression bombs. Zero values are replaced with their defaults.
+type Limits struct {
+	// MaxSize is the total number of bytes that may be written across all regular files.
+	MaxSize int64
+	// MaxEntries is the total number of entries an archive may contain.
+	MaxEntries int
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxSize <= 0 {
+		l.MaxSize = DefaultMaxSize
+	}
+	if l.MaxEntries <= 0 {
+		l.MaxEntries = DefaultMaxEntries
+	}
+	return l
+}
+
+// timestamps are applied to directories once extraction has finished since writing their contents updates them
+type dirEntry struct {
+	path    string
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// unpacker writes archive entries beneath a root directory. Entries may not escape the root, either directly or by
+// writing through a symlink, and symlink targets must resolve inside of the root. Absolute symlink targets are
+// interpreted relative to the root and rewritten as relative links. Every archive format is unpacked through this type
+// so that they share the same safety guarantees.
+//
+// Symlink targets are resolved against the tree extracted so far, following the links it already contains. Since a
+// later entry can change where an earlier link resolves to, every link is resolved again once extraction finishes.
+type unpacker struct {
+	root     string
+	limits   Limits
+	entries  int
+	written  int64
+	dirs     []dirEntry
+	symlinks []string
+}
+
+func newUnpacker(dst string, limits Limits) (*unpacker, error) {
+	root, err := filepath.Abs(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	return &unpacker{root: root, limits: limits.withDefaults()}, nil
+}
+
+// entry counts an archive entry against the limits, validates its name and prepares its parent directories. The
+// returned path is empty when the entry refers to the root itself.
+func (u *unpacker) entry(name string) (string, error) {
+	if u.entries++; u.entries > u.limits.MaxEntries {
+		return "", fmt.Errorf("%w: archive contains more than %d entries", ErrLimitExceeded, u.limits.MaxEntries)
+	}
+
+	cleaned, err := cleanEntryName(name)
+	if err != nil {
+		return "", err
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+
+	if err := prepareParent(u.root, cleaned); err != nil {
+		return "", err
+	}
+	return cleaned, nil
+}
+
+func (u *unpacker) dir(name string, mode os.FileMode, modTime time.Time) error {
+	target := filepath.Join(u.root, name)
+	if fi, err := os.Lstat(target); err == nil && !fi.IsDir() {
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+
+	// keep directories writable until extraction completes, the final mode is applied afterwards
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	u.dirs = append(u.dirs, dirEntry{path: target, mode: ownerReadable(mode) | 0700, modTime: modTime})
+
+	return nil
+}
+
+func (u *unpacker) file(name string, r io.Reader, size int64, mode os.FileMode, modTime time.Time) error {
+	remaining := u.limits.MaxSize - u.written
+	if size > remaining {
+		return fmt.Errorf("%w: archive contents exceed %d bytes", ErrLimitExceeded, u.limits.MaxSize)
+	}
+
+	target := filepath.Join(u.root, name)
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+
+	n, err := writeFile(target, r, ownerReadable(mode), remaining)
+	u.written += n
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(target, modTime, modTime)
+}
+
+func (u *unpacker) symlink(name, linkname string) error {
+	linkname, err := symlinkTarget(name, linkname)
+	if err != nil {
+		return err
+	}
+
+	target := filepath.Join(u.root, name)
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+
+	if err := os.Symlink(linkname, target); err != nil {
+		return err
+	}
+	if err := u.checkSymlink(name); err != nil {
+		_ = os.Remove(target)
+		return err
+	}
+	u.symlinks = append(u.symlinks, name)
+
+	return nil
+}
+
+// checkSymlink follows a symlink through the extracted tree and fails when it resolves outside of the root. links
+// that cannot be resolved at all, such as loops, cannot be followed outside of the root by anyone else either.
+func (u *unpacker) checkSymlink(name string) error {
+	_, err := util.ResolveInRoot(u.root, name)
+	if errors.Is(err, util.ErrOutsideRoot) {
+		return fmt.Errorf("%w: symlink %q resolves outside of the archive root", ErrUnsafePath, name)
+	}
+	if errors.Is(err, util.ErrTooManySymlinks) {
+		return nil
+	}
+	return err
+}
+
+func (u *unpacker) hardlink(name, linkname string) error {
+	cleaned, err := cleanEntryName(linkname)
+	if err != nil {
+		return err
+	}
+
+	// the link source may be referenced through previously extracted symlinks
+	sourceDir, err := util.ResolveInRoot(u.root, filepath.Dir(cleaned))
+	if err != nil {
+		if errors.Is(err, util.ErrOutsideRoot) {
+			return fmt.Errorf("%w: hardlink %q references a file outside of the archive root", ErrUnsafePath, name)
+		}
+		return err
+	}
+	source := filepath.Join(sourceDir, filepath.Base(cleaned))
+
+	// the link source must be a regular file that was previously extracted
+	fi, err := os.Lstat(source)
+	if err != nil {
+		return fmt.Errorf("invalid hardlink %q: %w", name, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%w: hardlink %q does not reference a regular file", ErrUnsafePath, name)
+	}
+	if err := prepareParent(u.root, cleaned); err != nil {
+		return err
+	}
+
+	target := filepath.Join(u.root, name)
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+
+	return os.Link(source, target)
+}
+
+// finish verifies that every symlink still resolves inside of the root and applies directory modes and timestamps.
+// directories are processed in reverse so that parents are finalized after their children.
+func (u *unpacker) finish() error {
+	for _, name := range u.symlinks {
+		if err := u.checkSymlink(name); err != nil {
+			_ = os.Remove(filepath.Join(u.root, name))
+			return err
+		}
+	}
+
+	for i := len(u.dirs) - 1; i >= 0; i-- {
+		dir := u.dirs[i]
+		if err := os.Chmod(dir.path, dir.mode); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dir.path, dir.modTime, dir.modTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// the owner must always be able to read the context, otherwise it cannot be sent to the build
+func ownerReadable(mode os.FileMode) os.FileMode {
+	return mode.Perm() | 0400
+}
+
+// cleanEntryName normalizes an entry name and rejects absolute paths and paths that traverse outside of the root
+func cleanEntryName(name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("%w: %q is absolute", ErrUnsafePath, name)
+	}
+
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q traverses outside of the archive root", ErrUnsafePath, name)
+	}
+
+	return cleaned, nil
+}
+
+// symlinkTarget validates that a symlink created at name resolves inside the root and returns the target to create.
+func symlinkTarget(name, linkname string) (string, error) {
+	if linkname == "" {
+		return "", fmt.Errorf("%w: symlink %q has no target", ErrUnsafePath, name)
+	}
+
+	dir := filepath.Dir(name)
+
+	var resolved string
+	if filepath.IsAbs(linkname) {
+		resolved = filepath.Clean(strings.TrimPrefix(linkname, "/"))
+	} else {
+		resolved = filepath.Join(dir, linkname)
+	}
+	if _, err := cleanEntryName(resolved); err != nil {
+		return "", fmt.Errorf("%w: symlink %q points to %q outside of the archive root", ErrUnsafePath, name, linkname)
+	}
+
+	if !filepath.IsAbs(linkname) {
+		return linkname, nil
+	}
+	return filepath.Rel(dir, resolved)
+}
+
+// prepareParent creates the parent directories of an entry. It fails when any existing parent is a symlink since
+// writing through it could place the entry outside of the root.
+func prepareParent(root, name string) error {
+	current := root
+
+	parts := strings.Split(filepath.Dir(name), string(filepath.Separator))
+	for _, part := range parts {
+		if part == "." {
+			continue
+		}
+		current = filepath.Join(current, part)
+
+		fi, err := os.Lstat(current)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.Mkdir(current, 0755); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case fi.Mode()&os.ModeSymlink != 0:
+			return fmt.Errorf("%w: %q is written through symlink %q", ErrUnsafePath, name, part)
+		case !fi.IsDir():
+			return fmt.Errorf("%w: parent of %q is not a directory", ErrUnsafePath, name)
+		}
+	}
+
+	return nil
+}
+
+// removeExisting deletes a non-directory entry so that it can be replaced, later archive entries take precedence
+func removeExisting(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("cannot replace directory %q with a file", path)
+	}
+	return os.Remove(path)
+}
+
+// writeFile copies at most limit bytes from r into a new file and returns the number of bytes written
+func writeFile(path string, r io.Reader, mode os.FileMode, limit int64) (int64, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// the header size is checked beforehand, this protects against streams that are longer than advertised
+	n, err := io.Copy(f, io.LimitReader(r, limit+1))
+	if err != nil {
+		return n, err
+	}
+	if n > limit {
+		return n, fmt.Errorf("%w: archive contents exceed the size limit", ErrLimitExceeded)
+	}
+
+	if err := f.Close(); err != nil {
+		return n, err
+	}
+	return n, os.Chmod(path, mode)
+}
@@ -0,0 +1,52 @@
+package archive
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+)
+
+// untar unpacks a tar stream into dst. Device nodes and fifos are skipped.
+func untar(r io.Reader, dst string, limits Limits) error {
+	u, err := newUnpacker(dst, limits)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name, err := u.entry(header.Name)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+
+		mode := os.FileMode(header.Mode)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = u.dir(name, mode, header.ModTime)
+		case tar.TypeReg, tar.TypeRegA:
+			err = u.file(name, tr, header.Size, mode, header.ModTime)
+		case tar.TypeSymlink:
+			err = u.symlink(name, header.Linkname)
+		case tar.TypeLink:
+			err = u.hardlink(name, header.Linkname)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return u.finish()
+}
@@ -0,0 +1,236 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type tarEntry struct {
+	header  tar.Header
+	content string
+}
+
+func buildTar(t *testing.T, entries ...tarEntry) *bytes.Buffer {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := e.header
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.content))
+		}
+		require.NoError(t, tw.WriteHeader(&hdr))
+		if e.content != "" {
+			_, err := tw.Write([]byte(e.content))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+
+	return buf
+}
+
+func file(name, content string, mode int64) tarEntry {
+	return tarEntry{header: tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: mode}, content: content}
+}
+
+func TestUntar(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	dst, err := ioutil.TempDir("", "forge-untar-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	buf := buildTar(t,
+		tarEntry{header: tar.Header{Name: "app/", Typeflag: tar.TypeDir, Mode: 0750, ModTime: mtime}},
+		tarEntry{header: tar.Header{Name: "app/run.sh", Typeflag: tar.TypeReg, Mode: 0755, ModTime: mtime}, content: "#!/bin/sh"},
+		file("nested/dir/Dockerfile", "FROM scratch", 0644),
+		tarEntry{header: tar.Header{Name: "app/link", Typeflag: tar.TypeSymlink, Linkname: "run.sh"}},
+		tarEntry{header: tar.Header{Name: "abs-link", Typeflag: tar.TypeSymlink, Linkname: "/app/run.sh"}},
+		tarEntry{header: tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "app/run.sh"}},
+		tarEntry{header: tar.Header{Name: "dev", Typeflag: tar.TypeChar}},
+	)
+	require.NoError(t, untar(buf, dst, Limits{}))
+
+	fi, err := os.Stat(filepath.Join(dst, "app", "run.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+	assert.True(t, fi.ModTime().Equal(mtime))
+
+	fi, err = os.Stat(filepath.Join(dst, "app"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), fi.Mode().Perm())
+	assert.True(t, fi.ModTime().Equal(mtime))
+
+	bs, err := ioutil.ReadFile(filepath.Join(dst, "nested", "dir", "Dockerfile"))
+	require.NoError(t, err)
+	assert.Equal(t, "FROM scratch", string(bs))
+
+	target, err := os.Readlink(filepath.Join(dst, "app", "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "run.sh", target)
+
+	target, err = os.Readlink(filepath.Join(dst, "abs-link"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("app", "run.sh"), target)
+
+	hard, err := os.Stat(filepath.Join(dst, "hard"))
+	require.NoError(t, err)
+	orig, _ := os.Stat(filepath.Join(dst, "app", "run.sh"))
+	assert.True(t, os.SameFile(orig, hard))
+
+	_, err = os.Lstat(filepath.Join(dst, "dev"))
+	assert.True(t, os.IsNotExist(err), "device nodes should be skipped")
+}
+
+func TestUntar_Unsafe(t *testing.T) {
+	testCases := []struct {
+		name    string
+		entries []tarEntry
+		err     error
+	}{
+		{
+			name:    "parent_traversal",
+			entries: []tarEntry{file("../evil", "x", 0644)},
+			err:     ErrUnsafePath,
+		},
+		{
+			name:    "nested_traversal",
+			entries: []tarEntry{file("app/../../evil", "x", 0644)},
+			err:     ErrUnsafePath,
+		},
+		{
+			name:    "absolute_path",
+			entries: []tarEntry{file("/etc/evil", "x", 0644)},
+			err:     ErrUnsafePath,
+		},
+		{
+			name: "symlink_escape",
+			entries: []tarEntry{
+				{header: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../../etc"}},
+			},
+			err: ErrUnsafePath,
+		},
+		{
+			name: "write_through_symlink",
+			entries: []tarEntry{
+				{header: tar.Header{Name: "dir", Typeflag: tar.TypeSymlink, Linkname: "."}},
+				file("dir/evil", "x", 0644),
+			},
+			err: ErrUnsafePath,
+		},
+		{
+			name: "chained_symlink_escape",
+			entries: []tarEntry{
+				{header: tar.Header{Name: "d", Typeflag: tar.TypeSymlink, Linkname: "."}},
+				{header: tar.Header{Name: "e", Typeflag: tar.TypeSymlink, Linkname: "d/.."}},
+			},
+			err: ErrUnsafePath,
+		},
+		{
+			name: "retargeted_symlink_escape",
+			entries: []tarEntry{
+				{header: tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "b/.."}},
+				{header: tar.Header{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "."}},
+			},
+			err: ErrUnsafePath,
+		},
+		{
+			name: "hardlink_through_symlink_escape",
+			entries: []tarEntry{
+				{header: tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "b/.."}},
+				{header: tar.Header{Name: "b", Typeflag: tar.TypeSymlink, Linkname: "."}},
+				{header: tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "a/secret"}},
+			},
+			err: ErrUnsafePath,
+		},
+		{
+			name: "hardlink_escape",
+			entries: []tarEntry{
+				{header: tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"}},
+			},
+			err: ErrUnsafePath,
+		},
+		{
+			name: "hardlink_to_symlink",
+			entries: []tarEntry{
+				{header: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "target"}},
+				{header: tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "link"}},
+			},
+			err: ErrUnsafePath,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "forge-untar-")
+			require.NoError(t, err)
+			defer os.RemoveAll(root)
+
+			dst := filepath.Join(root, "a", "b")
+			require.NoError(t, os.MkdirAll(dst, 0755))
+
+			err = untar(buildTar(t, tc.entries...), dst, Limits{})
+			assert.True(t, errors.Is(err, tc.err), "expected %v, got %v", tc.err, err)
+
+			_, err = os.Stat(filepath.Join(root, "a", "evil"))
+			assert.True(t, os.IsNotExist(err), "file written outside of destination")
+		})
+	}
+}
+
+func TestUntar_ChainedSymlinkRead(t *testing.T) {
+	root := t.TempDir()
+	dst := filepath.Join(root, "dst")
+	require.NoError(t, os.MkdirAll(dst, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "secret"), []byte("secret"), 0644))
+
+	err := untar(buildTar(t,
+		tarEntry{header: tar.Header{Name: "d", Typeflag: tar.TypeSymlink, Linkname: "."}},
+		tarEntry{header: tar.Header{Name: "e", Typeflag: tar.TypeSymlink, Linkname: "d/.."}},
+	), dst, Limits{})
+	assert.True(t, errors.Is(err, ErrUnsafePath), "expected %v, got %v", ErrUnsafePath, err)
+
+	_, err = os.ReadFile(filepath.Join(dst, "e", "secret"))
+	assert.Error(t, err, "file outside of destination is readable through extracted symlinks")
+}
+
+func TestUntar_Limits(t *testing.T) {
+	testCases := []struct {
+		name    string
+		limits  Limits
+		entries []tarEntry
+	}{
+		{
+			name:    "size",
+			limits:  Limits{MaxSize: 10},
+			entries: []tarEntry{file("a", "123456", 0644), file("b", "123456", 0644)},
+		},
+		{
+			name:    "entries",
+			limits:  Limits{MaxEntries: 2},
+			entries: []tarEntry{file("a", "", 0644), file("b", "", 0644), file("c", "", 0644)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dst, err := ioutil.TempDir("", "forge-untar-")
+			require.NoError(t, err)
+			defer os.RemoveAll(dst)
+
+			err = untar(buildTar(t, tc.entries...), dst, tc.limits)
+			assert.True(t, errors.Is(err, ErrLimitExceeded), "expected limit error, got %v", err)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package archive
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// unzip unpacks a zip archive into dst. Symlinks are stored as entries whose contents hold the link target.
+func unzip(fp, dst string, limits Limits) error {
+	zr, err := zip.OpenReader(fp)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	u, err := newUnpacker(dst, limits)
+	if err != nil {
+		return err
+	}
+
+	for _, zf := range zr.File {
+		name, err := u.entry(zf.Name)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+
+		if err := unzipEntry(u, name, zf); err != nil {
+			return err
+		}
+	}
+
+	return u.finish()
+}
+
+func unzipEntry(u *unpacker, name string, zf *zip.File) error {
+	mode := zf.Mode()
+	if mode.IsDir() {
+		return u.dir(name, mode, zf.Modified)
+	}
+	if !mode.IsRegular() && mode&os.ModeSymlink == 0 {
+		return nil
+	}
+
+	// the declared size is checked against the limits by the unpacker, this only guards the int64 conversion
+	if zf.UncompressedSize64 > uint64(1<<63-1) {
+		return fmt.Errorf("%w: entry %q is too large", ErrLimitExceeded, zf.Name)
+	}
+
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if mode&os.ModeSymlink != 0 {
+		target, err := ioutil.ReadAll(io.LimitReader(rc, 4096))
+		if err != nil {
+			return err
+		}
+		return u.symlink(name, string(target))
+	}
+
+	return u.file(name, rc, int64(zf.UncompressedSize64), mode, zf.Modified)
+}
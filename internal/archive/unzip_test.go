@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"archive/zip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type zipEntry struct {
+	name    string
+	mode    os.FileMode
+	content string
+}
+
+func buildZip(t *testing.T, dir string, entries ...zipEntry) string {
+	t.Helper()
+
+	fp := filepath.Join(dir, "archive.zip")
+	f, err := os.Create(fp)
+	require.NoError(t, err)
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		hdr.SetMode(e.mode)
+
+		w, err := zw.CreateHeader(hdr)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(e.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	return fp
+}
+
+func TestUnzip(t *testing.T) {
+	wd, err := ioutil.TempDir("", "forge-unzip-")
+	require.NoError(t, err)
+	defer os.RemoveAll(wd)
+
+	dst := filepath.Join(wd, "extracted")
+	require.NoError(t, os.Mkdir(dst, 0755))
+
+	fp := buildZip(t, wd,
+		zipEntry{name: "bin/", mode: os.ModeDir | 0755},
+		zipEntry{name: "bin/run.sh", mode: 0755, content: "#!/bin/sh"},
+		zipEntry{name: "run", mode: os.ModeSymlink | 0777, content: "bin/run.sh"},
+	)
+	require.NoError(t, unzip(fp, dst, Limits{}))
+
+	fi, err := os.Stat(filepath.Join(dst, "bin", "run.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+
+	target, err := os.Readlink(filepath.Join(dst, "run"))
+	require.NoError(t, err)
+	assert.Equal(t, "bin/run.sh", target)
+}
+
+func TestUnzip_Unsafe(t *testing.T) {
+	testCases := []struct {
+		name    string
+		entries []zipEntry
+		limits  Limits
+		err     error
+	}{
+		{
+			name:    "traversal",
+			entries: []zipEntry{{name: "../evil", mode: 0644, content: "x"}},
+			err:     ErrUnsafePath,
+		},
+		{
+			name:    "symlink_escape",
+			entries: []zipEntry{{name: "link", mode: os.ModeSymlink | 0777, content: "../../etc"}},
+			err:     ErrUnsafePath,
+		},
+		{
+			name:    "size_limit",
+			entries: []zipEntry{{name: "big", mode: 0644, content: "0123456789"}},
+			limits:  Limits{MaxSize: 5},
+			err:     ErrLimitExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wd, err := ioutil.TempDir("", "forge-unzip-")
+			require.NoError(t, err)
+			defer os.RemoveAll(wd)
+
+			dst := filepath.Join(wd, "extracted")
+			require.NoError(t, os.Mkdir(dst, 0755))
+
+			err = unzip(buildZip(t, wd, tc.entries...), dst, tc.limits)
+			assert.True(t, errors.Is(err, tc.err), "expected %v, got %v", tc.err, err)
+
+			_, err = os.Stat(filepath.Join(wd, "evil"))
+			assert.True(t, os.IsNotExist(err), "file written outside of destination")
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/dominodatalab/forge/internal/builder/embedded"
+	"github.com/dominodatalab/forge/internal/builder/remote"
 	"github.com/dominodatalab/forge/internal/builder/types"
 	"github.com/dominodatalab/forge/internal/config"
 	"github.com/dominodatalab/forge/plugins/preparer"
@@ -15,6 +16,13 @@ type OCIImageBuilder interface {
 	BuildAndPush(context.Context, *config.BuildOptions) (*types.Image, error)
 }
 
-func New(preparerPlugins []*preparer.Plugin, cacheImageLayers bool, logger logr.Logger) (OCIImageBuilder, error) {
-	return embedded.NewDriver(preparerPlugins, cacheImageLayers, logger)
+func New(preparerPlugins []*preparer.Plugin, opts config.BuilderOptions, logger logr.Logger) (OCIImageBuilder, error) {
+	if opts.Remote != nil {
+		bk, err := remote.New(opts.Remote, logger)
+		if err != nil {
+			return nil, err
+		}
+		return embedded.NewBuildkitDriver(bk, preparerPlugins, opts, logger), nil
+	}
+	return embedded.NewDriver(preparerPlugins, opts, logger)
 }
@@ -15,6 +15,7 @@ import (
 	"github.com/moby/buildkit/session"
 	"github.com/moby/buildkit/worker/base"
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/agent"
 
 	"github.com/dominodatalab/forge/internal/builder/embedded/bkimage/types"
 )
@@ -32,11 +33,18 @@ type Client struct {
 
 	sessionManager *session.Manager
 	controller     *control.Controller
+	worker         *base.Worker
 	workerOpt      *base.WorkerOpt // NOTE: modified
+	gcKeepStorage  int64
 
 	// dynamic elements
 	registryHosts   docker.RegistryHosts
 	hostCredentials CredentialsFn
+	shmSize         int64
+	secrets         map[string][]byte
+	sshAgents       map[string]agent.Agent
+	cacheStores     map[string]content.Store
+	cacheIndexes    map[string]string
 
 	logger logr.Logger
 }
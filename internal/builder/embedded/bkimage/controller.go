@@ -5,6 +5,8 @@ import (
 	"path/filepath"
 
 	"github.com/moby/buildkit/cache/remotecache"
+	inlineremotecache "github.com/moby/buildkit/cache/remotecache/inline"
+	localremotecache "github.com/moby/buildkit/cache/remotecache/local"
 	registryremotecache "github.com/moby/buildkit/cache/remotecache/registry"
 	"github.com/moby/buildkit/control"
 	"github.com/moby/buildkit/frontend"
@@ -55,9 +57,12 @@ func (c *Client) createController(ctx context.Context) error {
 	}
 	remoteCacheExporterFuncs := map[string]remotecache.ResolveCacheExporterFunc{
 		"registry": registryremotecache.ResolveCacheExporterFunc(sm, c.getRegistryHosts()),
+		"inline":   inlineremotecache.ResolveCacheExporterFunc(),
+		"local":    localremotecache.ResolveCacheExporterFunc(sm),
 	}
 	remoteCacheImporterFuncs := map[string]remotecache.ResolveCacheImporterFunc{
 		"registry": registryremotecache.ResolveCacheImporterFunc(sm, opt.ContentStore, c.getRegistryHosts()),
+		"local":    localremotecache.ResolveCacheImporterFunc(sm),
 	}
 	controller, err := control.NewController(control.Opt{
 		SessionManager:            sm,
@@ -73,5 +78,6 @@ func (c *Client) createController(ctx context.Context) error {
 	}
 
 	c.controller = controller
+	c.worker = w
 	return nil
 }
@@ -0,0 +1,65 @@
+package bkimage
+
+import (
+	"context"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/pkg/errors"
+)
+
+// local sources, cache mounts and git checkouts are cheap to recreate and are removed first
+var transientRecordsFilter = []string{"type==source.local,type==exec.cachemount,type==source.git.checkout"}
+
+// SetGCKeepStorage configures the number of bytes the worker is allowed to retain in its state directory. A value of
+// zero disables garbage collection. This must be called before the first solve.
+func (c *Client) SetGCKeepStorage(keepBytes int64) {
+	c.gcKeepStorage = keepBytes
+}
+
+// builds a garbage collection policy modeled after the buildkitd defaults that keeps the worker below its budget
+func (c *Client) gcPolicy() []client.PruneInfo {
+	if c.gcKeepStorage <= 0 {
+		return nil
+	}
+
+	return []client.PruneInfo{
+		{
+			Filter:       transientRecordsFilter,
+			KeepDuration: 48 * time.Hour,
+			KeepBytes:    c.gcKeepStorage / 10,
+		},
+		{
+			KeepDuration: 60 * 24 * time.Hour,
+			KeepBytes:    c.gcKeepStorage,
+		},
+		{
+			KeepBytes: c.gcKeepStorage,
+		},
+		{
+			All:       true,
+			KeepBytes: c.gcKeepStorage,
+		},
+	}
+}
+
+// Prune removes every image from the image store and applies the garbage collection policy to the build cache. Images
+// are only needed until they are pushed, but they hold on to their content for as long as they exist. Nothing is done
+// when garbage collection is disabled or no build has been run yet.
+func (c *Client) Prune(ctx context.Context) error {
+	if c.worker == nil || c.gcKeepStorage <= 0 {
+		return nil
+	}
+
+	imgs, err := c.imageStore.List(ctx)
+	if err != nil {
+		return errors.Wrap(err, "listing images failed")
+	}
+	for _, img := range imgs {
+		if err := c.imageStore.Delete(ctx, img.Name); err != nil {
+			return errors.Wrapf(err, "deleting image %q failed", img.Name)
+		}
+	}
+
+	return errors.Wrap(c.worker.Prune(ctx, nil, c.gcPolicy()...), "pruning build cache failed")
+}
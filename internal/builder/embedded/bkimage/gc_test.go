@@ -0,0 +1,25 @@
+package bkimage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCPolicy(t *testing.T) {
+	c := &Client{}
+	assert.Nil(t, c.gcPolicy())
+	assert.NoError(t, c.Prune(context.Background()), "pruning without gc or a worker is a no-op")
+
+	c.SetGCKeepStorage(10 << 30)
+	policy := c.gcPolicy()
+	if assert.Len(t, policy, 4) {
+		assert.Equal(t, transientRecordsFilter, policy[0].Filter)
+		assert.Equal(t, int64(1<<30), policy[0].KeepBytes)
+		assert.True(t, policy[3].All)
+		for _, p := range policy[1:] {
+			assert.Equal(t, int64(10<<30), p.KeepBytes)
+		}
+	}
+}
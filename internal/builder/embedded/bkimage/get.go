@@ -2,15 +2,28 @@ package bkimage
 
 import (
 	"context"
+	"encoding/json"
 
+	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 )
 
 type ListedImage struct {
 	images.Image
 	ContentSize int64
+	Platforms   []PlatformImage
+}
+
+// PlatformImage describes the platform-specific manifest of an image.
+type PlatformImage struct {
+	Platform     ocispec.Platform
+	Digest       digest.Digest
+	ConfigDigest digest.Digest
+	ContentSize  int64
 }
 
 func (c *Client) GetImage(ctx context.Context, name string) (*ListedImage, error) {
@@ -24,13 +37,92 @@ func (c *Client) GetImage(ctx context.Context, name string) (*ListedImage, error
 		return nil, errors.Wrapf(err, "getting image %q from image store failed", name)
 	}
 
-	size, err := imgObj.Size(ctx, c.contentStore, platforms.Default())
+	listed, err := ListImage(ctx, c.contentStore, imgObj)
 	if err != nil {
 		return nil, errors.Wrapf(err, "calculating image size of %q failed", name)
 	}
+	return listed, nil
+}
+
+// ListImage reads the platform images of an image from a content provider, e.g. a registry fetcher.
+func ListImage(ctx context.Context, provider content.Provider, img images.Image) (*ListedImage, error) {
+	platformImages, err := getPlatformImages(ctx, provider, img)
+	if err != nil {
+		return nil, err
+	}
+
+	// report the largest platform image since size limits apply to each platform individually
+	var size int64
+	for _, pi := range platformImages {
+		if pi.ContentSize > size {
+			size = pi.ContentSize
+		}
+	}
 
 	return &ListedImage{
-		Image:       imgObj,
+		Image:       img,
 		ContentSize: size,
+		Platforms:   platformImages,
 	}, nil
 }
+
+func getPlatformImages(ctx context.Context, provider content.Provider, img images.Image) ([]PlatformImage, error) {
+	switch img.Target.MediaType {
+	case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
+		bs, err := content.ReadBlob(ctx, provider, img.Target)
+		if err != nil {
+			return nil, err
+		}
+
+		var idx ocispec.Index
+		if err := json.Unmarshal(bs, &idx); err != nil {
+			return nil, err
+		}
+
+		var result []PlatformImage
+		for _, desc := range idx.Manifests {
+			if desc.Platform == nil {
+				continue
+			}
+
+			manifest := images.Image{Target: desc}
+			size, err := manifest.Size(ctx, provider, platforms.All)
+			if err != nil {
+				return nil, err
+			}
+			config, err := manifest.Config(ctx, provider, platforms.All)
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, PlatformImage{
+				Platform:     platforms.Normalize(*desc.Platform),
+				Digest:       desc.Digest,
+				ConfigDigest: config.Digest,
+				ContentSize:  size,
+			})
+		}
+		return result, nil
+	default:
+		size, err := img.Size(ctx, provider, platforms.Default())
+		if err != nil {
+			return nil, err
+		}
+		config, err := img.Config(ctx, provider, platforms.Default())
+		if err != nil {
+			return nil, err
+		}
+
+		platform := platforms.DefaultSpec()
+		if ps, err := images.Platforms(ctx, provider, img.Target); err == nil && len(ps) == 1 {
+			platform = platforms.Normalize(ps[0])
+		}
+
+		return []PlatformImage{{
+			Platform:     platform,
+			Digest:       img.Target.Digest,
+			ConfigDigest: config.Digest,
+			ContentSize:  size,
+		}}, nil
+	}
+}
@@ -13,11 +13,17 @@ func (c *Client) ResetHostConfigurations() {
 }
 
 func (c *Client) ConfigureHosts(hostCredentials CredentialsFn, matchNonSSL TLSEnabledFn) {
+	c.hostCredentials = hostCredentials
+	c.registryHosts = NewRegistryHosts(hostCredentials, matchNonSSL)
+}
+
+// NewRegistryHosts returns registry endpoints that authenticate using the given credentials and use plain http for
+// matching hosts.
+func NewRegistryHosts(hostCredentials CredentialsFn, matchNonSSL TLSEnabledFn) docker.RegistryHosts {
 	authOpt := docker.WithAuthCreds(hostCredentials)
 	authorizer := docker.NewDockerAuthorizer(authOpt)
 
-	c.hostCredentials = hostCredentials
-	c.registryHosts = docker.ConfigureDefaultRegistries(
+	return docker.ConfigureDefaultRegistries(
 		docker.WithAuthorizer(authorizer),
 		docker.WithPlainHTTP(matchNonSSL),
 	)
@@ -29,6 +35,11 @@ func (c *Client) getHostCredentials() CredentialsFn {
 	}
 }
 
+// RegistryHosts returns the registry endpoints and credentials configured for the current build.
+func (c *Client) RegistryHosts() docker.RegistryHosts {
+	return c.getRegistryHosts()
+}
+
 func (c *Client) getRegistryHosts() docker.RegistryHosts {
 	return func(s string) ([]docker.RegistryHost, error) {
 		return c.registryHosts(s)
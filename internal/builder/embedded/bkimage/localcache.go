@@ -0,0 +1,161 @@
+package bkimage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	contentlocal "github.com/containerd/containerd/content/local"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/moby/buildkit/client/ociindex"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// exporter response key holding the descriptor of an exported cache manifest
+	cacheManifestKey = "cache.manifest"
+
+	// tag used by local caches when none is provided
+	defaultLocalCacheTag = "latest"
+
+	localCacheStorePrefix = "local:"
+)
+
+// ConfigureLocalCache serves the directories of "local" cache imports and exports in a solve request from the client
+// session. Each directory is an OCI image layout and the "tag" attribute selects the cache inside of it. Imports whose
+// tag does not exist yet are removed from the request. This mirrors the cache handling of buildctl and must be called
+// before the session used by the request is created.
+func (c *Client) ConfigureLocalCache(req *controlapi.SolveRequest) error {
+	stores := map[string]content.Store{}
+
+	for _, exp := range req.Cache.Exports {
+		if exp.Type != "local" {
+			continue
+		}
+
+		dir := exp.Attrs["dest"]
+		if dir == "" {
+			return errors.New("local cache exports require a destination")
+		}
+		cs, err := contentlocal.NewStore(dir)
+		if err != nil {
+			return errors.Wrapf(err, "cannot create local cache store %q", dir)
+		}
+		stores[localCacheStorePrefix+dir] = cs
+	}
+	indexes := LocalCacheIndexes(req.Cache.Exports)
+
+	var imports []*controlapi.CacheOptionsEntry
+	for _, imp := range req.Cache.Imports {
+		if imp.Type != "local" {
+			imports = append(imports, imp)
+			continue
+		}
+
+		dir := imp.Attrs["src"]
+		dgst, err := LocalCacheImportDigest(imp.Attrs)
+		if err != nil {
+			return err
+		}
+		if dgst == "" {
+			c.logger.Info("Local layer cache not found, skipping import", "dir", dir, "tag", localCacheTag(imp.Attrs))
+			continue
+		}
+
+		cs, err := contentlocal.NewStore(dir)
+		if err != nil {
+			return errors.Wrapf(err, "cannot open local cache store %q", dir)
+		}
+		stores[localCacheStorePrefix+dir] = cs
+		imp.Attrs["digest"] = dgst
+		imports = append(imports, imp)
+	}
+	req.Cache.Imports = imports
+
+	c.cacheStores = stores
+	c.cacheIndexes = indexes
+	return nil
+}
+
+func (c *Client) ResetLocalCache() {
+	c.cacheStores = nil
+	c.cacheIndexes = nil
+}
+
+// LocalCacheIndexes returns the tag of every "local" cache export keyed by the path of the index it is recorded in.
+func LocalCacheIndexes(exports []*controlapi.CacheOptionsEntry) map[string]string {
+	indexes := map[string]string{}
+	for _, exp := range exports {
+		if exp.Type == "local" {
+			indexes[filepath.Join(exp.Attrs["dest"], "index.json")] = localCacheTag(exp.Attrs)
+		}
+	}
+	return indexes
+}
+
+// LocalCacheImportDigest returns the manifest digest selected by the tag of a "local" cache import, or an empty
+// string when the cache does not exist yet.
+func LocalCacheImportDigest(attrs map[string]string) (string, error) {
+	dir := attrs["src"]
+	if dir == "" {
+		return "", errors.New("local cache imports require a source")
+	}
+	return localCacheDigest(dir, localCacheTag(attrs))
+}
+
+// records exported cache manifests under their tag in the index of every local cache export
+func (c *Client) updateLocalCacheIndexes(resp map[string]string) error {
+	return UpdateLocalCacheIndexes(c.cacheIndexes, resp)
+}
+
+// UpdateLocalCacheIndexes records the cache manifest exported by a solve under its tag in every index returned by
+// LocalCacheIndexes.
+func UpdateLocalCacheIndexes(indexes map[string]string, resp map[string]string) error {
+	descJSON, ok := resp[cacheManifestKey]
+	if !ok || len(indexes) == 0 {
+		return nil
+	}
+
+	var desc ocispec.Descriptor
+	if err := json.Unmarshal([]byte(descJSON), &desc); err != nil {
+		return errors.Wrap(err, "cannot read exported cache manifest")
+	}
+	created := time.Now().UTC().Format(time.RFC3339)
+
+	for path, tag := range indexes {
+		desc.Annotations = map[string]string{ocispec.AnnotationCreated: created}
+		if err := ociindex.PutDescToIndexJSONFileLocked(path, desc, tag); err != nil {
+			return errors.Wrapf(err, "cannot update local cache index %q", path)
+		}
+	}
+	return nil
+}
+
+func localCacheTag(attrs map[string]string) string {
+	if tag := attrs["tag"]; tag != "" {
+		return tag
+	}
+	return defaultLocalCacheTag
+}
+
+// returns the manifest digest of a tag in a local cache, or an empty string when it does not exist
+func localCacheDigest(dir, tag string) (string, error) {
+	path := filepath.Join(dir, "index.json")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "", nil
+	}
+
+	idx, err := ociindex.ReadIndexJSONFileLocked(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot read local cache index %q", path)
+	}
+	for _, m := range idx.Manifests {
+		if m.Annotations[ocispec.AnnotationRefName] == tag {
+			return m.Digest.String(), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,58 @@
+package bkimage
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/moby/buildkit/client/ociindex"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_LocalCache(t *testing.T) {
+	dir := t.TempDir()
+	desc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex, Digest: digest.FromString("cache"), Size: 5}
+	require.NoError(t, ociindex.PutDescToIndexJSONFileLocked(filepath.Join(dir, "index.json"), desc, "main"))
+
+	req := &controlapi.SolveRequest{
+		Cache: controlapi.CacheOptions{
+			Exports: []*controlapi.CacheOptionsEntry{
+				{Type: "local", Attrs: map[string]string{"dest": dir, "tag": "feature"}},
+			},
+			Imports: []*controlapi.CacheOptionsEntry{
+				{Type: "local", Attrs: map[string]string{"src": dir, "tag": "feature"}},
+				{Type: "local", Attrs: map[string]string{"src": dir, "tag": "main"}},
+				{Type: "registry", Attrs: map[string]string{"ref": "registry.io/org/app:buildcache"}},
+			},
+		},
+	}
+
+	c := &Client{logger: logr.Discard()}
+	require.NoError(t, c.ConfigureLocalCache(req))
+
+	// caches that were not exported yet are not imported
+	require.Len(t, req.Cache.Imports, 2)
+	assert.Equal(t, desc.Digest.String(), req.Cache.Imports[0].Attrs["digest"])
+	assert.Equal(t, "registry", req.Cache.Imports[1].Type)
+	assert.Contains(t, c.cacheStores, "local:"+dir)
+
+	exported := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageIndex, Digest: digest.FromString("exported"), Size: 8}
+	bs, err := json.Marshal(exported)
+	require.NoError(t, err)
+	require.NoError(t, c.updateLocalCacheIndexes(map[string]string{cacheManifestKey: string(bs)}))
+
+	idx, err := ociindex.ReadIndexJSONFileLocked(filepath.Join(dir, "index.json"))
+	require.NoError(t, err)
+	require.Len(t, idx.Manifests, 2)
+	assert.Equal(t, exported.Digest, idx.Manifests[1].Digest)
+	assert.Equal(t, "feature", idx.Manifests[1].Annotations[ocispec.AnnotationRefName])
+	assert.NotEmpty(t, idx.Manifests[1].Annotations[ocispec.AnnotationCreated])
+
+	c.ResetLocalCache()
+	assert.Nil(t, c.cacheStores)
+}
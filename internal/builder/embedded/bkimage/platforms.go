@@ -0,0 +1,68 @@
+package bkimage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/moby/buildkit/util/archutil"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// SupportedPlatforms returns the platforms that the worker can execute, including those provided by emulators.
+func (c *Client) SupportedPlatforms() ([]specs.Platform, error) {
+	var supported []specs.Platform
+	for _, s := range archutil.SupportedPlatforms(false) {
+		p, err := platforms.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		supported = append(supported, platforms.Normalize(p))
+	}
+
+	return supported, nil
+}
+
+// ValidatePlatforms returns an error when any of the requested platforms cannot be executed by the worker.
+func (c *Client) ValidatePlatforms(_ context.Context, requested []string) error {
+	supported, err := c.SupportedPlatforms()
+	if err != nil {
+		return err
+	}
+	return CheckPlatforms(supported, requested)
+}
+
+// CheckPlatforms returns an error when any of the requested platforms is not one of the supported platforms.
+func CheckPlatforms(supported []specs.Platform, requested []string) error {
+	var unsupported []string
+	for _, r := range requested {
+		p, err := platforms.Parse(r)
+		if err != nil {
+			return fmt.Errorf("invalid platform %q: %w", r, err)
+		}
+
+		if !matchesAny(supported, p) {
+			unsupported = append(unsupported, platforms.Format(p))
+		}
+	}
+
+	if len(unsupported) != 0 {
+		var names []string
+		for _, p := range supported {
+			names = append(names, platforms.Format(p))
+		}
+		return fmt.Errorf("worker cannot build for platforms [%s], supported platforms are [%s]", strings.Join(unsupported, ", "), strings.Join(names, ", "))
+	}
+
+	return nil
+}
+
+func matchesAny(supported []specs.Platform, p specs.Platform) bool {
+	for _, s := range supported {
+		if platforms.Only(s).Match(p) {
+			return true
+		}
+	}
+	return false
+}
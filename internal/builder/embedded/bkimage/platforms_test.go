@@ -0,0 +1,24 @@
+package bkimage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_ValidatePlatforms(t *testing.T) {
+	c := &Client{}
+	ctx := context.Background()
+
+	assert.NoError(t, c.ValidatePlatforms(ctx, nil))
+	assert.NoError(t, c.ValidatePlatforms(ctx, []string{platforms.DefaultString()}))
+
+	err := c.ValidatePlatforms(ctx, []string{platforms.DefaultString(), "plan9/mips64"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "plan9/mips64")
+	}
+
+	assert.Error(t, c.ValidatePlatforms(ctx, []string{"not a platform"}))
+}
@@ -0,0 +1,35 @@
+package bkimage
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// ResolveImage returns the digest of an image in its remote registry using the configured registry hosts. An empty
+// digest is returned when the image does not exist.
+func (c *Client) ResolveImage(ctx context.Context, image string) (digest.Digest, error) {
+	return ResolveImage(ctx, c.getRegistryHosts(), image)
+}
+
+// ResolveImage returns the digest of an image in its remote registry, or an empty digest when it does not exist.
+func ResolveImage(ctx context.Context, hosts docker.RegistryHosts, image string) (digest.Digest, error) {
+	image, err := parseImageName(image)
+	if err != nil {
+		return "", err
+	}
+
+	resolver := docker.NewResolver(docker.ResolverOptions{Hosts: hosts})
+	_, desc, err := resolver.Resolve(ctx, image)
+	if errdefs.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "resolving image %q failed", image)
+	}
+
+	return desc.Digest, nil
+}
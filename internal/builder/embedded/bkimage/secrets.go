@@ -0,0 +1,10 @@
+package bkimage
+
+// ConfigureSecrets sets the secret values that are served to RUN instructions mounting secrets by id.
+func (c *Client) ConfigureSecrets(secrets map[string][]byte) {
+	c.secrets = secrets
+}
+
+func (c *Client) ResetSecrets() {
+	c.secrets = nil
+}
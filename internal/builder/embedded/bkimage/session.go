@@ -4,7 +4,9 @@ import (
 	"context"
 
 	"github.com/moby/buildkit/session"
+	sessioncontent "github.com/moby/buildkit/session/content"
 	"github.com/moby/buildkit/session/filesync"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
 	"github.com/moby/buildkit/session/testutil"
 	"github.com/pkg/errors"
 )
@@ -37,6 +39,13 @@ func (c *Client) Session(ctx context.Context, localDirs map[string]string) (*ses
 
 	sess.Allow(filesync.NewFSSyncProvider(syncedDirs))
 	sess.Allow(NewDynamicAuthProvider(c.getHostCredentials()))
+	sess.Allow(secretsprovider.FromMap(c.secrets))
+	if len(c.sshAgents) != 0 {
+		sess.Allow(NewKeyringSSHProvider(c.sshAgents))
+	}
+	if len(c.cacheStores) != 0 {
+		sess.Allow(sessioncontent.NewAttachable(c.cacheStores))
+	}
 
 	// create a session dialer
 	dialer := session.Dialer(testutil.TestStream(testutil.Handler(sm.HandleConn)))
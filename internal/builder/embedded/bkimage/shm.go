@@ -0,0 +1,64 @@
+package bkimage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/mount"
+	"github.com/docker/docker/pkg/idtools"
+	"github.com/moby/buildkit/executor"
+	"github.com/moby/buildkit/snapshot"
+)
+
+// ConfigureShmSize sets the size (in bytes) of /dev/shm inside containers created for RUN instructions. A value of zero
+// or less retains the runtime default of 64MB.
+func (c *Client) ConfigureShmSize(size int64) {
+	c.shmSize = size
+}
+
+func (c *Client) ResetShmSize() {
+	c.shmSize = 0
+}
+
+// shmExecutor overrides the /dev/shm mount of every container launched by the wrapped executor when a custom size has
+// been configured on the client.
+type shmExecutor struct {
+	executor.Executor
+	client *Client
+}
+
+func (e *shmExecutor) Run(ctx context.Context, id string, rootfs executor.Mount, mounts []executor.Mount, process executor.ProcessInfo, started chan<- struct{}) error {
+	if size := e.client.shmSize; size > 0 {
+		// mounts are applied in order so this one shadows the default /dev/shm mount
+		mounts = append(mounts, executor.Mount{
+			Src:  &shmMountable{size: size},
+			Dest: "/dev/shm",
+		})
+	}
+
+	return e.Executor.Run(ctx, id, rootfs, mounts, process, started)
+}
+
+type shmMountable struct {
+	size int64
+}
+
+func (m *shmMountable) Mount(_ context.Context, _ bool) (snapshot.Mountable, error) {
+	return &shmMount{size: m.size}, nil
+}
+
+type shmMount struct {
+	size int64
+}
+
+func (m *shmMount) Mount() ([]mount.Mount, func() error, error) {
+	return []mount.Mount{{
+		Type:    "tmpfs",
+		Source:  "shm",
+		Options: []string{"nosuid", "noexec", "nodev", "mode=1777", fmt.Sprintf("size=%d", m.size)},
+	}}, func() error { return nil }, nil
+}
+
+func (m *shmMount) IdentityMapping() *idtools.IdentityMapping {
+	return nil
+}
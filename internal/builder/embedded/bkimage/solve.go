@@ -30,11 +30,11 @@ func (c *Client) Solve(ctx context.Context, req *controlapi.SolveRequest, ch cha
 			}()
 		}()
 
-		_, err := c.controller.Solve(ctx, req)
+		resp, err := c.controller.Solve(ctx, req)
 		if err != nil {
-			err = errors.Wrap(err, "failed to solve")
+			return errors.Wrap(err, "failed to solve")
 		}
-		return err
+		return c.updateLocalCacheIndexes(resp.ExporterResponse)
 	})
 	eg.Go(func() error {
 		statusReq := &controlapi.StatusRequest{
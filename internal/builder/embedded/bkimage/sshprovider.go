@@ -0,0 +1,138 @@
+package bkimage
+
+import (
+	"context"
+	"io"
+
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/sshforward"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// adapted from https://github.com/moby/buildkit/blob/v0.9.1/session/sshforward/sshprovider/agentprovider.go
+//
+// the upstream provider only loads keys from files or agent sockets, this one serves keys held in memory.
+
+// ConfigureSSH parses PEM-encoded private keys and exposes each set as an ssh agent with the given id to RUN
+// instructions using "--mount=type=ssh,id=<id>".
+func (c *Client) ConfigureSSH(keys map[string][][]byte) error {
+	agents, err := NewKeyringAgents(keys)
+	if err != nil {
+		return err
+	}
+
+	c.sshAgents = agents
+	return nil
+}
+
+// NewKeyringAgents parses PEM-encoded private keys into one in-memory ssh agent per id.
+func NewKeyringAgents(keys map[string][][]byte) (map[string]agent.Agent, error) {
+	agents := map[string]agent.Agent{}
+	for id, pems := range keys {
+		keyring := agent.NewKeyring()
+		for _, pem := range pems {
+			key, err := ssh.ParseRawPrivateKey(pem)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse private key for ssh agent %q", id)
+			}
+			if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+				return nil, errors.Wrapf(err, "failed to add private key to ssh agent %q", id)
+			}
+		}
+		agents[id] = keyring
+	}
+
+	return agents, nil
+}
+
+func (c *Client) ResetSSH() {
+	c.sshAgents = nil
+}
+
+type keyringProvider struct {
+	agents map[string]agent.Agent
+}
+
+func NewKeyringSSHProvider(agents map[string]agent.Agent) session.Attachable {
+	return &keyringProvider{agents: agents}
+}
+
+func (kp *keyringProvider) Register(server *grpc.Server) {
+	sshforward.RegisterSSHServer(server, kp)
+}
+
+func (kp *keyringProvider) CheckAgent(ctx context.Context, req *sshforward.CheckAgentRequest) (*sshforward.CheckAgentResponse, error) {
+	id := sshforward.DefaultID
+	if req.ID != "" {
+		id = req.ID
+	}
+	if _, ok := kp.agents[id]; !ok {
+		return &sshforward.CheckAgentResponse{}, errors.Errorf("unset ssh forward key %s", id)
+	}
+	return &sshforward.CheckAgentResponse{}, nil
+}
+
+func (kp *keyringProvider) ForwardAgent(stream sshforward.SSH_ForwardAgentServer) error {
+	id := sshforward.DefaultID
+
+	opts, _ := metadata.FromIncomingContext(stream.Context()) // if no metadata continue with empty object
+	if v, ok := opts[sshforward.KeySSHID]; ok && len(v) > 0 && v[0] != "" {
+		id = v[0]
+	}
+
+	a, ok := kp.agents[id]
+	if !ok {
+		return errors.Errorf("unset ssh forward key %s", id)
+	}
+
+	s1, s2 := sockPair()
+
+	eg, ctx := errgroup.WithContext(context.TODO())
+	eg.Go(func() error {
+		return agent.ServeAgent(&readOnlyAgent{a}, s1)
+	})
+	eg.Go(func() error {
+		defer s1.Close()
+		return sshforward.Copy(ctx, s2, stream, nil)
+	})
+
+	return eg.Wait()
+}
+
+func sockPair() (io.ReadWriteCloser, io.ReadWriteCloser) {
+	pr1, pw1 := io.Pipe()
+	pr2, pw2 := io.Pipe()
+	return &sock{pr1, pw2, pw1}, &sock{pr2, pw1, pw2}
+}
+
+type sock struct {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+// prevents builds from modifying the keys held by an agent
+type readOnlyAgent struct {
+	agent.Agent
+}
+
+func (a *readOnlyAgent) Add(_ agent.AddedKey) error {
+	return errors.Errorf("adding new keys not allowed by buildkit")
+}
+
+func (a *readOnlyAgent) Remove(_ ssh.PublicKey) error {
+	return errors.Errorf("removing keys not allowed by buildkit")
+}
+
+func (a *readOnlyAgent) RemoveAll() error {
+	return errors.Errorf("removing keys not allowed by buildkit")
+}
+
+func (a *readOnlyAgent) Lock(_ []byte) error {
+	return errors.Errorf("locking agent not allowed by buildkit")
+}
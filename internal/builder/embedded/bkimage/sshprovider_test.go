@@ -0,0 +1,51 @@
+package bkimage
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_ConfigureSSH(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	c := &Client{}
+
+	t.Run("valid", func(t *testing.T) {
+		require.NoError(t, c.ConfigureSSH(map[string][][]byte{
+			"default": {keyPEM},
+			"github":  {keyPEM, keyPEM},
+		}))
+		require.Len(t, c.sshAgents, 2)
+
+		keys, err := c.sshAgents["default"].List()
+		require.NoError(t, err)
+		assert.Len(t, keys, 1)
+
+		c.ResetSSH()
+		assert.Nil(t, c.sshAgents)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		err := c.ConfigureSSH(map[string][][]byte{"default": {[]byte("not a key")}})
+		assert.Error(t, err)
+		assert.Nil(t, c.sshAgents)
+	})
+
+	t.Run("read_only", func(t *testing.T) {
+		require.NoError(t, c.ConfigureSSH(map[string][][]byte{"default": {keyPEM}}))
+		a := &readOnlyAgent{c.sshAgents["default"]}
+		assert.Error(t, a.RemoveAll())
+		assert.Error(t, a.Lock([]byte("passphrase")))
+	})
+}
@@ -9,16 +9,13 @@ import (
 	"github.com/containerd/containerd/diff/apply"
 	"github.com/containerd/containerd/diff/walking"
 	ctdmetadata "github.com/containerd/containerd/metadata"
-	"github.com/containerd/containerd/platforms"
 	bkmetadata "github.com/moby/buildkit/cache/metadata"
 	"github.com/moby/buildkit/executor/oci"
 	"github.com/moby/buildkit/executor/runcexecutor"
 	containerdsnapshot "github.com/moby/buildkit/snapshot/containerd"
-	"github.com/moby/buildkit/util/archutil"
 	"github.com/moby/buildkit/util/leaseutil"
 	"github.com/moby/buildkit/util/network/netproviders"
 	"github.com/moby/buildkit/worker/base"
-	specs "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 func (c *Client) createWorkerOpt() (opt base.WorkerOpt, err error) {
@@ -55,22 +52,18 @@ func (c *Client) createWorkerOpt() (opt base.WorkerOpt, err error) {
 
 	executorLabels := base.Labels("oci", c.backend)
 
-	var supportedPlatforms []specs.Platform
-	for _, s := range archutil.SupportedPlatforms(false) {
-		p, err := platforms.Parse(s)
-		if err != nil {
-			return opt, err
-		}
-		supportedPlatforms = append(supportedPlatforms, platforms.Normalize(p))
+	supportedPlatforms, err := c.SupportedPlatforms()
+	if err != nil {
+		return opt, err
 	}
 
 	opt = base.WorkerOpt{
 		ID:              id,
 		Labels:          executorLabels,
 		Platforms:       supportedPlatforms,
-		GCPolicy:        nil,
+		GCPolicy:        c.gcPolicy(),
 		MetadataStore:   md,
-		Executor:        exe,
+		Executor:        &shmExecutor{Executor: exe, client: c},
 		Snapshotter:     containerdsnapshot.NewSnapshotter(c.backend, c.metadataDB.Snapshotter(c.backend), "buildkit", nil),
 		ContentStore:    c.contentStore,
 		Applier:         apply.NewFileSystemApplier(c.contentStore),
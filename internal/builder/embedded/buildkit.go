@@ -0,0 +1,111 @@
+package embedded
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/go-logr/logr"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/dominodatalab/forge/internal/builder/embedded/bkimage"
+	"github.com/dominodatalab/forge/internal/config"
+)
+
+// Buildkit solves builds for the driver and manages the images they produce. Images are referenced by the name given
+// to the image exporter of a solve request.
+type Buildkit interface {
+	SetLogger(logr.Logger)
+
+	// registry hosts and credentials are configured for every build and reset afterwards
+	ConfigureHosts(bkimage.CredentialsFn, bkimage.TLSEnabledFn)
+	ResetHostConfigurations()
+	RegistryHosts() docker.RegistryHosts
+
+	ValidatePlatforms(ctx context.Context, requested []string) error
+	Build(ctx context.Context, req *controlapi.SolveRequest, localDirs map[string]string, opts *config.BuildOptions) error
+	GetImage(ctx context.Context, name string) (*bkimage.ListedImage, error)
+	TagImage(ctx context.Context, src, dest string) error
+	PushImage(ctx context.Context, name string) error
+	ResolveImage(ctx context.Context, name string) (digest.Digest, error)
+	Prune(ctx context.Context) error
+}
+
+// embeddedBuildkit runs buildkit inside the builder process and keeps built images in its local image store.
+type embeddedBuildkit struct {
+	*bkimage.Client
+	logger logr.Logger
+}
+
+func (e *embeddedBuildkit) SetLogger(logger logr.Logger) {
+	e.logger = logger
+	e.Client.SetLogger(logger)
+}
+
+func (e *embeddedBuildkit) Build(ctx context.Context, req *controlapi.SolveRequest, localDirs map[string]string, opts *config.BuildOptions) error {
+	// size /dev/shm for every run and reset afterwards
+	e.ConfigureShmSize(opts.ShmSize)
+	defer e.ResetShmSize()
+
+	// serve build secrets from memory for every run and reset afterwards
+	e.ConfigureSecrets(opts.Secrets)
+	defer e.ResetSecrets()
+
+	// serve ssh agents from memory for every run and reset afterwards
+	if err := e.ConfigureSSH(opts.SSHKeys); err != nil {
+		return err
+	}
+	defer e.ResetSSH()
+
+	// serve local layer caches for every run and reset afterwards
+	if err := e.ConfigureLocalCache(req); err != nil {
+		return err
+	}
+	defer e.ResetLocalCache()
+
+	// create a new buildkit session
+	sess, sessDialer, err := e.Session(ctx, localDirs)
+	if err != nil {
+		return err
+	}
+	req.Session = sess.ID()
+
+	// add build metadata to context
+	ctx = namespaces.WithNamespace(ctx, "buildkit")
+	eg, ctx := errgroup.WithContext(ctx)
+
+	// launch build
+	ch := make(chan *controlapi.StatusResponse)
+	eg.Go(func() error {
+		return sess.Run(ctx, sessDialer)
+	})
+	eg.Go(func() error {
+		defer sess.Close()
+		return e.Solve(ctx, req, ch)
+	})
+	eg.Go(func() error { return displayProgress(ch, &bkimage.LogrWriter{Logger: e.logger}) })
+
+	// return error when one occurs
+	return eg.Wait()
+}
+
+func (e *embeddedBuildkit) PushImage(ctx context.Context, name string) error {
+	sess, sessDialer, err := e.Session(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	ctx = namespaces.WithNamespace(ctx, "buildkit")
+	eg, ctx := errgroup.WithContext(ctx)
+
+	eg.Go(func() error {
+		return sess.Run(ctx, sessDialer)
+	})
+	eg.Go(func() error {
+		defer sess.Close()
+		return e.Client.PushImage(ctx, sess.ID(), name)
+	})
+	return eg.Wait()
+}
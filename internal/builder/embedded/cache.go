@@ -0,0 +1,265 @@
+package embedded
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	controlapi "github.com/moby/buildkit/api/services/control"
+
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+	"github.com/dominodatalab/forge/internal/config"
+	"github.com/dominodatalab/forge/internal/layercache"
+)
+
+// returns a copy of the build options with the cache key templates rendered
+func withCacheKeys(opts *config.BuildOptions, data forgev1alpha1.TagTemplateData) (*config.BuildOptions, error) {
+	if opts.Cache.Key == "" && len(opts.Cache.FallbackKeys) == 0 {
+		return opts, nil
+	}
+
+	rendered := *opts
+	rendered.Cache.FallbackKeys = nil
+
+	if opts.Cache.Key != "" {
+		key, err := forgev1alpha1.RenderTag(opts.Cache.Key, data.CacheKeyData())
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache key: %v", err)
+		}
+		rendered.Cache.Key = key
+	}
+	for _, tmpl := range opts.Cache.FallbackKeys {
+		key, err := forgev1alpha1.RenderTag(tmpl, data.CacheKeyData())
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache key: %v", err)
+		}
+		rendered.Cache.FallbackKeys = append(rendered.Cache.FallbackKeys, key)
+	}
+
+	return &rendered, nil
+}
+
+// returns true when layer caches are used by a build. caches configured on the build take precedence over the
+// builder defaults.
+func cacheEnabled(cacheImageLayers bool, opts *config.BuildOptions) bool {
+	if opts.Cache.Enabled != nil {
+		return *opts.Cache.Enabled
+	}
+	return cacheImageLayers
+}
+
+// returns the cache export mode of a build, which defaults to the mode configured on the builder
+func cacheMode(opts *config.BuildOptions) (string, error) {
+	if opts.Cache.Mode != "" {
+		return opts.Cache.Mode, nil
+	}
+	return getExportMode()
+}
+
+// returns the cache repository and the cache keys imported by a build in order of precedence. the first key is the
+// one exported unless the inline mode is used.
+//
+// caches are stored in the image repository unless a separate cache repository is configured. the cache key
+// defaults to a common tag, or to the image tag with the inline mode since the cache is stored in the image itself.
+func cacheKeys(image, mode string, opts *config.BuildOptions) (reference.Named, []string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo := reference.TrimNamed(named)
+	if opts.Cache.Repository != "" {
+		if repo, err = reference.ParseNormalizedNamed(opts.Cache.Repository); err != nil {
+			return nil, nil, fmt.Errorf("invalid cache repository %q: %v", opts.Cache.Repository, err)
+		}
+		repo = reference.TrimNamed(repo)
+	}
+
+	key := opts.Cache.Key
+	if key == "" {
+		key = cacheTag
+		if tagged, ok := reference.TagNameOnly(named).(reference.Tagged); ok && mode == forgev1alpha1.CacheModeInline {
+			key = tagged.Tag()
+		}
+	}
+
+	var keys []string
+	for _, k := range append([]string{key}, opts.Cache.FallbackKeys...) {
+		keys = appendTag(keys, k)
+	}
+	return repo, keys, nil
+}
+
+// returns the registry cache references imported by a build in order of precedence
+func cacheRefs(image, mode string, opts *config.BuildOptions) ([]string, error) {
+	repo, keys, err := cacheKeys(image, mode, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []string
+	for _, key := range keys {
+		ref, err := reference.WithTag(repo, key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot tag cache repository %q with %q: %v", repo, key, err)
+		}
+		refs = appendTag(refs, ref.String())
+	}
+	return appendImportRefs(refs, opts)
+}
+
+// appends the additional cache import references of a build
+func appendImportRefs(refs []string, opts *config.BuildOptions) ([]string, error) {
+	for _, ref := range opts.CacheFrom {
+		named, err := reference.ParseNormalizedNamed(ref)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache import reference %q: %v", ref, err)
+		}
+		refs = appendTag(refs, reference.TagNameOnly(named).String())
+	}
+	return refs, nil
+}
+
+// adds the cache imports and exports of a build to a solve request
+func addCacheOptions(req *controlapi.SolveRequest, image string, cacheImageLayers bool, opts *config.BuildOptions) error {
+	if !cacheEnabled(cacheImageLayers, opts) {
+		return nil
+	}
+
+	mode, err := cacheMode(opts)
+	if err != nil {
+		return err
+	}
+	// builds that are not pushed must not publish anything, including layer caches
+	export := !opts.DisableLayerCacheExport && !opts.BuildOnly
+	imports := !opts.DisableBuildCache
+
+	var refs []string
+	switch opts.Cache.Backend {
+	case "", forgev1alpha1.CacheBackendRegistry:
+		if refs, err = cacheRefs(image, mode, opts); err != nil {
+			return err
+		}
+
+		if export {
+			if mode == forgev1alpha1.CacheModeInline {
+				req.Cache.Exports = []*controlapi.CacheOptionsEntry{{Type: "inline"}}
+			} else {
+				req.Cache.Exports = []*controlapi.CacheOptionsEntry{{
+					Type: "registry",
+					Attrs: map[string]string{
+						"mode": mode,
+						"ref":  refs[0],
+					},
+				}}
+			}
+		}
+	case forgev1alpha1.CacheBackendLocal, forgev1alpha1.CacheBackendS3:
+		if mode == forgev1alpha1.CacheModeInline {
+			return fmt.Errorf("the inline cache mode cannot be used with the %s cache backend", opts.Cache.Backend)
+		}
+		if opts.Cache.Local.Dir == "" {
+			return fmt.Errorf("the %s layer cache backend is not configured", opts.Cache.Backend)
+		}
+
+		repo, keys, err := cacheKeys(image, mode, opts)
+		if err != nil {
+			return err
+		}
+		dir := layercache.LayoutDir(opts.Cache.Local.Dir, repo.Name())
+
+		if export {
+			req.Cache.Exports = []*controlapi.CacheOptionsEntry{{
+				Type: "local",
+				Attrs: map[string]string{
+					"mode": mode,
+					"dest": dir,
+					"tag":  keys[0],
+				},
+			}}
+		}
+		if imports {
+			for _, key := range keys {
+				req.Cache.Imports = append(req.Cache.Imports, &controlapi.CacheOptionsEntry{
+					Type: "local",
+					Attrs: map[string]string{
+						"src": dir,
+						"tag": key,
+					},
+				})
+			}
+		}
+
+		// additional references are always imported from registries
+		if refs, err = appendImportRefs(nil, opts); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported layer cache backend %q", opts.Cache.Backend)
+	}
+
+	if imports && len(refs) != 0 {
+		for _, ref := range refs {
+			req.Cache.Imports = append(req.Cache.Imports, &controlapi.CacheOptionsEntry{
+				Type: "registry",
+				Attrs: map[string]string{
+					"ref": ref,
+				},
+			})
+		}
+		req.FrontendAttrs["cache-from"] = strings.Join(refs, ",")
+	}
+
+	return nil
+}
+
+// downloads the caches imported by a build that uses the s3 backend into a local staging directory. returns a copy of
+// the build options using the staging directory and a func uploading the exported cache after the build.
+func stageS3Cache(ctx context.Context, workDir, image string, cacheImageLayers bool, opts *config.BuildOptions) (*config.BuildOptions, func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	if opts.Cache.Backend != forgev1alpha1.CacheBackendS3 || !cacheEnabled(cacheImageLayers, opts) {
+		return opts, noop, nil
+	}
+
+	mode, err := cacheMode(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	repo, keys, err := cacheKeys(image, mode, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	store, err := layercache.NewS3Store(ctx, opts.Cache.S3)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	staging := filepath.Join(workDir, "layer-cache")
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return nil, nil, err
+	}
+	dir := layercache.LayoutDir(staging, repo.Name())
+
+	if !opts.DisableBuildCache {
+		if err := store.Download(ctx, repo.Name(), keys, dir); err != nil {
+			return nil, nil, fmt.Errorf("cannot download layer cache: %w", err)
+		}
+	}
+
+	staged := *opts
+	staged.Cache.Local = layercache.LocalOptions{Dir: staging}
+
+	upload := noop
+	if !opts.DisableLayerCacheExport && !opts.BuildOnly {
+		upload = func(ctx context.Context) error {
+			if err := store.Upload(ctx, repo.Name(), keys[0], dir); err != nil {
+				return fmt.Errorf("cannot upload layer cache: %w", err)
+			}
+			return nil
+		}
+	}
+	return &staged, upload, nil
+}
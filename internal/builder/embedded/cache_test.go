@@ -0,0 +1,157 @@
+package embedded
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+	"github.com/dominodatalab/forge/internal/config"
+	"github.com/dominodatalab/forge/internal/layercache"
+)
+
+func TestWithCacheKeys(t *testing.T) {
+	data := forgev1alpha1.NewTagTemplateData("build", "default", "", "feature/login", "3f786850e387550fdab836ed7e6dc881de23001b", time.Now())
+	opts := &config.BuildOptions{Cache: config.CacheOptions{Key: "{{ .GitRef }}", FallbackKeys: []string{"main"}}}
+
+	rendered, err := withCacheKeys(opts, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered.Cache.Key != "feature-login" {
+		t.Errorf("expected cache key %q, got %q", "feature-login", rendered.Cache.Key)
+	}
+	if !reflect.DeepEqual(rendered.Cache.FallbackKeys, []string{"main"}) {
+		t.Errorf("expected fallback keys %v, got %v", []string{"main"}, rendered.Cache.FallbackKeys)
+	}
+	if opts.Cache.Key != "{{ .GitRef }}" {
+		t.Errorf("expected original options to be retained, got key %q", opts.Cache.Key)
+	}
+
+	if _, err := withCacheKeys(&config.BuildOptions{Cache: config.CacheOptions{Key: "{{ .Digest }}"}}, data); err == nil {
+		t.Error("expected err for cache key depending on the image digest, got none")
+	}
+}
+
+func TestCacheRefs(t *testing.T) {
+	tests := []struct {
+		name     string
+		image    string
+		mode     string
+		opts     *config.BuildOptions
+		expected []string
+	}{
+		{
+			name:     "default",
+			image:    "registry.io/org/app:v1",
+			mode:     "max",
+			opts:     &config.BuildOptions{},
+			expected: []string{"registry.io/org/app:buildcache"},
+		},
+		{
+			name:  "repository_and_keys",
+			image: "registry.io/org/app:v1",
+			mode:  "min",
+			opts: &config.BuildOptions{
+				Cache:     config.CacheOptions{Repository: "cache.io/app", Key: "feature", FallbackKeys: []string{"main", "feature"}},
+				CacheFrom: []string{"registry.io/org/base", "cache.io/app:main"},
+			},
+			expected: []string{"cache.io/app:feature", "cache.io/app:main", "registry.io/org/base:latest"},
+		},
+		{
+			name:     "inline",
+			image:    "registry.io/org/app:v1",
+			mode:     forgev1alpha1.CacheModeInline,
+			opts:     &config.BuildOptions{Cache: config.CacheOptions{FallbackKeys: []string{"main"}}},
+			expected: []string{"registry.io/org/app:v1", "registry.io/org/app:main"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := cacheRefs(tc.image, tc.mode, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("expected refs %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSolveRequestWithContext_cache(t *testing.T) {
+	enabled, disabled := true, false
+
+	req, err := solveRequestWithContext("session", "registry.io/org/app:v1", false, &config.BuildOptions{
+		Cache: config.CacheOptions{Enabled: &enabled, Mode: forgev1alpha1.CacheModeInline, FallbackKeys: []string{"main"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Cache.Exports) != 1 || req.Cache.Exports[0].Type != "inline" {
+		t.Errorf("expected inline cache export, got %v", req.Cache.Exports)
+	}
+	if len(req.Cache.Imports) != 2 {
+		t.Errorf("expected cache imports for the key and fallback key, got %v", req.Cache.Imports)
+	}
+	if expected := "registry.io/org/app:v1,registry.io/org/app:main"; req.FrontendAttrs["cache-from"] != expected {
+		t.Errorf("expected cache-from %q, got %q", expected, req.FrontendAttrs["cache-from"])
+	}
+
+	req, err = solveRequestWithContext("session", "registry.io/org/app:v1", true, &config.BuildOptions{
+		Cache: config.CacheOptions{Enabled: &disabled},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Cache.Exports) != 0 || len(req.Cache.Imports) != 0 {
+		t.Errorf("expected caching to be disabled by the build, got exports %v and imports %v", req.Cache.Exports, req.Cache.Imports)
+	}
+}
+
+func TestSolveRequestWithContext_localCache(t *testing.T) {
+	opts := &config.BuildOptions{
+		CacheFrom: []string{"registry.io/org/base:buildcache"},
+		Cache: config.CacheOptions{
+			Backend:      forgev1alpha1.CacheBackendLocal,
+			Mode:         "min",
+			Key:          "feature",
+			FallbackKeys: []string{"main"},
+			Local:        layercache.LocalOptions{Dir: "/mnt/layer-cache"},
+		},
+	}
+
+	req, err := solveRequestWithContext("session", "registry.io/org/app:v1", true, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := "/mnt/layer-cache/registry.io/org/app"
+	expectedExports := []*controlapi.CacheOptionsEntry{
+		{Type: "local", Attrs: map[string]string{"mode": "min", "dest": dir, "tag": "feature"}},
+	}
+	if !reflect.DeepEqual(req.Cache.Exports, expectedExports) {
+		t.Errorf("expected exports %v, got %v", expectedExports, req.Cache.Exports)
+	}
+	expectedImports := []*controlapi.CacheOptionsEntry{
+		{Type: "local", Attrs: map[string]string{"src": dir, "tag": "feature"}},
+		{Type: "local", Attrs: map[string]string{"src": dir, "tag": "main"}},
+		{Type: "registry", Attrs: map[string]string{"ref": "registry.io/org/base:buildcache"}},
+	}
+	if !reflect.DeepEqual(req.Cache.Imports, expectedImports) {
+		t.Errorf("expected imports %v, got %v", expectedImports, req.Cache.Imports)
+	}
+
+	opts.Cache.Local.Dir = ""
+	if _, err := solveRequestWithContext("session", "registry.io/org/app:v1", true, opts); err == nil {
+		t.Error("expected err for unconfigured local backend, got none")
+	}
+
+	opts.Cache.Local.Dir = "/mnt/layer-cache"
+	opts.Cache.Mode = forgev1alpha1.CacheModeInline
+	if _, err := solveRequestWithContext("session", "registry.io/org/app:v1", true, opts); err == nil {
+		t.Error("expected err for inline mode with local backend, got none")
+	}
+}
@@ -0,0 +1,236 @@
+package embedded
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+
+	"github.com/dominodatalab/forge/internal/archive"
+	builder "github.com/dominodatalab/forge/internal/builder/types"
+	"github.com/dominodatalab/forge/internal/config"
+	"github.com/dominodatalab/forge/internal/git"
+)
+
+const (
+	// image label populated with the commit of git build contexts
+	revisionLabel = "org.opencontainers.image.revision"
+
+	// prefix of named contexts that are resolved as images by the frontend
+	dockerImageScheme = "docker-image://"
+
+	// prefix of the session directories that serve fetched named contexts
+	namedContextLocalPrefix = "named-context-"
+)
+
+type gitFetcher func(context.Context, logr.Logger, *git.Source, string, *git.Auth) (*git.Checkout, error)
+
+// buildContext is a fetched build context that is ready for use.
+type buildContext struct {
+	ContentsDir string
+	GitCommit   string
+	Digest      string
+	// additional named contexts fetched along with the build context
+	AdditionalContexts []builder.AdditionalContext
+}
+
+// fetches the build context and writes inline files on top of it
+func (d *driver) fetchContext(ctx context.Context, opts *config.BuildOptions) (*buildContext, error) {
+	bc, err := d.fetchRemoteContext(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeInlineFiles(bc.ContentsDir, opts); err != nil {
+		return nil, errors.Wrap(err, "cannot write inline files into build context")
+	}
+	return bc, nil
+}
+
+// fetches the build context from a remote archive, an OCI artifact or a git repository. volume contexts are used in
+// place unless they need to be modified, builds without a context use an empty directory.
+func (d *driver) fetchRemoteContext(ctx context.Context, opts *config.BuildOptions) (*buildContext, error) {
+	if opts.ContextDir != "" {
+		if len(d.preparerPlugins) == 0 && opts.Dockerfile == nil && len(opts.InlineFiles) == 0 {
+			return &buildContext{ContentsDir: opts.ContextDir}, nil
+		}
+
+		// volume contexts are mounted read-only, copy them into a scratch directory that can be modified
+		dir := filepath.Join(d.workDir, "volume")
+		if err := archive.CopyTree(opts.ContextDir, dir, opts.ContextLimits); err != nil {
+			return nil, errors.Wrap(err, "cannot copy volume build context")
+		}
+		return &buildContext{ContentsDir: dir}, nil
+	}
+
+	if opts.ContextURL == "" {
+		dir := filepath.Join(d.workDir, "inline")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		return &buildContext{ContentsDir: dir}, nil
+	}
+
+	return d.fetchSource(ctx, opts.ContextURL, d.workDir, opts, archive.Options{
+		Timeout: opts.ContextTimeout,
+		Limits:  opts.ContextLimits,
+		Digest:  opts.ContextDigest,
+		Auth:    opts.ContextAuth,
+	})
+}
+
+// fetches an archive, OCI artifact or git repository into a working directory
+func (d *driver) fetchSource(ctx context.Context, source, wd string, opts *config.BuildOptions, extractOpts archive.Options) (*buildContext, error) {
+	if archive.IsOCIReference(source) {
+		// pull the artifact using the credentials configured for the build registries
+		extractOpts.Auth = nil
+		extractOpts.RegistryHosts = d.bk.RegistryHosts()
+
+		extract, err := d.ociExtractor(d.logger, ctx, source, wd, extractOpts)
+		if err != nil {
+			return nil, err
+		}
+		return &buildContext{ContentsDir: extract.ContentsDir, Digest: extract.Digest.String()}, nil
+	}
+
+	if !git.IsSource(source) {
+		// download and extract remote archive
+		extract, err := d.contextExtractor(d.logger, ctx, source, wd, extractOpts)
+		if err != nil {
+			return nil, err
+		}
+		return &buildContext{ContentsDir: extract.ContentsDir, Digest: extract.Digest.String()}, nil
+	}
+
+	// commits already pin the contents of git contexts
+	if extractOpts.Digest != "" {
+		return nil, errors.New("context digests can only be verified for archive contexts, pin a git commit instead")
+	}
+
+	src, err := git.ParseSource(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if extractOpts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, extractOpts.Timeout)
+		defer cancel()
+	}
+
+	checkout, err := d.gitFetcher(ctx, d.logger, src, filepath.Join(wd, "repository"), opts.GitAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	return &buildContext{
+		ContentsDir: checkout.ContentsDir,
+		GitCommit:   checkout.Commit,
+	}, nil
+}
+
+// namedContexts are the additional named contexts of a build
+type namedContexts struct {
+	// frontend attributes and local directories referencing the contexts
+	Attrs     map[string]string
+	LocalDirs map[string]string
+	// contexts fetched by the driver, sorted by name
+	Fetched []builder.AdditionalContext
+}
+
+// fetches additional named contexts and returns the frontend attributes and local directories referencing them.
+// image references are resolved by the frontend itself, every other source is fetched like the main build context.
+func (d *driver) fetchNamedContexts(ctx context.Context, opts *config.BuildOptions) (*namedContexts, error) {
+	named := &namedContexts{
+		Attrs:     map[string]string{},
+		LocalDirs: map[string]string{},
+	}
+
+	for name, source := range opts.AdditionalContexts {
+		if strings.HasPrefix(source, dockerImageScheme) {
+			named.Attrs[namedContextAttr(name)] = source
+			continue
+		}
+
+		wd := filepath.Join(d.workDir, "contexts", name)
+		if err := os.MkdirAll(wd, 0755); err != nil {
+			return nil, err
+		}
+
+		bc, err := d.fetchSource(ctx, source, wd, opts, archive.Options{
+			Timeout: opts.ContextTimeout,
+			Limits:  opts.ContextLimits,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot fetch build context %q", name)
+		}
+
+		localName := namedContextLocalPrefix + name
+		named.Attrs[namedContextAttr(name)] = "local:" + localName
+		named.LocalDirs[localName] = bc.ContentsDir
+		named.Fetched = append(named.Fetched, builder.AdditionalContext{Name: name, Digest: bc.Digest, GitCommit: bc.GitCommit})
+	}
+	sort.Slice(named.Fetched, func(i, j int) bool {
+		return named.Fetched[i].Name < named.Fetched[j].Name
+	})
+
+	return named, nil
+}
+
+func namedContextAttr(name string) string {
+	return "context:" + name
+}
+
+// writes inline files followed by the inline dockerfile so that it takes precedence
+func writeInlineFiles(dir string, opts *config.BuildOptions) error {
+	files := map[string][]byte{}
+	for p, contents := range opts.InlineFiles {
+		files[path.Clean(p)] = contents
+	}
+
+	if opts.Dockerfile != nil {
+		dockerfilePath := opts.DockerfilePath
+		if dockerfilePath == "" {
+			dockerfilePath = defaultDockerfileName
+		}
+		files[path.Clean(dockerfilePath)] = opts.Dockerfile
+	}
+
+	if len(files) == 0 {
+		return nil
+	}
+	return archive.WriteFiles(dir, files, opts.ContextLimits)
+}
+
+// returns a copy of the build options with the revision label set, unless it was provided explicitly
+func withRevisionLabel(opts *config.BuildOptions, commit string) *config.BuildOptions {
+	if _, ok := opts.Labels[revisionLabel]; ok || commit == "" {
+		return opts
+	}
+
+	labels := map[string]string{revisionLabel: commit}
+	for k, v := range opts.Labels {
+		labels[k] = v
+	}
+
+	labeled := *opts
+	labeled.Labels = labels
+	return &labeled
+}
+
+// returns the branch or tag of a git build context, or an empty string for other contexts and the remote HEAD
+func contextGitRef(contextURL string) string {
+	if !git.IsSource(contextURL) {
+		return ""
+	}
+	src, err := git.ParseSource(contextURL)
+	if err != nil {
+		return ""
+	}
+	return src.Ref
+}
@@ -0,0 +1,193 @@
+package embedded
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/dominodatalab/forge/internal/archive"
+	builder "github.com/dominodatalab/forge/internal/builder/types"
+	"github.com/dominodatalab/forge/internal/config"
+	"github.com/dominodatalab/forge/internal/git"
+)
+
+func TestDriver_fetchContext(t *testing.T) {
+	var extracted, fetched, pulled bool
+	d := &driver{
+		bk:     &embeddedBuildkit{},
+		logger: log.NullLogger{},
+		contextExtractor: func(logr.Logger, context.Context, string, string, archive.Options) (*archive.Extraction, error) {
+			extracted = true
+			return &archive.Extraction{ContentsDir: "/extracted"}, nil
+		},
+		ociExtractor: func(_ logr.Logger, _ context.Context, ref string, _ string, opts archive.Options) (*archive.Extraction, error) {
+			pulled = true
+			if opts.RegistryHosts == nil {
+				t.Errorf("expected registry hosts to be passed to oci extractor")
+			}
+			return &archive.Extraction{ContentsDir: "/artifact", Digest: "sha256:abc"}, nil
+		},
+		gitFetcher: func(_ context.Context, _ logr.Logger, src *git.Source, _ string, auth *git.Auth) (*git.Checkout, error) {
+			fetched = true
+			if src.Ref != "main" || src.Subdir != "app" {
+				t.Errorf("unexpected git source %+v", src)
+			}
+			if auth == nil || auth.Password != "token" {
+				t.Errorf("expected git auth to be passed to fetcher")
+			}
+			return &git.Checkout{Commit: "abc123", ContentsDir: "/repository/app"}, nil
+		},
+	}
+
+	bc, err := d.fetchContext(context.Background(), &config.BuildOptions{ContextURL: "https://example.com/context.tgz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !extracted || fetched || bc.ContentsDir != "/extracted" || bc.GitCommit != "" {
+		t.Errorf("expected archive context, got %+v", bc)
+	}
+
+	extracted = false
+	bc, err = d.fetchContext(context.Background(), &config.BuildOptions{
+		ContextURL: "https://example.com/org/repo.git#main:app",
+		GitAuth:    &git.Auth{Password: "token"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extracted || !fetched || bc.ContentsDir != "/repository/app" || bc.GitCommit != "abc123" {
+		t.Errorf("expected git context, got %+v", bc)
+	}
+
+	fetched = false
+	bc, err = d.fetchContext(context.Background(), &config.BuildOptions{ContextURL: "oci://registry.example.com/contexts/app@sha256:abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extracted || fetched || !pulled || bc.ContentsDir != "/artifact" || bc.Digest != "sha256:abc" {
+		t.Errorf("expected oci context, got %+v", bc)
+	}
+}
+
+func TestWithRevisionLabel(t *testing.T) {
+	opts := &config.BuildOptions{Labels: map[string]string{"team": "ml"}}
+
+	if actual := withRevisionLabel(opts, ""); actual != opts {
+		t.Error("expected options to be unchanged without a commit")
+	}
+
+	labeled := withRevisionLabel(opts, "abc123")
+	if labeled.Labels[revisionLabel] != "abc123" || labeled.Labels["team"] != "ml" {
+		t.Errorf("unexpected labels %v", labeled.Labels)
+	}
+	if _, ok := opts.Labels[revisionLabel]; ok {
+		t.Error("original labels should not be modified")
+	}
+
+	opts.Labels[revisionLabel] = "explicit"
+	if actual := withRevisionLabel(opts, "abc123"); actual.Labels[revisionLabel] != "explicit" {
+		t.Error("explicit revision label should take precedence")
+	}
+}
+
+func TestWriteInlineFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forge-inline-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := &config.BuildOptions{
+		DockerfilePath: "build/Dockerfile.prod",
+		Dockerfile:     []byte("FROM scratch"),
+		InlineFiles: map[string][]byte{
+			"build/Dockerfile.prod": []byte("FROM busybox"),
+			"app.py":                []byte("print('hello')"),
+		},
+	}
+	if err := writeInlineFiles(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"build/Dockerfile.prod": "FROM scratch",
+		"app.py":                "print('hello')",
+	}
+	for p, contents := range expected {
+		bs, err := ioutil.ReadFile(filepath.Join(dir, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bs) != contents {
+			t.Errorf("expected %q to contain %q, got %q", p, contents, string(bs))
+		}
+	}
+
+	if err := writeInlineFiles(dir, &config.BuildOptions{InlineFiles: map[string][]byte{"../escape": nil}}); err == nil {
+		t.Error("expected files outside of the context to be rejected")
+	}
+}
+
+func TestDriver_fetchVolumeContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forge-volume-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := &driver{logger: log.NullLogger{}}
+	bc, err := d.fetchRemoteContext(context.Background(), &config.BuildOptions{ContextDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bc.ContentsDir != dir {
+		t.Errorf("expected volume context to be used in place, got %q", bc.ContentsDir)
+	}
+}
+
+func TestDriver_fetchNamedContexts(t *testing.T) {
+	d := &driver{
+		bk:      &embeddedBuildkit{},
+		logger:  log.NullLogger{},
+		workDir: t.TempDir(),
+		contextExtractor: func(_ logr.Logger, _ context.Context, _ string, wd string, _ archive.Options) (*archive.Extraction, error) {
+			return &archive.Extraction{ContentsDir: wd, Digest: "sha256:assets"}, nil
+		},
+		gitFetcher: func(_ context.Context, _ logr.Logger, _ *git.Source, wd string, _ *git.Auth) (*git.Checkout, error) {
+			return &git.Checkout{Commit: "abc123", ContentsDir: wd}, nil
+		},
+	}
+	named, err := d.fetchNamedContexts(context.Background(), &config.BuildOptions{
+		AdditionalContexts: map[string]string{
+			"base":   "docker-image://alpine:3.15",
+			"shared": "https://example.com/org/shared.git#main",
+			"assets": "https://example.com/assets.tgz",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if named.Attrs["context:base"] != "docker-image://alpine:3.15" {
+		t.Errorf("expected image context to be passed to the frontend, got %v", named.Attrs)
+	}
+	if named.Attrs["context:assets"] != "local:named-context-assets" || named.LocalDirs["named-context-assets"] == "" {
+		t.Errorf("expected archive context to be served from a local directory, got %v %v", named.Attrs, named.LocalDirs)
+	}
+	if len(named.LocalDirs) != 2 {
+		t.Errorf("expected no local directories for image contexts, got %v", named.LocalDirs)
+	}
+
+	expected := []builder.AdditionalContext{
+		{Name: "assets", Digest: "sha256:assets"},
+		{Name: "shared", GitCommit: "abc123"},
+	}
+	if !reflect.DeepEqual(named.Fetched, expected) {
+		t.Errorf("expected fetched contexts %v, got %v", expected, named.Fetched)
+	}
+}
@@ -3,44 +3,82 @@ package embedded
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
 	"github.com/docker/distribution/reference"
 	"github.com/go-logr/logr"
-	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
-	"golang.org/x/sync/errgroup"
 
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
 	"github.com/dominodatalab/forge/internal/archive"
 	"github.com/dominodatalab/forge/internal/builder/embedded/bkimage"
 	"github.com/dominodatalab/forge/internal/builder/embedded/bkimage/types"
 	builder "github.com/dominodatalab/forge/internal/builder/types"
 	"github.com/dominodatalab/forge/internal/config"
+	"github.com/dominodatalab/forge/internal/git"
+	"github.com/dominodatalab/forge/internal/layercache"
 	"github.com/dominodatalab/forge/plugins/preparer"
 )
 
 type driver struct {
-	bk               *bkimage.Client
+	bk               Buildkit
 	logger           logr.Logger
 	preparerPlugins  []*preparer.Plugin
 	contextExtractor archive.Extractor
+	ociExtractor     archive.Extractor
+	gitFetcher       gitFetcher
 	cacheImageLayers bool
+	workDir          string
+	pusher           func(context.Context, string) error
+	resolver         func(context.Context, string) (digest.Digest, error)
 }
 
-func NewDriver(preparerPlugins []*preparer.Plugin, cacheImageLayers bool, logger logr.Logger) (*driver, error) {
-	client, err := bkimage.NewClient(config.GetStateDir(), types.AutoBackend, logger)
+func NewDriver(preparerPlugins []*preparer.Plugin, opts config.BuilderOptions, logger logr.Logger) (*driver, error) {
+	stateDir := opts.StateDir
+	if stateDir == "" {
+		stateDir = config.GetStateDir()
+	}
+
+	client, err := bkimage.NewClient(stateDir, types.AutoBackend, logger)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot create buildkit client")
 	}
+	client.SetGCKeepStorage(opts.StateGCKeepStorage)
+
+	return newDriver(&embeddedBuildkit{Client: client, logger: logger}, preparerPlugins, opts, logger), nil
+}
+
+// NewBuildkitDriver returns a driver that delegates solving and pushing images to another buildkit backend, e.g. an
+// external daemon. Build contexts are still fetched and prepared by the driver before they are synced to the backend.
+func NewBuildkitDriver(bk Buildkit, preparerPlugins []*preparer.Plugin, opts config.BuilderOptions, logger logr.Logger) *driver {
+	return newDriver(bk, preparerPlugins, opts, logger)
+}
+
+func newDriver(bk Buildkit, preparerPlugins []*preparer.Plugin, opts config.BuilderOptions, logger logr.Logger) *driver {
+	workDir := opts.WorkDir
+	if workDir == "" {
+		workDir = config.BuildContextPath
+	}
 
-	return &driver{
-		bk:               client,
+	d := &driver{
+		bk:               bk,
 		logger:           logger,
 		preparerPlugins:  preparerPlugins,
 		contextExtractor: archive.FetchAndExtract,
-		cacheImageLayers: cacheImageLayers,
-	}, nil
+		ociExtractor:     archive.FetchOCIAndExtract,
+		gitFetcher:       git.Fetch,
+		cacheImageLayers: opts.CacheImageLayers,
+		workDir:          workDir,
+	}
+	d.pusher = d.push
+	d.resolver = bk.ResolveImage
+
+	return d
 }
 
 func (d *driver) SetLogger(logger logr.Logger) {
@@ -49,66 +87,140 @@ func (d *driver) SetLogger(logger logr.Logger) {
 }
 
 func (d *driver) BuildAndPush(ctx context.Context, opts *config.BuildOptions) (*builder.Image, error) {
-	if len(opts.PushRegistries) == 0 {
+	if len(opts.PushRegistries) == 0 && !opts.BuildOnly {
 		return nil, errors.New("image builds require at least one push registry")
 	}
+	defer d.pruneState()
 
+	if opts.Timeout <= 0 {
+		return d.buildAndPush(ctx, opts)
+	}
+
+	// cancel every fetch, solve and push operation once the build deadline passes
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	image, err := d.buildAndPush(ctx, opts)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%w after %s: %v", builder.ErrBuildTimeout, opts.Timeout, err)
+	}
+	return image, err
+}
+
+func (d *driver) buildAndPush(ctx context.Context, opts *config.BuildOptions) (*builder.Image, error) {
 	// configure registry hosts for every run and reset afterwards
 	d.bk.ConfigureHosts(generateRegistryFunc(opts.Registries))
 	defer func() { d.bk.ResetHostConfigurations() }()
 
-	var headImg string
-	var images []string
-	var imageSize uint64
-	for idx, registry := range opts.PushRegistries {
+	startedAt := time.Now()
+
+	// resolve the image repository in every registry, the first one is used to build the image
+	var repos []pushTarget
+	for _, registry := range opts.PushRegistries {
 		// Build fully-qualified image name
-		image := fmt.Sprintf("%s/%s", registry, opts.ImageName)
+		name := fmt.Sprintf("%s/%s", registry, opts.ImageName)
 
 		// Parse the image name and tag.
-		named, err := reference.ParseNormalizedNamed(image)
+		named, err := reference.ParseNormalizedNamed(name)
+		if err != nil {
+			return nil, fmt.Errorf("parsing image name %q failed: %v", name, err)
+		}
+		repos = append(repos, pushTarget{registry: registry, named: named})
+	}
+
+	// images that are only built are named without a registry
+	if len(repos) == 0 {
+		named, err := reference.ParseNormalizedNamed(opts.ImageName)
 		if err != nil {
-			return nil, fmt.Errorf("parsing image name %q failed: %v", image, err)
+			return nil, fmt.Errorf("parsing image name %q failed: %v", opts.ImageName, err)
 		}
+		repos = append(repos, pushTarget{named: named})
+	}
 
-		// Add the latest tag if they did not provide one.
-		named = reference.TagNameOnly(named)
-		image = named.String()
+	// Check tags known before building against the tag policy
+	digests := map[string]digest.Digest{}
+	if skipped, err := d.checkStaticTags(ctx, repos, opts, startedAt, digests); err != nil || skipped != nil {
+		return skipped, err
+	}
 
-		if idx == 0 { // Build, check image size, and set ref to head image
-			headImg = image
+	// Add the latest tag if they did not provide one.
+	headImg := reference.TagNameOnly(repos[0].named).String()
 
-			if err := d.build(ctx, headImg, opts); err != nil {
-				return nil, err
-			}
-			if imageSize, err = d.validateImageSize(ctx, headImg, opts.ImageSizeLimit); err != nil {
-				return nil, err
-			}
-		} else { // Tag tail images
-			if err := d.tag(ctx, headImg, image); err != nil {
+	// Build and check image size
+	bc, err := d.build(ctx, headImg, opts, startedAt)
+	if err != nil {
+		return nil, err
+	}
+	image, err := d.inspectImage(ctx, headImg, opts.ImageSizeLimit)
+	if err != nil {
+		return nil, err
+	}
+	image.GitCommit = bc.GitCommit
+	image.ContextDigest = bc.Digest
+	image.AdditionalContexts = bc.AdditionalContexts
+
+	if opts.BuildOnly {
+		d.logger.Info("Pushing is disabled, skipping image push", "image", headImg, "digest", image.Digest)
+		return image, nil
+	}
+
+	// Tag images with every tag in every registry
+	tags, err := imageTags(repos[0].named, image, opts, startedAt)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := pushTargets(repos, tags)
+	if err != nil {
+		return nil, err
+	}
+	if targets, err = d.applyTagPolicy(ctx, image, targets, opts, digests); err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		if name := target.named.String(); name != headImg {
+			if err := d.tag(ctx, headImg, name); err != nil {
 				return nil, err
 			}
 		}
-
-		// Push image into registry
-		if err := d.push(ctx, image); err != nil {
-			return nil, err
-		}
-		images = append(images, image)
 	}
 
-	// Return a list of every registry image
-	return &builder.Image{
-		URLs: images,
-		Size: imageSize,
-	}, nil
+	// Push images into every registry
+	if err := d.pushAll(ctx, image, targets, opts); err != nil {
+		return nil, err
+	}
+	return image, nil
 }
 
-func (d *driver) build(ctx context.Context, image string, opts *config.BuildOptions) error {
-	// download and extract remote OCI context
-	extract, err := d.contextExtractor(d.logger, ctx, opts.ContextURL, config.BuildContextPath, opts.ContextTimeout)
+// builds an image and returns the build context it was built from
+func (d *driver) build(ctx context.Context, image string, opts *config.BuildOptions, startedAt time.Time) (*buildContext, error) {
+	// fail fast instead of waiting for the solver to reach a RUN instruction it cannot execute
+	if err := d.bk.ValidatePlatforms(ctx, opts.Platforms); err != nil {
+		return nil, err
+	}
+
+	bc, err := d.fetchContext(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateCommitTags(opts, bc.GitCommit); err != nil {
+		return nil, err
+	}
+	opts = withRevisionLabel(opts, bc.GitCommit)
+
+	data := forgev1alpha1.NewTagTemplateData(opts.BuildName, opts.BuildNamespace, "", contextGitRef(opts.ContextURL), bc.GitCommit, startedAt)
+	if opts, err = withCacheKeys(opts, data); err != nil {
+		return nil, err
+	}
+	opts, uploadCache, err := stageS3Cache(ctx, d.workDir, image, d.cacheImageLayers, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	named, err := d.fetchNamedContexts(ctx, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	bc.AdditionalContexts = named.Fetched
 
 	for _, preparerPlugin := range d.preparerPlugins {
 		defer func() {
@@ -118,49 +230,59 @@ func (d *driver) build(ctx context.Context, image string, opts *config.BuildOpti
 		}()
 
 		d.logger.Info("Preparing resources for image build context")
-		if err := preparerPlugin.Prepare(extract.ContentsDir, opts.PluginData); err != nil {
-			return err
+		if err := preparerPlugin.Prepare(bc.ContentsDir, opts.PluginData); err != nil {
+			return nil, err
 		}
 		d.logger.Info("Resource preparation complete")
 		d.logger.Info(strings.Repeat("=", 70))
 	}
 
-	// assume Dockerfile lives inside context root
+	// dockerfile path is relative to the context root
+	dockerfile, err := resolveDockerfile(bc.ContentsDir, opts.DockerfilePath)
+	if err != nil {
+		return nil, err
+	}
 	localDirs := map[string]string{
-		"context":    extract.ContentsDir,
-		"dockerfile": extract.ContentsDir,
+		"context":    bc.ContentsDir,
+		"dockerfile": filepath.Dir(dockerfile),
 	}
-
-	// create a new buildkit session
-	sess, sessDialer, err := d.bk.Session(ctx, localDirs)
-	if err != nil {
-		return err
+	for name, dir := range named.LocalDirs {
+		localDirs[name] = dir
 	}
 
 	// prepare build parameters
-	solveReq, err := solveRequestWithContext(sess.ID(), image, d.cacheImageLayers, opts)
+	solveReq, err := solveRequestWithContext("", image, d.cacheImageLayers, opts)
 	if err != nil {
-		sess.Close()
-		return err
+		return nil, err
 	}
+	addNamedContexts(solveReq, named.Attrs)
+	// symlinked dockerfiles are sent under the name of the file they resolve to
+	solveReq.FrontendAttrs["filename"] = filepath.Base(dockerfile)
 
-	// add build metadata to context
-	ctx = namespaces.WithNamespace(ctx, "buildkit")
-	eg, ctx := errgroup.WithContext(ctx)
-
-	// launch build
-	ch := make(chan *controlapi.StatusResponse)
-	eg.Go(func() error {
-		return sess.Run(ctx, sessDialer)
-	})
-	eg.Go(func() error {
-		defer sess.Close()
-		return d.bk.Solve(ctx, solveReq, ch)
-	})
-	eg.Go(func() error { return displayProgress(ch, &bkimage.LogrWriter{Logger: d.logger}) })
-
-	// return error when one occurs
-	return eg.Wait()
+	if err := d.bk.Build(ctx, solveReq, localDirs, opts); err != nil {
+		return nil, err
+	}
+
+	if err := uploadCache(ctx); err != nil {
+		return nil, err
+	}
+	if opts.Cache.Backend == forgev1alpha1.CacheBackendLocal && len(solveReq.Cache.Exports) != 0 {
+		// exceeding the cache budget must not fail builds that already succeeded
+		if err := layercache.Prune(opts.Cache.Local); err != nil {
+			d.logger.Error(err, "Cannot prune local layer cache")
+		}
+	}
+	return bc, nil
+}
+
+// keeps a state directory that outlives the build below its size budget. failures are logged because the image has
+// already been built and pushed at this point.
+func (d *driver) pruneState() {
+	ctx := namespaces.WithNamespace(context.Background(), "buildkit")
+
+	if err := d.bk.Prune(ctx); err != nil {
+		d.logger.Error(err, "Cannot prune buildkit state")
+	}
 }
 
 func (d *driver) tag(ctx context.Context, image, target string) error {
@@ -170,40 +292,48 @@ func (d *driver) tag(ctx context.Context, image, target string) error {
 }
 
 func (d *driver) push(ctx context.Context, image string) error {
-	sess, sessDialer, err := d.bk.Session(ctx, nil)
-	if err != nil {
-		return err
-	}
-
 	ctx = namespaces.WithNamespace(ctx, "buildkit")
-	eg, ctx := errgroup.WithContext(ctx)
-
-	eg.Go(func() error {
-		return sess.Run(ctx, sessDialer)
-	})
-	eg.Go(func() error {
-		defer sess.Close()
-		return d.bk.PushImage(ctx, sess.ID(), image)
-	})
-	if err := eg.Wait(); err != nil {
-		return err
-	}
 
-	return nil
+	return d.bk.PushImage(ctx, image)
 }
 
-func (d *driver) validateImageSize(ctx context.Context, name string, limit uint64) (uint64, error) {
+// reads the digests and sizes of a built image and ensures that every platform image is within the size limit
+func (d *driver) inspectImage(ctx context.Context, name string, limit uint64) (*builder.Image, error) {
 	ctx = namespaces.WithNamespace(ctx, "buildkit")
 
-	image, err := d.bk.GetImage(ctx, name)
+	listed, err := d.bk.GetImage(ctx, name)
 	if err != nil {
-		return 0, fmt.Errorf("cannot validate image size: %v", err)
+		return nil, fmt.Errorf("cannot validate image size: %v", err)
+	}
+	// backends may only report the digest of images they did not push, which is enough unless a limit applies
+	if len(listed.Platforms) == 0 && limit > 0 {
+		return nil, fmt.Errorf("cannot validate image size: no platform images found for %q", name)
+	}
+
+	image := &builder.Image{
+		Size:   uint64(listed.ContentSize),
+		Digest: listed.Target.Digest.String(),
+	}
+	for _, pi := range listed.Platforms {
+		platform := platforms.Format(pi.Platform)
+		size := uint64(pi.ContentSize)
+
+		if limit > 0 && size > limit {
+			return nil, fmt.Errorf("image %q for platform %s is too large to push to registry (size: %d, limit: %d)", name, platform, size, limit)
+		}
+
+		image.Platforms = append(image.Platforms, builder.PlatformImage{
+			Platform:     platform,
+			Digest:       pi.Digest.String(),
+			ConfigDigest: pi.ConfigDigest.String(),
+			Size:         size,
+		})
 	}
 
-	imageSize := uint64(image.ContentSize)
-	if limit > 0 && imageSize > limit {
-		return 0, fmt.Errorf("image %q is too large to push to registry (size: %d, limit: %d)", name, imageSize, limit)
+	// images built for multiple platforms are indexes without a config of their own
+	if len(listed.Platforms) == 1 && listed.Platforms[0].Digest == listed.Target.Digest {
+		image.ConfigDigest = listed.Platforms[0].ConfigDigest.String()
 	}
 
-	return imageSize, nil
+	return image, nil
 }
@@ -0,0 +1,154 @@
+package embedded
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	remoteerrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/semaphore"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	builder "github.com/dominodatalab/forge/internal/builder/types"
+	"github.com/dominodatalab/forge/internal/config"
+)
+
+// number of registries pushed to at the same time when a limit is not provided
+const defaultPushConcurrency = 3
+
+// retries transient registry errors for roughly a minute before giving up on a registry
+var pushBackoff = wait.Backoff{
+	Duration: 2 * time.Second,
+	Factor:   2,
+	Steps:    5,
+	Jitter:   0.1,
+	Cap:      30 * time.Second,
+}
+
+// pushTarget is the fully-qualified image name of a build in a single push registry.
+type pushTarget struct {
+	registry string
+	named    reference.Named
+}
+
+// pushes an image to every target concurrently and records the outcome of each push in the image. unless best effort
+// pushing is enabled, the first failure cancels every other push. a push error is returned when any push failed.
+func (d *driver) pushAll(ctx context.Context, image *builder.Image, targets []pushTarget, opts *config.BuildOptions) error {
+	concurrency := opts.PushConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultPushConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]builder.PushResult, len(targets))
+	sem := semaphore.NewWeighted(int64(concurrency))
+	var wg sync.WaitGroup
+
+	for idx, target := range targets {
+		result := &results[idx]
+		result.Registry = target.registry
+		result.URL = target.named.String()
+
+		// pushed manifests keep their digest, reference them by it in every registry
+		if digested, err := reference.WithDigest(reference.TrimNamed(target.named), digest.Digest(image.Digest)); err == nil {
+			result.Reference = digested.String()
+		}
+
+		if err := sem.Acquire(ctx, 1); err != nil {
+			result.Error = err.Error()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer sem.Release(1)
+
+			if err := d.pushWithRetry(ctx, result.URL); err != nil {
+				result.Error = err.Error()
+				if !opts.PushBestEffort {
+					cancel()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var failures []string
+	for _, result := range results {
+		if result.Error != "" {
+			failures = append(failures, fmt.Sprintf("%s: %s", result.Registry, result.Error))
+			continue
+		}
+		image.URLs = append(image.URLs, result.URL)
+	}
+	image.Pushes = append(image.Pushes, results...)
+
+	if len(failures) == 0 {
+		return nil
+	}
+	return &builder.PushError{
+		Image: image,
+		Err:   fmt.Errorf("pushing image failed for %d of %d registries (%s)", len(failures), len(targets), strings.Join(failures, "; ")),
+	}
+}
+
+// pushes an image, retrying transient registry errors with backoff. cancelling the context stops any retries,
+// including one that is waiting for its backoff to elapse.
+func (d *driver) pushWithRetry(ctx context.Context, name string) error {
+	backoff := pushBackoff
+	for attempt := 1; ; attempt++ {
+		err := d.pusher(ctx, name)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !isTransientPushError(err) || attempt >= pushBackoff.Steps {
+			return err
+		}
+
+		d.logger.Error(err, "Received transient error while pushing image, will attempt to retry", "image", name)
+
+		timer := time.NewTimer(backoff.Step())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// returns true for connection failures and registry responses that indicate a temporary condition
+func isTransientPushError(err error) bool {
+	// cancelled pushes are never retried, even though the underlying dial or read error may look like a timeout
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var status remoteerrors.ErrUnexpectedStatus
+	if errors.As(err, &status) {
+		switch status.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+			http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
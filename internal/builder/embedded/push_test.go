@@ -0,0 +1,173 @@
+package embedded
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	remoteerrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/docker/distribution/reference"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	builder "github.com/dominodatalab/forge/internal/builder/types"
+	"github.com/dominodatalab/forge/internal/config"
+)
+
+func testPushTargets(t *testing.T, registries ...string) []pushTarget {
+	var targets []pushTarget
+	for _, registry := range registries {
+		named, err := reference.ParseNormalizedNamed(registry + "/app:v1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, pushTarget{registry: registry, named: named})
+	}
+	return targets
+}
+
+func TestDriver_pushAll(t *testing.T) {
+	defer func(b wait.Backoff) { pushBackoff = b }(pushBackoff)
+	pushBackoff = wait.Backoff{Duration: time.Millisecond, Steps: 3}
+
+	const imageDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	unavailable := remoteerrors.ErrUnexpectedStatus{Status: "503 Service Unavailable", StatusCode: http.StatusServiceUnavailable}
+	unauthorized := remoteerrors.ErrUnexpectedStatus{Status: "401 Unauthorized", StatusCode: http.StatusUnauthorized}
+
+	t.Run("retries_transient_errors", func(t *testing.T) {
+		var mu sync.Mutex
+		attempts := map[string]int{}
+		d := &driver{logger: log.NullLogger{}, pusher: func(_ context.Context, name string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts[name]++
+			if name == "registry-b.io/app:v1" && attempts[name] < 3 {
+				return fmt.Errorf("pushing manifest: %w", unavailable)
+			}
+			return nil
+		}}
+
+		image := &builder.Image{Digest: imageDigest}
+		err := d.pushAll(context.Background(), image, testPushTargets(t, "registry-a.io", "registry-b.io"), &config.BuildOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempts["registry-b.io/app:v1"] != 3 {
+			t.Errorf("expected transient error to be retried, got %d attempts", attempts["registry-b.io/app:v1"])
+		}
+		if len(image.URLs) != 2 || len(image.Pushes) != 2 {
+			t.Errorf("expected image to be pushed to every registry, got %+v", image)
+		}
+		if ref := image.Pushes[1].Reference; ref != "registry-b.io/app@"+imageDigest {
+			t.Errorf("expected digest reference, got %q", ref)
+		}
+	})
+
+	t.Run("best_effort", func(t *testing.T) {
+		var mu sync.Mutex
+		var pushed []string
+		d := &driver{logger: log.NullLogger{}, pusher: func(_ context.Context, name string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			pushed = append(pushed, name)
+			if name == "registry-a.io/app:v1" {
+				return unauthorized
+			}
+			return nil
+		}}
+
+		image := &builder.Image{Digest: imageDigest}
+		err := d.pushAll(context.Background(), image, testPushTargets(t, "registry-a.io", "registry-b.io", "registry-c.io"), &config.BuildOptions{
+			PushBestEffort:  true,
+			PushConcurrency: 1,
+		})
+
+		var pushErr *builder.PushError
+		if !errors.As(err, &pushErr) {
+			t.Fatalf("expected push error, got %v", err)
+		}
+		if len(pushed) != 3 {
+			t.Errorf("expected every registry to be attempted once, got %v", pushed)
+		}
+		if len(image.URLs) != 2 || image.Pushes[0].Error == "" || image.Pushes[1].Error != "" || image.Pushes[2].Error != "" {
+			t.Errorf("expected only the first registry to fail, got %+v", image.Pushes)
+		}
+	})
+
+	t.Run("fail_fast", func(t *testing.T) {
+		d := &driver{logger: log.NullLogger{}, pusher: func(ctx context.Context, name string) error {
+			if name == "registry-a.io/app:v1" {
+				return unauthorized
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		}}
+
+		image := &builder.Image{Digest: imageDigest}
+		err := d.pushAll(context.Background(), image, testPushTargets(t, "registry-a.io", "registry-b.io"), &config.BuildOptions{})
+
+		var pushErr *builder.PushError
+		if !errors.As(err, &pushErr) {
+			t.Fatalf("expected push error, got %v", err)
+		}
+		if len(image.URLs) != 0 || image.Pushes[0].Error == "" || image.Pushes[1].Error == "" {
+			t.Errorf("expected remaining pushes to be cancelled, got %+v", image.Pushes)
+		}
+	})
+}
+
+func TestDriver_pushWithRetryCancelledDuringBackoff(t *testing.T) {
+	defer func(b wait.Backoff) { pushBackoff = b }(pushBackoff)
+	pushBackoff = wait.Backoff{Duration: time.Hour, Steps: 3}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	attempts := 0
+	d := &driver{logger: log.NullLogger{}, pusher: func(context.Context, string) error {
+		attempts++
+		time.AfterFunc(10*time.Millisecond, cancel)
+		return remoteerrors.ErrUnexpectedStatus{StatusCode: http.StatusServiceUnavailable}
+	}}
+
+	done := make(chan error, 1)
+	go func() { done <- d.pushWithRetry(ctx, "registry.io/app:v1") }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context cancellation, got %v", err)
+		}
+		if attempts != 1 {
+			t.Errorf("expected no attempts after cancellation, got %d", attempts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("push kept waiting for its backoff after the context was cancelled")
+	}
+}
+
+func TestIsTransientPushError(t *testing.T) {
+	testCases := []struct {
+		err       error
+		transient bool
+	}{
+		{remoteerrors.ErrUnexpectedStatus{StatusCode: http.StatusBadGateway}, true},
+		{remoteerrors.ErrUnexpectedStatus{StatusCode: http.StatusTooManyRequests}, true},
+		{remoteerrors.ErrUnexpectedStatus{StatusCode: http.StatusForbidden}, false},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{errors.New("manifest invalid"), false},
+		{context.Canceled, false},
+		{&net.OpError{Op: "read", Err: context.DeadlineExceeded}, false},
+	}
+
+	for _, tc := range testCases {
+		if actual := isTransientPushError(fmt.Errorf("push failed: %w", tc.err)); actual != tc.transient {
+			t.Errorf("expected transient=%t for %v, got %t", tc.transient, tc.err, actual)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package embedded
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+	builder "github.com/dominodatalab/forge/internal/builder/types"
+	"github.com/dominodatalab/forge/internal/config"
+)
+
+const (
+	// default tag used when neither the image name nor the build provide one
+	defaultTag = "latest"
+
+	// values used to detect tags that depend on the built image
+	placeholderDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	placeholderCommit = "0000000000000000000000000000000000000000"
+)
+
+// returns the tags pushed for an image: the tag included in the image name followed by every rendered tag template.
+// duplicates are removed and images without any tag use the default tag.
+func imageTags(named reference.Named, image *builder.Image, opts *config.BuildOptions, startedAt time.Time) ([]string, error) {
+	data := forgev1alpha1.NewTagTemplateData(opts.BuildName, opts.BuildNamespace, image.Digest, contextGitRef(opts.ContextURL), image.GitCommit, startedAt)
+
+	var tags []string
+	for _, tmpl := range tagTemplates(named, opts) {
+		tag, err := forgev1alpha1.RenderTag(tmpl, data)
+		if err != nil {
+			return nil, err
+		}
+		tags = appendTag(tags, tag)
+	}
+
+	return tags, nil
+}
+
+// returns the tags that are known before building because they do not depend on the built image or its context.
+// complete is false when some tags can only be rendered after building.
+func staticTags(named reference.Named, opts *config.BuildOptions, startedAt time.Time) (tags []string, complete bool) {
+	gitRef := contextGitRef(opts.ContextURL)
+	unknown := forgev1alpha1.NewTagTemplateData(opts.BuildName, opts.BuildNamespace, "", gitRef, "", startedAt)
+	placeholder := forgev1alpha1.NewTagTemplateData(opts.BuildName, opts.BuildNamespace, placeholderDigest, gitRef, placeholderCommit, startedAt)
+
+	complete = true
+	for _, tmpl := range tagTemplates(named, opts) {
+		before, err := forgev1alpha1.RenderTag(tmpl, unknown)
+		if err != nil {
+			complete = false
+			continue
+		}
+		if after, err := forgev1alpha1.RenderTag(tmpl, placeholder); err != nil || before != after {
+			complete = false
+			continue
+		}
+		tags = appendTag(tags, before)
+	}
+
+	return tags, complete
+}
+
+// returns an error when a tag template uses the git commit but the build context was not fetched from a git
+// repository. these templates would otherwise render the same tag, e.g. "sha-", for every build.
+func validateCommitTags(opts *config.BuildOptions, gitCommit string) error {
+	if gitCommit != "" || opts.BuildOnly {
+		return nil
+	}
+	for _, tmpl := range opts.Tags {
+		if forgev1alpha1.TagUsesGitCommit(tmpl) {
+			return fmt.Errorf("tag template %q uses the git commit but the build context is not a git repository", tmpl)
+		}
+	}
+	return nil
+}
+
+func tagTemplates(named reference.Named, opts *config.BuildOptions) []string {
+	var templates []string
+	if tagged, ok := named.(reference.Tagged); ok {
+		templates = append(templates, tagged.Tag())
+	}
+	templates = append(templates, opts.Tags...)
+	if len(templates) == 0 {
+		templates = append(templates, defaultTag)
+	}
+	return templates
+}
+
+func appendTag(tags []string, tag string) []string {
+	for _, t := range tags {
+		if t == tag {
+			return tags
+		}
+	}
+	return append(tags, tag)
+}
+
+// returns the tagged name of every tag in every registry repository
+func pushTargets(repos []pushTarget, tags []string) ([]pushTarget, error) {
+	var targets []pushTarget
+	for _, repo := range repos {
+		for _, tag := range tags {
+			named, err := reference.WithTag(reference.TrimNamed(repo.named), tag)
+			if err != nil {
+				return nil, fmt.Errorf("cannot tag image %q with %q: %v", repo.named, tag, err)
+			}
+			targets = append(targets, pushTarget{registry: repo.registry, named: named})
+		}
+	}
+	return targets, nil
+}
+
+// checks the tags that are known before building against the tag policy. existing tags fail the build with the
+// failIfExists policy. with the skipIfExists policy, an image describing the existing tags is returned when the build
+// can be skipped because every tag exists.
+func (d *driver) checkStaticTags(ctx context.Context, repos []pushTarget, opts *config.BuildOptions, startedAt time.Time, digests map[string]digest.Digest) (*builder.Image, error) {
+	if opts.BuildOnly || (opts.TagPolicy != forgev1alpha1.TagPolicyFailIfExists && opts.TagPolicy != forgev1alpha1.TagPolicySkipIfExists) {
+		return nil, nil
+	}
+
+	tags, complete := staticTags(repos[0].named, opts, startedAt)
+	targets, err := pushTargets(repos, tags)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.resolveTags(ctx, targets, digests); err != nil {
+		return nil, err
+	}
+
+	existing := existingTags(targets, digests)
+	switch {
+	case len(existing) == 0:
+		return nil, nil
+	case opts.TagPolicy == forgev1alpha1.TagPolicyFailIfExists:
+		return nil, &builder.TagExistsError{Images: existing}
+	case !complete || len(existing) != len(targets):
+		return nil, nil
+	}
+
+	d.logger.Info("Every image tag already exists, skipping build", "images", existing)
+	image := &builder.Image{Skipped: true, Digest: digests[existing[0]].String()}
+	skipExistingTags(image, targets, digests)
+	return image, nil
+}
+
+// applies the tag policy to every push target after building. returns the targets that should be pushed.
+func (d *driver) applyTagPolicy(ctx context.Context, image *builder.Image, targets []pushTarget, opts *config.BuildOptions, digests map[string]digest.Digest) ([]pushTarget, error) {
+	if opts.TagPolicy != forgev1alpha1.TagPolicyFailIfExists && opts.TagPolicy != forgev1alpha1.TagPolicySkipIfExists {
+		return targets, nil
+	}
+
+	// tags known before building were already checked
+	if err := d.resolveTags(ctx, targets, digests); err != nil {
+		return nil, err
+	}
+
+	if opts.TagPolicy == forgev1alpha1.TagPolicyFailIfExists {
+		if existing := existingTags(targets, digests); len(existing) != 0 {
+			return nil, &builder.TagExistsError{Images: existing}
+		}
+		return targets, nil
+	}
+	return skipExistingTags(image, targets, digests), nil
+}
+
+// resolves the digest of every target that has not been checked yet. targets that do not exist are recorded with an
+// empty digest.
+func (d *driver) resolveTags(ctx context.Context, targets []pushTarget, digests map[string]digest.Digest) error {
+	for _, target := range targets {
+		name := target.named.String()
+		if _, ok := digests[name]; ok {
+			continue
+		}
+
+		dgst, err := d.resolver(ctx, name)
+		if err != nil {
+			return err
+		}
+		digests[name] = dgst
+	}
+	return nil
+}
+
+// returns the names of the targets that exist
+func existingTags(targets []pushTarget, digests map[string]digest.Digest) []string {
+	var names []string
+	for _, target := range targets {
+		if name := target.named.String(); digests[name] != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// records targets that already exist as skipped pushes and returns the remaining targets
+func skipExistingTags(image *builder.Image, targets []pushTarget, digests map[string]digest.Digest) []pushTarget {
+	var remaining []pushTarget
+	for _, target := range targets {
+		name := target.named.String()
+		dgst := digests[name]
+		if dgst == "" {
+			remaining = append(remaining, target)
+			continue
+		}
+
+		result := builder.PushResult{Registry: target.registry, URL: name, Skipped: true}
+		if digested, err := reference.WithDigest(reference.TrimNamed(target.named), dgst); err == nil {
+			result.Reference = digested.String()
+		}
+		image.URLs = append(image.URLs, name)
+		image.Pushes = append(image.Pushes, result)
+	}
+	return remaining
+}
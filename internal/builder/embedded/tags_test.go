@@ -0,0 +1,189 @@
+package embedded
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+
+	builder "github.com/dominodatalab/forge/internal/builder/types"
+	"github.com/dominodatalab/forge/internal/config"
+)
+
+func TestImageTags(t *testing.T) {
+	image := &builder.Image{GitCommit: "3f786850e387550fdab836ed7e6dc881de23001b"}
+
+	testCases := []struct {
+		name      string
+		imageName string
+		tags      []string
+		expected  []string
+	}{
+		{"default", "registry.io/app", nil, []string{"latest"}},
+		{"image_name", "registry.io/app:1.4.2", nil, []string{"1.4.2"}},
+		{"templates", "registry.io/app", []string{"1.4.2", "1.4", "sha-{{ .ShortCommit }}"}, []string{"1.4.2", "1.4", "sha-3f78685"}},
+		{"duplicates", "registry.io/app:1.4.2", []string{"1.4.2", "1.4"}, []string{"1.4.2", "1.4"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			named, err := reference.ParseNormalizedNamed(tc.imageName)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actual, err := imageTags(named, image, &config.BuildOptions{Tags: tc.tags}, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected tags %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+
+	named, _ := reference.ParseNormalizedNamed("registry.io/app")
+	if _, err := imageTags(named, &builder.Image{}, &config.BuildOptions{Tags: []string{"{{ .ShortCommit }}"}}, time.Now()); err == nil {
+		t.Error("expected error for a tag that renders empty, got none")
+	}
+}
+
+func TestValidateCommitTags(t *testing.T) {
+	opts := &config.BuildOptions{Tags: []string{"1.4.2", "sha-{{ .ShortCommit }}"}}
+
+	if err := validateCommitTags(opts, "3f786850e387550fdab836ed7e6dc881de23001b"); err != nil {
+		t.Errorf("expected commit tags of git contexts to be valid, got %v", err)
+	}
+	if err := validateCommitTags(opts, ""); err == nil {
+		t.Error("expected error for commit tags of a context without a commit, got none")
+	}
+	if err := validateCommitTags(&config.BuildOptions{Tags: opts.Tags, BuildOnly: true}, ""); err != nil {
+		t.Errorf("expected tags of unpushed images to be ignored, got %v", err)
+	}
+	if err := validateCommitTags(&config.BuildOptions{Tags: []string{"{{ .Name }}-{{ .Timestamp }}"}}, ""); err != nil {
+		t.Errorf("expected tags without the commit to be valid, got %v", err)
+	}
+}
+
+func TestPushTargets(t *testing.T) {
+	repos := testPushTargets(t, "registry-a.io", "registry-b.io")
+
+	targets, err := pushTargets(repos, []string{"1.4.2", "1.4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual []string
+	for _, target := range targets {
+		actual = append(actual, target.registry+"="+target.named.String())
+	}
+	expected := []string{
+		"registry-a.io=registry-a.io/app:1.4.2",
+		"registry-a.io=registry-a.io/app:1.4",
+		"registry-b.io=registry-b.io/app:1.4.2",
+		"registry-b.io=registry-b.io/app:1.4",
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected targets %v, got %v", expected, actual)
+	}
+}
+
+func TestStaticTags(t *testing.T) {
+	named, err := reference.ParseNormalizedNamed("registry.io/app:1.4.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags, complete := staticTags(named, &config.BuildOptions{BuildName: "build-1", Tags: []string{"1.4", "{{ .Name }}"}}, time.Now())
+	if !complete || !reflect.DeepEqual([]string{"1.4.2", "1.4", "build-1"}, tags) {
+		t.Errorf("expected every tag to be static, got %v (complete: %t)", tags, complete)
+	}
+
+	tags, complete = staticTags(named, &config.BuildOptions{Tags: []string{"sha-{{ .ShortCommit }}", "{{ .ShortDigest }}"}}, time.Now())
+	if complete || !reflect.DeepEqual([]string{"1.4.2"}, tags) {
+		t.Errorf("expected build dependent tags to be excluded, got %v (complete: %t)", tags, complete)
+	}
+}
+
+func TestDriver_checkStaticTags(t *testing.T) {
+	const existingDigest = digest.Digest("sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	repos := testPushTargets(t, "registry-a.io", "registry-b.io")
+
+	newDriver := func(existing ...string) *driver {
+		return &driver{logger: log.NullLogger{}, resolver: func(_ context.Context, name string) (digest.Digest, error) {
+			for _, e := range existing {
+				if e == name {
+					return existingDigest, nil
+				}
+			}
+			return "", nil
+		}}
+	}
+
+	t.Run("overwrite", func(t *testing.T) {
+		d := &driver{resolver: func(context.Context, string) (digest.Digest, error) {
+			t.Fatal("expected tags not to be resolved")
+			return "", nil
+		}}
+		skipped, err := d.checkStaticTags(context.Background(), repos, &config.BuildOptions{}, time.Now(), map[string]digest.Digest{})
+		if err != nil || skipped != nil {
+			t.Errorf("expected build to proceed, got %v %v", skipped, err)
+		}
+	})
+
+	t.Run("fail_if_exists", func(t *testing.T) {
+		d := newDriver("registry-b.io/app:v1")
+		opts := &config.BuildOptions{TagPolicy: forgev1alpha1.TagPolicyFailIfExists}
+
+		_, err := d.checkStaticTags(context.Background(), repos, opts, time.Now(), map[string]digest.Digest{})
+		var tagErr *builder.TagExistsError
+		if !errors.As(err, &tagErr) || !reflect.DeepEqual([]string{"registry-b.io/app:v1"}, tagErr.Images) {
+			t.Errorf("expected tag exists error, got %v", err)
+		}
+	})
+
+	t.Run("skip_if_exists", func(t *testing.T) {
+		d := newDriver("registry-a.io/app:v1", "registry-b.io/app:v1")
+		opts := &config.BuildOptions{TagPolicy: forgev1alpha1.TagPolicySkipIfExists}
+
+		skipped, err := d.checkStaticTags(context.Background(), repos, opts, time.Now(), map[string]digest.Digest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skipped == nil || !skipped.Skipped || skipped.Digest != existingDigest.String() || len(skipped.Pushes) != 2 {
+			t.Fatalf("expected build to be skipped, got %+v", skipped)
+		}
+		if ref := skipped.Pushes[0].Reference; ref != "registry-a.io/app@"+existingDigest.String() {
+			t.Errorf("expected existing digest reference, got %q", ref)
+		}
+	})
+
+	t.Run("skip_if_some_exist", func(t *testing.T) {
+		d := newDriver("registry-a.io/app:v1")
+		opts := &config.BuildOptions{TagPolicy: forgev1alpha1.TagPolicySkipIfExists}
+		digests := map[string]digest.Digest{}
+
+		skipped, err := d.checkStaticTags(context.Background(), repos, opts, time.Now(), digests)
+		if err != nil || skipped != nil {
+			t.Fatalf("expected build to proceed, got %v %v", skipped, err)
+		}
+
+		image := &builder.Image{}
+		remaining, err := d.applyTagPolicy(context.Background(), image, testPushTargets(t, "registry-a.io", "registry-b.io"), opts, digests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(remaining) != 1 || remaining[0].registry != "registry-b.io" {
+			t.Errorf("expected only missing tags to be pushed, got %v", remaining)
+		}
+		if len(image.Pushes) != 1 || !image.Pushes[0].Skipped {
+			t.Errorf("expected existing tag to be recorded as skipped, got %+v", image.Pushes)
+		}
+	})
+}
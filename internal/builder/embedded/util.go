@@ -2,27 +2,41 @@ package embedded
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 
-	"github.com/docker/distribution/reference"
 	controlapi "github.com/moby/buildkit/api/services/control"
 	bkclient "github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/cmd/buildctl/build"
 	"github.com/moby/buildkit/identity"
 	"github.com/moby/buildkit/util/progress/progressui"
 
+	forgev1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
 	"github.com/dominodatalab/forge/internal/builder/embedded/bkimage"
 	"github.com/dominodatalab/forge/internal/config"
+	"github.com/dominodatalab/forge/internal/util"
 )
 
 const (
-	// common tag name that will be used when registry image caching is enabled.
+	// common tag name that will be used when registry image caching is enabled and no cache key is configured.
 	cacheTag = "buildcache"
 
-	// cache mode used when override envvar is not set
+	// cache mode used when neither the build nor the override envvar set one
 	defaultCacheMode = "max"
+
+	// name of the dockerfile used when a custom path is not provided
+	defaultDockerfileName = "Dockerfile"
+
+	// frontend attribute that forwards the build to an external dockerfile frontend image
+	syntaxAttr = "build-arg:BUILDKIT_SYNTAX"
+
+	// first dockerfile frontend release that supports named contexts
+	namedContextFrontend = "docker/dockerfile:1.4"
 )
 
 func solveRequestWithContext(sessionID string, image string, cacheImageLayers bool, opts *config.BuildOptions) (*controlapi.SolveRequest, error) {
@@ -31,7 +45,7 @@ func solveRequestWithContext(sessionID string, image string, cacheImageLayers bo
 		Session:  sessionID,
 		Frontend: "dockerfile.v0",
 		FrontendAttrs: map[string]string{
-			"filename": "Dockerfile",
+			"filename": defaultDockerfileName,
 		},
 		Exporter: "image",
 		ExporterAttrs: map[string]string{
@@ -40,42 +54,40 @@ func solveRequestWithContext(sessionID string, image string, cacheImageLayers bo
 		Cache: controlapi.CacheOptions{},
 	}
 
-	imageName, err := reference.ParseNormalizedNamed(image)
-	if err != nil {
-		return nil, err
+	if opts.DockerfilePath != "" {
+		req.FrontendAttrs["filename"] = path.Base(opts.DockerfilePath)
 	}
-	cacheTaggedName, err := reference.WithTag(imageName, cacheTag)
-	if err != nil {
-		return nil, err
-	}
-	cacheTagRef := cacheTaggedName.String()
 
-	if cacheImageLayers {
-		if !opts.DisableLayerCacheExport {
-			cacheMode, err := getExportMode()
-			if err != nil {
-				return nil, err
-			}
+	if len(opts.Platforms) != 0 {
+		req.FrontendAttrs["platform"] = strings.Join(opts.Platforms, ",")
+	}
 
-			req.Cache.Exports = []*controlapi.CacheOptionsEntry{{
-				Type: "registry",
-				Attrs: map[string]string{
-					"mode": cacheMode,
-					"ref":  cacheTagRef,
-				},
-			}}
+	if opts.Target != "" {
+		req.FrontendAttrs["target"] = opts.Target
+	}
 
-			req.FrontendAttrs["cache-from"] = cacheTagRef
+	if len(opts.ExtraHosts) != 0 {
+		var hosts []string
+		for _, entry := range opts.ExtraHosts {
+			parts := strings.SplitN(entry, ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid extra host %q, must use the format host:ip", entry)
+			}
+			hosts = append(hosts, fmt.Sprintf("%s=%s", parts[0], parts[1]))
 		}
+		req.FrontendAttrs["add-hosts"] = strings.Join(hosts, ",")
+	}
 
-		if !opts.DisableBuildCache {
-			req.Cache.Imports = []*controlapi.CacheOptionsEntry{{
-				Type: "registry",
-				Attrs: map[string]string{
-					"ref": cacheTagRef,
-				},
-			}}
-		}
+	switch opts.NetworkMode {
+	case "", "default":
+	case "none":
+		req.FrontendAttrs["force-network-mode"] = "none"
+	default:
+		return nil, fmt.Errorf("unsupported network mode: %s", opts.NetworkMode)
+	}
+
+	if err := addCacheOptions(req, image, cacheImageLayers, opts); err != nil {
+		return nil, err
 	}
 
 	if opts.DisableBuildCache {
@@ -104,17 +116,33 @@ func solveRequestWithContext(sessionID string, image string, cacheImageLayers bo
 	return req, nil
 }
 
-// returns the mode used when pushing cached layers to the registry.
+// adds named context attributes to a solve request. the builtin dockerfile frontend does not support named contexts,
+// so the request is forwarded to one that does unless a frontend image was selected with the BUILDKIT_SYNTAX build arg.
+func addNamedContexts(req *controlapi.SolveRequest, attrs map[string]string) {
+	if len(attrs) == 0 {
+		return
+	}
+
+	for k, v := range attrs {
+		req.FrontendAttrs[k] = v
+	}
+	if _, ok := req.FrontendAttrs[syntaxAttr]; !ok {
+		req.FrontendAttrs[syntaxAttr] = namedContextFrontend
+	}
+}
+
+// returns the default mode used when pushing cached layers to the registry.
 //
 // "min" only pushes the layers for the final image (no intermediate layers for multi-stage builds)
 // "max" pushes all layers into the cache
+// "inline" embeds the cache metadata into the image instead of pushing a separate cache
 func getExportMode() (string, error) {
 	mode := os.Getenv("EMBEDDED_BUILDER_CACHE_MODE")
 
 	switch {
 	case mode == "":
 		return defaultCacheMode, nil
-	case mode != "min" && mode != "max":
+	case mode != "min" && mode != "max" && mode != forgev1alpha1.CacheModeInline:
 		return "", fmt.Errorf("invalid embedded builder cache mode: %s", mode)
 	default:
 		return mode, nil
@@ -193,3 +221,37 @@ func generateRegistryFunc(registries []config.Registry) (bkimage.CredentialsFn,
 
 	return hostCredentials, matchNonSSL
 }
+
+// returns the path of the dockerfile after ensuring it resolves to a regular file inside the context directory. symlinks
+// are resolved within the context so that the returned file can be sent to the frontend as is.
+func resolveDockerfile(contextDir, dockerfilePath string) (string, error) {
+	if dockerfilePath == "" {
+		dockerfilePath = defaultDockerfileName
+	}
+
+	fullPath := filepath.Join(contextDir, filepath.FromSlash(dockerfilePath))
+	if rel, err := filepath.Rel(contextDir, fullPath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("dockerfile %q is outside of the build context", dockerfilePath)
+	}
+
+	resolved, err := util.ResolveInRoot(contextDir, filepath.FromSlash(dockerfilePath))
+	if err != nil {
+		if errors.Is(err, util.ErrOutsideRoot) {
+			return "", fmt.Errorf("dockerfile %q is outside of the build context: %w", dockerfilePath, err)
+		}
+		return "", fmt.Errorf("cannot resolve dockerfile %q in build context: %w", dockerfilePath, err)
+	}
+
+	fi, err := os.Lstat(resolved)
+	if err != nil {
+		return "", fmt.Errorf("cannot find dockerfile %q in build context: %w", dockerfilePath, err)
+	}
+	if fi.IsDir() {
+		return "", fmt.Errorf("dockerfile %q is a directory", dockerfilePath)
+	}
+	if !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("dockerfile %q is not a regular file", dockerfilePath)
+	}
+
+	return resolved, nil
+}
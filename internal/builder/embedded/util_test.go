@@ -2,7 +2,12 @@ package embedded
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+
+	"github.com/dominodatalab/forge/internal/config"
 )
 
 func TestDriver_getExportMode(t *testing.T) {
@@ -44,3 +49,136 @@ func TestDriver_getExportMode(t *testing.T) {
 		}
 	})
 }
+
+func TestSolveRequestWithContext_frontendOptions(t *testing.T) {
+	opts := &config.BuildOptions{
+		DockerfilePath: "services/api/Dockerfile.prod",
+		Target:         "runtime",
+		ExtraHosts:     []string{"db:10.0.0.1", "ipv6:::1"},
+		NetworkMode:    "none",
+		Platforms:      []string{"linux/amd64", "linux/arm64"},
+	}
+
+	req, err := solveRequestWithContext("session", "registry.io/org/app", false, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"filename":           "Dockerfile.prod",
+		"target":             "runtime",
+		"add-hosts":          "db=10.0.0.1,ipv6=::1",
+		"force-network-mode": "none",
+		"platform":           "linux/amd64,linux/arm64",
+	}
+	for k, v := range expected {
+		if actual := req.FrontendAttrs[k]; actual != v {
+			t.Errorf("expected frontend attr %s to be %q, got %q", k, v, actual)
+		}
+	}
+
+	if _, err := solveRequestWithContext("session", "registry.io/org/app", false, &config.BuildOptions{NetworkMode: "host"}); err == nil {
+		t.Error("expected err for unsupported network mode, got none")
+	}
+}
+
+func TestResolveDockerfile(t *testing.T) {
+	parent := t.TempDir()
+	contextDir := filepath.Join(parent, "context")
+	nested := filepath.Join(contextDir, "services", "api")
+	dotted := filepath.Join(contextDir, "..dir")
+	for _, dir := range []string{nested, dotted} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := []string{
+		filepath.Join(contextDir, "Dockerfile"),
+		filepath.Join(contextDir, "..Dockerfile"),
+		filepath.Join(dotted, "Dockerfile"),
+		filepath.Join(nested, "Dockerfile.prod"),
+		filepath.Join(parent, "secret"),
+	}
+	for _, fp := range files {
+		if err := os.WriteFile(fp, []byte("FROM scratch"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"Dockerfile.link":    "services/api/Dockerfile.prod",
+		"Dockerfile.outside": "../secret",
+		"Dockerfile.abs":     filepath.Join(parent, "secret"),
+		"self":               ".",
+		"escape":             "self/..",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(contextDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+		valid    bool
+	}{
+		{"", filepath.Join(contextDir, "Dockerfile"), true},
+		{"services/api/Dockerfile.prod", filepath.Join(nested, "Dockerfile.prod"), true},
+		{"..Dockerfile", filepath.Join(contextDir, "..Dockerfile"), true},
+		{"..dir/Dockerfile", filepath.Join(dotted, "Dockerfile"), true},
+		{"Dockerfile.link", filepath.Join(nested, "Dockerfile.prod"), true},
+		{"services/api", "", false},
+		{"missing/Dockerfile", "", false},
+		{"../Dockerfile", "", false},
+		{"Dockerfile.outside", "", false},
+		{"Dockerfile.abs", "", false},
+		{"escape/secret", "", false},
+	}
+	for _, tc := range tests {
+		actual, err := resolveDockerfile(contextDir, tc.path)
+		if tc.valid && err != nil {
+			t.Errorf("path %q: unexpected error: %v", tc.path, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("path %q: expected err, got none", tc.path)
+		}
+		if actual != tc.expected {
+			t.Errorf("path %q: expected dockerfile %q, got %q", tc.path, tc.expected, actual)
+		}
+	}
+}
+
+func TestAddNamedContexts(t *testing.T) {
+	req := &controlapi.SolveRequest{FrontendAttrs: map[string]string{}}
+	addNamedContexts(req, nil)
+	if len(req.FrontendAttrs) != 0 {
+		t.Errorf("expected no frontend attrs without named contexts, got %v", req.FrontendAttrs)
+	}
+
+	addNamedContexts(req, map[string]string{"context:shared": "local:named-context-shared"})
+	if req.FrontendAttrs["context:shared"] != "local:named-context-shared" {
+		t.Errorf("expected named context attr, got %v", req.FrontendAttrs)
+	}
+	if req.FrontendAttrs[syntaxAttr] != namedContextFrontend {
+		t.Errorf("expected named context frontend, got %q", req.FrontendAttrs[syntaxAttr])
+	}
+
+	req = &controlapi.SolveRequest{FrontendAttrs: map[string]string{syntaxAttr: "docker/dockerfile:1.5"}}
+	addNamedContexts(req, map[string]string{"context:shared": "docker-image://alpine"})
+	if req.FrontendAttrs[syntaxAttr] != "docker/dockerfile:1.5" {
+		t.Errorf("expected explicit frontend to be retained, got %q", req.FrontendAttrs[syntaxAttr])
+	}
+}
+
+func TestSolveRequestWithContext_buildOnly(t *testing.T) {
+	req, err := solveRequestWithContext("session", "registry.io/org/app", true, &config.BuildOptions{BuildOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Cache.Exports) != 0 {
+		t.Errorf("expected build only requests not to export layer caches, got %v", req.Cache.Exports)
+	}
+	if len(req.Cache.Imports) != 1 {
+		t.Errorf("expected build only requests to import layer caches, got %v", req.Cache.Imports)
+	}
+}
@@ -0,0 +1,354 @@
+// Package remote delegates image builds to an external buildkit daemon.
+package remote
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/docker/distribution/reference"
+	"github.com/go-logr/logr"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	bkclient "github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+	"github.com/moby/buildkit/util/contentutil"
+	"github.com/moby/buildkit/util/progress/progressui"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/dominodatalab/forge/internal/builder/embedded"
+	"github.com/dominodatalab/forge/internal/builder/embedded/bkimage"
+	"github.com/dominodatalab/forge/internal/config"
+)
+
+var _ embedded.Buildkit = &Buildkit{}
+
+// Buildkit delegates builds to an external buildkit daemon. The daemon pushes built images to every push repository by
+// digest, so that their size is validated using the pushed manifests before any tag is pushed. Tags only add manifests
+// to repositories that already contain the image, layers never pass through the builder. Oversized images are left
+// untagged in the registry.
+type Buildkit struct {
+	client *bkclient.Client
+	logger logr.Logger
+
+	registryHosts   docker.RegistryHosts
+	hostCredentials bkimage.CredentialsFn
+
+	// images produced by the current build, keyed by name
+	images map[string]remoteImage
+	// repositories the daemon pushed the current build to
+	pushed map[string]bool
+}
+
+type remoteImage struct {
+	// digested reference of the image in the registry it was pushed to, empty when it was only built
+	ref  string
+	desc ocispec.Descriptor
+}
+
+// New creates a client for the daemon at the configured address.
+func New(opts *config.RemoteBuildkitOptions, logger logr.Logger) (*Buildkit, error) {
+	if opts == nil || opts.Address == "" {
+		return nil, errors.New("remote buildkit daemon address is required")
+	}
+
+	var clientOpts []bkclient.ClientOpt
+	if opts.CACert != "" {
+		clientOpts = append(clientOpts, bkclient.WithCredentials(opts.ServerName, opts.CACert, opts.Cert, opts.Key))
+	} else if opts.Cert != "" || opts.Key != "" {
+		return nil, errors.New("remote buildkit client certificates require a CA certificate")
+	}
+
+	// the connection is established lazily, so an unavailable daemon only fails the builds using it
+	client, err := bkclient.New(context.Background(), opts.Address, clientOpts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create client for buildkit daemon %q", opts.Address)
+	}
+
+	r := &Buildkit{client: client, logger: logger}
+	r.ResetHostConfigurations()
+
+	return r, nil
+}
+
+func (r *Buildkit) SetLogger(logger logr.Logger) {
+	r.logger = logger
+}
+
+func (r *Buildkit) ConfigureHosts(hostCredentials bkimage.CredentialsFn, matchNonSSL bkimage.TLSEnabledFn) {
+	r.hostCredentials = hostCredentials
+	r.registryHosts = bkimage.NewRegistryHosts(hostCredentials, matchNonSSL)
+}
+
+func (r *Buildkit) ResetHostConfigurations() {
+	r.registryHosts = docker.ConfigureDefaultRegistries()
+	r.hostCredentials = func(string) (string, string, error) {
+		return "", "", nil
+	}
+}
+
+func (r *Buildkit) RegistryHosts() docker.RegistryHosts {
+	return r.registryHosts
+}
+
+// ValidatePlatforms checks the requested platforms against the platforms of every worker of the daemon.
+func (r *Buildkit) ValidatePlatforms(ctx context.Context, requested []string) error {
+	if len(requested) == 0 {
+		return nil
+	}
+
+	workers, err := r.client.ListWorkers(ctx)
+	if err != nil {
+		return errors.Wrap(err, "cannot list remote buildkit workers")
+	}
+
+	var supported []ocispec.Platform
+	for _, w := range workers {
+		supported = append(supported, w.Platforms...)
+	}
+	return bkimage.CheckPlatforms(supported, requested)
+}
+
+func (r *Buildkit) Build(ctx context.Context, req *controlapi.SolveRequest, localDirs map[string]string, opts *config.BuildOptions) error {
+	if opts.ShmSize > 0 {
+		return errors.New("custom shm sizes are not supported by remote buildkit daemons")
+	}
+	r.images = map[string]remoteImage{}
+	r.pushed = map[string]bool{}
+
+	name := req.ExporterAttrs["name"]
+	exporterAttrs := map[string]string{}
+	for k, v := range req.ExporterAttrs {
+		exporterAttrs[k] = v
+	}
+	if !opts.BuildOnly {
+		// tags are pushed once the image size has been validated
+		repos, err := pushRepositories(name, opts)
+		if err != nil {
+			return err
+		}
+		for _, repo := range repos {
+			r.pushed[repo] = true
+		}
+		exporterAttrs["name"] = strings.Join(repos, ",")
+		exporterAttrs["push"] = "true"
+		exporterAttrs["push-by-digest"] = "true"
+	}
+
+	attachables := []session.Attachable{
+		bkimage.NewDynamicAuthProvider(r.hostCredentials),
+		secretsprovider.FromMap(opts.Secrets),
+	}
+	agents, err := bkimage.NewKeyringAgents(opts.SSHKeys)
+	if err != nil {
+		return err
+	}
+	if len(agents) != 0 {
+		attachables = append(attachables, bkimage.NewKeyringSSHProvider(agents))
+	}
+
+	cacheImports, err := r.cacheImports(req.Cache.Imports)
+	if err != nil {
+		return err
+	}
+
+	solveOpt := bkclient.SolveOpt{
+		Exports:       []bkclient.ExportEntry{{Type: req.Exporter, Attrs: exporterAttrs}},
+		LocalDirs:     localDirs,
+		Frontend:      req.Frontend,
+		FrontendAttrs: req.FrontendAttrs,
+		CacheExports:  cacheEntries(req.Cache.Exports),
+		CacheImports:  cacheImports,
+		Session:       attachables,
+	}
+
+	var resp *bkclient.SolveResponse
+	ch := make(chan *bkclient.SolveStatus)
+	eg, solveCtx := errgroup.WithContext(ctx)
+	eg.Go(func() (err error) {
+		resp, err = r.client.Solve(solveCtx, nil, solveOpt, ch)
+		return err
+	})
+	eg.Go(func() error {
+		return progressui.DisplaySolveStatus(context.TODO(), "", nil, &bkimage.LogrWriter{Logger: r.logger}, ch)
+	})
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	// the client records exported caches under the "latest" tag only
+	if err := bkimage.UpdateLocalCacheIndexes(bkimage.LocalCacheIndexes(req.Cache.Exports), resp.ExporterResponse); err != nil {
+		return err
+	}
+
+	dgst, err := digest.Parse(resp.ExporterResponse[exptypes.ExporterImageDigestKey])
+	if err != nil {
+		return errors.Wrap(err, "remote buildkit daemon did not report the image digest")
+	}
+	if opts.BuildOnly {
+		r.images[name] = remoteImage{desc: ocispec.Descriptor{Digest: dgst}}
+		return nil
+	}
+
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return errors.Wrapf(err, "parsing image name %q failed", name)
+	}
+	digested, err := reference.WithDigest(reference.TrimNamed(named), dgst)
+	if err != nil {
+		return err
+	}
+
+	resolver := docker.NewResolver(docker.ResolverOptions{Hosts: r.registryHosts})
+	_, desc, err := resolver.Resolve(ctx, digested.String())
+	if err != nil {
+		return errors.Wrapf(err, "resolving pushed image %q failed", digested)
+	}
+	r.images[name] = remoteImage{ref: digested.String(), desc: desc}
+
+	return nil
+}
+
+// returns the repository of the built image followed by the repository of the image in every other push registry
+func pushRepositories(name string, opts *config.BuildOptions) ([]string, error) {
+	names := []string{name}
+	for _, registry := range opts.PushRegistries {
+		names = append(names, fmt.Sprintf("%s/%s", registry, opts.ImageName))
+	}
+
+	var repos []string
+	seen := map[string]bool{}
+	for _, n := range names {
+		named, err := reference.ParseNormalizedNamed(n)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing image name %q failed", n)
+		}
+		if repo := named.Name(); !seen[repo] {
+			seen[repo] = true
+			repos = append(repos, repo)
+		}
+	}
+	return repos, nil
+}
+
+// drops local cache imports that do not exist yet, the client fails builds importing them
+func (r *Buildkit) cacheImports(imports []*controlapi.CacheOptionsEntry) ([]bkclient.CacheOptionsEntry, error) {
+	var entries []bkclient.CacheOptionsEntry
+	for _, entry := range cacheEntries(imports) {
+		if entry.Type == "local" {
+			dgst, err := bkimage.LocalCacheImportDigest(entry.Attrs)
+			if err != nil {
+				return nil, err
+			}
+			if dgst == "" {
+				r.logger.Info("Local layer cache not found, skipping import", "dir", entry.Attrs["src"])
+				continue
+			}
+			entry.Attrs["digest"] = dgst
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func cacheEntries(entries []*controlapi.CacheOptionsEntry) []bkclient.CacheOptionsEntry {
+	var result []bkclient.CacheOptionsEntry
+	for _, entry := range entries {
+		attrs := map[string]string{}
+		for k, v := range entry.Attrs {
+			attrs[k] = v
+		}
+		result = append(result, bkclient.CacheOptionsEntry{Type: entry.Type, Attrs: attrs})
+	}
+	return result
+}
+
+// GetImage reads the platform images of a pushed image from its registry. only the digest of images that were not
+// pushed is known, so their size cannot be validated.
+func (r *Buildkit) GetImage(ctx context.Context, name string) (*bkimage.ListedImage, error) {
+	img, err := r.image(name)
+	if err != nil {
+		return nil, err
+	}
+	target := images.Image{Name: name, Target: img.desc}
+
+	if img.ref == "" {
+		r.logger.Info("Image was not pushed, skipping size validation", "image", name)
+		return &bkimage.ListedImage{Image: target}, nil
+	}
+
+	resolver := docker.NewResolver(docker.ResolverOptions{Hosts: r.registryHosts})
+	fetcher, err := resolver.Fetcher(ctx, img.ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot fetch image %q", img.ref)
+	}
+	return bkimage.ListImage(ctx, contentutil.FromFetcher(fetcher), target)
+}
+
+func (r *Buildkit) TagImage(_ context.Context, src, dest string) error {
+	img, err := r.image(src)
+	if err != nil {
+		return err
+	}
+	r.images[dest] = img
+	return nil
+}
+
+// PushImage tags a pushed image in the repository of the given name. The daemon already pushed the image to the
+// repository by digest, so only the manifests are copied.
+func (r *Buildkit) PushImage(ctx context.Context, name string) error {
+	img, err := r.image(name)
+	if err != nil {
+		return err
+	}
+	if img.ref == "" {
+		return fmt.Errorf("image %q was not pushed by the remote buildkit daemon", name)
+	}
+
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return errors.Wrapf(err, "parsing image name %q failed", name)
+	}
+	if !r.pushed[named.Name()] {
+		return fmt.Errorf("remote buildkit daemon did not push image %q to repository %s", name, named.Name())
+	}
+	src, err := reference.WithDigest(reference.TrimNamed(named), img.desc.Digest)
+	if err != nil {
+		return err
+	}
+
+	resolver := docker.NewResolver(docker.ResolverOptions{Hosts: r.registryHosts})
+	fetcher, err := resolver.Fetcher(ctx, src.String())
+	if err != nil {
+		return errors.Wrapf(err, "cannot fetch image %q", src)
+	}
+	pusher, err := resolver.Pusher(ctx, name)
+	if err != nil {
+		return errors.Wrapf(err, "cannot push image %q", name)
+	}
+
+	return contentutil.CopyChain(ctx, contentutil.FromPusher(pusher), contentutil.FromFetcher(fetcher), img.desc)
+}
+
+func (r *Buildkit) ResolveImage(ctx context.Context, name string) (digest.Digest, error) {
+	return bkimage.ResolveImage(ctx, r.registryHosts, name)
+}
+
+// Prune is a no-op, the daemon garbage collects its own state.
+func (r *Buildkit) Prune(context.Context) error {
+	return nil
+}
+
+func (r *Buildkit) image(name string) (remoteImage, error) {
+	img, ok := r.images[name]
+	if !ok {
+		return remoteImage{}, fmt.Errorf("image %q was not built by the remote buildkit daemon", name)
+	}
+	return img, nil
+}
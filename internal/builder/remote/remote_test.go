@@ -0,0 +1,206 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes/docker"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/dominodatalab/forge/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	_, err := New(&config.RemoteBuildkitOptions{}, log.NullLogger{})
+	assert.EqualError(t, err, "remote buildkit daemon address is required")
+
+	_, err = New(&config.RemoteBuildkitOptions{Address: "tcp://buildkitd:1234", Cert: "cert.pem", Key: "key.pem"}, log.NullLogger{})
+	assert.EqualError(t, err, "remote buildkit client certificates require a CA certificate")
+
+	_, err = New(&config.RemoteBuildkitOptions{Address: "tcp://buildkitd:1234", CACert: filepath.Join(t.TempDir(), "missing.pem")}, log.NullLogger{})
+	assert.Error(t, err)
+
+	r, err := New(&config.RemoteBuildkitOptions{Address: "unix:///run/buildkit/buildkitd.sock"}, log.NullLogger{})
+	require.NoError(t, err)
+	assert.NotNil(t, r.RegistryHosts())
+}
+
+func TestBuildkit_images(t *testing.T) {
+	dgst := digest.FromString("image")
+	r := &Buildkit{
+		logger: log.NullLogger{},
+		images: map[string]remoteImage{
+			"docker.io/library/app:latest": {desc: ocispec.Descriptor{Digest: dgst}},
+		},
+	}
+	ctx := context.Background()
+
+	require.NoError(t, r.TagImage(ctx, "docker.io/library/app:latest", "docker.io/library/app:v1"))
+	assert.EqualError(t, r.TagImage(ctx, "docker.io/library/other:latest", "docker.io/library/app:v2"), `image "docker.io/library/other:latest" was not built by the remote buildkit daemon`)
+
+	// images that were only built report their digest without a size
+	listed, err := r.GetImage(ctx, "docker.io/library/app:v1")
+	require.NoError(t, err)
+	assert.Equal(t, dgst, listed.Target.Digest)
+	assert.Empty(t, listed.Platforms)
+
+	assert.EqualError(t, r.PushImage(ctx, "docker.io/library/app:v1"), `image "docker.io/library/app:v1" was not pushed by the remote buildkit daemon`)
+}
+
+// serves images the daemon pushed by digest and records the tags and blobs pushed afterwards
+type testRegistry struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+}
+
+func (reg *testRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// manifests are fetched while they are pushed, bodies are read before locking
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if r.URL.Path == "/v2/" {
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/blobs/uploads/") {
+		reg.uploads++
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var bs []byte
+	var ok bool
+	switch repo, ref := path.Split(strings.TrimPrefix(r.URL.Path, "/v2/")); {
+	case strings.HasSuffix(repo, "/manifests/") && r.Method == http.MethodPut:
+		reg.manifests[repo+ref] = body
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(body).String())
+		w.WriteHeader(http.StatusCreated)
+		return
+	case strings.HasSuffix(repo, "/manifests/"):
+		bs, ok = reg.manifests[repo+ref]
+		w.Header().Set("Content-Type", images.MediaTypeDockerSchema2Manifest)
+	case strings.HasSuffix(repo, "/blobs/"):
+		bs, ok = reg.blobs[ref]
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Docker-Content-Digest", digest.FromBytes(bs).String())
+	w.Header().Set("Content-Length", fmt.Sprint(len(bs)))
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(bs)
+	}
+}
+
+func TestBuildkit_pushedImages(t *testing.T) {
+	cfg := []byte(`{"architecture":"amd64","os":"linux"}`)
+	layer := bytes.Repeat([]byte("layer"), 100)
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Config:    ocispec.Descriptor{MediaType: images.MediaTypeDockerSchema2Config, Digest: digest.FromBytes(cfg), Size: int64(len(cfg))},
+		Layers:    []ocispec.Descriptor{{MediaType: images.MediaTypeDockerSchema2LayerGzip, Digest: digest.FromBytes(layer), Size: int64(len(layer))}},
+	})
+	require.NoError(t, err)
+	dgst := digest.FromBytes(manifest)
+
+	reg := &testRegistry{
+		blobs:     map[string][]byte{digest.FromBytes(cfg).String(): cfg, digest.FromBytes(layer).String(): layer},
+		manifests: map[string][]byte{"team/app/manifests/" + dgst.String(): manifest},
+	}
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	desc := ocispec.Descriptor{MediaType: images.MediaTypeDockerSchema2Manifest, Digest: dgst, Size: int64(len(manifest))}
+	r := &Buildkit{
+		logger:        log.NullLogger{},
+		registryHosts: docker.ConfigureDefaultRegistries(docker.WithPlainHTTP(docker.MatchAllHosts)),
+		images:        map[string]remoteImage{host + "/team/app:latest": {ref: host + "/team/app@" + dgst.String(), desc: desc}},
+		pushed:        map[string]bool{host + "/team/app": true},
+	}
+	ctx := context.Background()
+
+	// sizes are read from the manifests the daemon pushed
+	listed, err := r.GetImage(ctx, host+"/team/app:latest")
+	require.NoError(t, err)
+	require.Len(t, listed.Platforms, 1)
+	assert.Equal(t, dgst, listed.Platforms[0].Digest)
+	assert.Equal(t, int64(len(manifest)+len(cfg)+len(layer)), listed.ContentSize)
+
+	// tags only add manifests to repositories the daemon pushed to
+	require.NoError(t, r.TagImage(ctx, host+"/team/app:latest", host+"/team/app:v1"))
+	require.NoError(t, r.PushImage(ctx, host+"/team/app:v1"))
+	assert.Equal(t, manifest, reg.manifests["team/app/manifests/v1"])
+	assert.Zero(t, reg.uploads)
+
+	require.NoError(t, r.TagImage(ctx, host+"/team/app:latest", host+"/other/app:v1"))
+	assert.EqualError(t, r.PushImage(ctx, host+"/other/app:v1"), fmt.Sprintf("remote buildkit daemon did not push image %q to repository %s", host+"/other/app:v1", host+"/other/app"))
+	assert.Zero(t, reg.uploads)
+}
+
+func TestPushRepositories(t *testing.T) {
+	repos, err := pushRepositories("registry-a.io/team/app:latest", &config.BuildOptions{
+		ImageName:      "team/app:v1",
+		PushRegistries: []string{"registry-a.io", "registry-b.io:5000"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"registry-a.io/team/app", "registry-b.io:5000/team/app"}, repos)
+
+	_, err = pushRepositories("registry-a.io/team/app:latest", &config.BuildOptions{ImageName: "Team/App", PushRegistries: []string{"registry-a.io"}})
+	assert.Error(t, err)
+}
+
+func TestBuildkit_cacheImports(t *testing.T) {
+	dir := t.TempDir()
+	index := `{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.index.v1+json","digest":"sha256:4d4d4f1a4bcfa40ab4d9d4b8c2f0d1e4b0b0c1f3f8a2a5d3e6b2a7c9e1f0d2c4","size":1,"annotations":{"org.opencontainers.image.ref.name":"main"}}]}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.json"), []byte(index), 0644))
+
+	r := &Buildkit{logger: log.NullLogger{}}
+	entries, err := r.cacheImports([]*controlapi.CacheOptionsEntry{
+		{Type: "registry", Attrs: map[string]string{"ref": "registry.io/cache:main"}},
+		{Type: "local", Attrs: map[string]string{"src": dir, "tag": "main"}},
+		{Type: "local", Attrs: map[string]string{"src": dir, "tag": "feature"}},
+		{Type: "local", Attrs: map[string]string{"src": filepath.Join(dir, "missing")}},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, entries, 2)
+	assert.Equal(t, "registry", entries[0].Type)
+	assert.Equal(t, "sha256:4d4d4f1a4bcfa40ab4d9d4b8c2f0d1e4b0b0c1f3f8a2a5d3e6b2a7c9e1f0d2c4", entries[1].Attrs["digest"])
+
+	_, err = r.cacheImports([]*controlapi.CacheOptionsEntry{{Type: "local", Attrs: map[string]string{}}})
+	assert.EqualError(t, err, "local cache imports require a source")
+}
+
+func TestBuildkit_BuildShmSize(t *testing.T) {
+	r := &Buildkit{logger: log.NullLogger{}}
+	err := r.Build(context.Background(), &controlapi.SolveRequest{}, nil, &config.BuildOptions{ShmSize: 1 << 30})
+	assert.EqualError(t, err, "custom shm sizes are not supported by remote buildkit daemons")
+}
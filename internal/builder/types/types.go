@@ -1,6 +1,73 @@
 package types
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrBuildTimeout is returned when an image build does not finish within its configured timeout.
+var ErrBuildTimeout = errors.New("build timed out")
+
 type Image struct {
-	URLs []string
-	Size uint64
+	URLs          []string
+	Size          uint64
+	Digest        string
+	ConfigDigest  string
+	Platforms     []PlatformImage
+	Pushes        []PushResult
+	GitCommit     string
+	ContextDigest string
+	// AdditionalContexts fetched for the build, sorted by name.
+	AdditionalContexts []AdditionalContext
+	// Skipped is true when every tag already existed and the image was not built.
+	Skipped bool
+}
+
+// AdditionalContext records what a named build context was fetched from.
+type AdditionalContext struct {
+	Name      string
+	Digest    string
+	GitCommit string
+}
+
+type PlatformImage struct {
+	Platform     string
+	Digest       string
+	ConfigDigest string
+	Size         uint64
+}
+
+// PushResult is the outcome of pushing an image to a single registry.
+type PushResult struct {
+	Registry  string
+	URL       string
+	Reference string
+	Error     string
+	// Skipped is true when the tag already existed and was not pushed.
+	Skipped bool
+}
+
+// PushError is returned when a built image could not be pushed to every registry. The image records the outcome of
+// each push that was attempted.
+type PushError struct {
+	Image *Image
+	Err   error
+}
+
+func (e *PushError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PushError) Unwrap() error {
+	return e.Err
+}
+
+// TagExistsError is returned when tags already exist in a registry and the tag policy does not allow overwriting them.
+type TagExistsError struct {
+	Images []string
+}
+
+func (e *TagExistsError) Error() string {
+	return fmt.Sprintf("image tags already exist: %s", strings.Join(e.Images, ", "))
 }
@@ -1,30 +1,48 @@
 package buildjob
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 
 	"github.com/dominodatalab/forge/api/forge/v1alpha1"
+	"github.com/dominodatalab/forge/internal/archive"
 	"github.com/dominodatalab/forge/internal/builder"
+	"github.com/dominodatalab/forge/internal/builder/types"
 	"github.com/dominodatalab/forge/internal/clientset"
 	forgev1alpha1 "github.com/dominodatalab/forge/internal/clientset/typed/forge/v1alpha1"
 
 	"github.com/dominodatalab/forge/internal/config"
 	"github.com/dominodatalab/forge/internal/credentials"
+	"github.com/dominodatalab/forge/internal/git"
 	forgek8s "github.com/dominodatalab/forge/internal/kubernetes"
+	"github.com/dominodatalab/forge/internal/layercache"
 	"github.com/dominodatalab/forge/internal/message"
 	"github.com/dominodatalab/forge/plugins/preparer"
 )
 
+const (
+	// secret key holding ssh known hosts entries used when fetching git contexts
+	knownHostsKey = "known_hosts"
+
+	// secret keys used to authenticate context archive downloads
+	contextTokenKey     = "token"
+	contextCABundleKey  = "ca.crt"
+	contextHeaderPrefix = "header."
+)
+
 type Job struct {
 	log logr.Logger
 
@@ -37,29 +55,25 @@ type Job struct {
 
 	builder builder.OCIImageBuilder
 
+	contextLimits   archive.Limits
+	layerCache      layercache.Options
+	pushConcurrency int
+
 	name      string
 	namespace string
 
+	// set when the job runs inside a long-running worker shared by many builds, rather than a dedicated build pod
+	inWorker bool
+
 	cleanupSteps []func()
 }
 
 func New(cfg Config) (*Job, error) {
 	log := NewLogger()
 
-	// initialize kubernetes clients
-	log.Info("Initializing Kubernetes clients")
-
-	restCfg, err := forgek8s.LoadKubernetesConfig()
+	clientsk8s, clientforge, err := newClients(log)
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot load k8s config")
-	}
-	clientsk8s, err := kubernetes.NewForConfig(restCfg)
-	if err != nil {
-		return nil, errors.Wrap(err, "cannot create k8s api client")
-	}
-	client, err := clientset.NewForConfig(restCfg)
-	if err != nil {
-		return nil, errors.Wrap(err, "cannot create forge api client")
+		return nil, err
 	}
 
 	var cleanupSteps []func()
@@ -78,41 +92,81 @@ func New(cfg Config) (*Job, error) {
 		})
 	}
 
-	// setup preparer plugins
-	log.Info("Loading configured preparer plugins")
-
-	preparerPlugins, err := preparer.LoadPlugins(cfg.PreparerPluginsPath)
+	preparerPlugins, err := loadPlugins(cfg.PreparerPluginsPath, log)
 	if err != nil {
-		return nil, errors.Wrapf(err, "unable to load preparer plugins path %q", cfg.PreparerPluginsPath)
+		return nil, err
 	}
-	cleanupSteps = append(cleanupSteps, func() {
-		log.Info("Killing preparer plugins")
-		for _, preparerPlugin := range preparerPlugins {
-			preparerPlugin.Kill()
-		}
-	})
+	cleanupSteps = append(cleanupSteps, func() { killPlugins(preparerPlugins, log) })
 
 	// instantiate the image builder
 	log.Info("Initializing OCI image builder")
 
-	ociBuilder, err := builder.New(preparerPlugins, cfg.EnableLayerCaching, log)
+	builderOpts := config.BuilderOptions{
+		CacheImageLayers:   cfg.EnableLayerCaching,
+		StateGCKeepStorage: cfg.StateGCKeepStorage,
+	}
+	if cfg.Builder == v1alpha1.BuilderRemote {
+		builderOpts.Remote = &cfg.RemoteBuildkit
+	}
+
+	ociBuilder, err := builder.New(preparerPlugins, builderOpts, log)
 	if err != nil {
 		return nil, errors.Wrap(err, "image builder initialization failed")
 	}
 
 	return &Job{
-		log:          log,
-		name:         cfg.ResourceName,
-		namespace:    cfg.ResourceNamespace,
-		clientk8s:    clientsk8s,
-		clientforge:  client.ForgeV1alpha1(),
-		producer:     producer,
-		plugins:      preparerPlugins,
-		builder:      ociBuilder,
-		cleanupSteps: cleanupSteps,
+		log:             log,
+		name:            cfg.ResourceName,
+		namespace:       cfg.ResourceNamespace,
+		clientk8s:       clientsk8s,
+		clientforge:     clientforge,
+		producer:        producer,
+		plugins:         preparerPlugins,
+		builder:         ociBuilder,
+		contextLimits:   cfg.ContextLimits,
+		layerCache:      cfg.LayerCache,
+		pushConcurrency: cfg.PushConcurrency,
+		cleanupSteps:    cleanupSteps,
 	}, nil
 }
 
+// initializes the kubernetes clients used to read build resources and update their status
+func newClients(log logr.Logger) (kubernetes.Interface, forgev1alpha1.ForgeV1alpha1Interface, error) {
+	log.Info("Initializing Kubernetes clients")
+
+	restCfg, err := forgek8s.LoadKubernetesConfig()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot load k8s config")
+	}
+	clientsk8s, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot create k8s api client")
+	}
+	client, err := clientset.NewForConfig(restCfg)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot create forge api client")
+	}
+
+	return clientsk8s, client.ForgeV1alpha1(), nil
+}
+
+func loadPlugins(path string, log logr.Logger) ([]*preparer.Plugin, error) {
+	log.Info("Loading configured preparer plugins")
+
+	preparerPlugins, err := preparer.LoadPlugins(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load preparer plugins path %q", path)
+	}
+	return preparerPlugins, nil
+}
+
+func killPlugins(preparerPlugins []*preparer.Plugin, log logr.Logger) {
+	log.Info("Killing preparer plugins")
+	for _, preparerPlugin := range preparerPlugins {
+		preparerPlugin.Kill()
+	}
+}
+
 func (j *Job) Run() error {
 	ctx := context.Background()
 
@@ -144,7 +198,11 @@ func (j *Job) Run() error {
 	if err != nil {
 		logError(j.log, err)
 
-		if iErr := j.transitionToFailure(ctx, cib, err); iErr != nil {
+		transition := j.transitionToFailure
+		if errors.Is(err, types.ErrBuildTimeout) {
+			transition = j.transitionToTimedOut
+		}
+		if iErr := transition(ctx, cib, err); iErr != nil {
 			err = errors.Wrap(err, iErr.Error())
 		}
 		return err
@@ -169,16 +227,120 @@ func (j *Job) generateBuildOptions(ctx context.Context, cib *v1alpha1.ContainerI
 		return nil, errors.Wrap(err, "cannot build registry config")
 	}
 
+	if err := cib.Spec.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid build spec")
+	}
+
+	secrets, err := j.buildSecrets(ctx, cib.Namespace, cib.Spec.Secrets)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot load build secrets")
+	}
+
+	contextAuth, err := j.buildContextAuth(ctx, cib.Namespace, cib.Spec.ContextAuth)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot load context credentials")
+	}
+
+	inlineFiles, err := j.buildInlineFiles(ctx, cib.Namespace, &cib.Spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot load inline files")
+	}
+	var dockerfile []byte
+	if cib.Spec.Dockerfile != "" {
+		dockerfile = []byte(cib.Spec.Dockerfile)
+	}
+
+	gitAuth, err := j.buildGitAuth(ctx, cib.Namespace, cib.Spec.GitAuth)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot load git credentials")
+	}
+
+	sshKeys, knownHosts, err := j.buildSSHAgents(ctx, cib.Namespace, cib.Spec.SSH)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot load ssh agent keys")
+	}
+	for id, value := range knownHosts {
+		if secrets == nil {
+			secrets = map[string][]byte{}
+		}
+		secrets[id] = value
+	}
+
+	// volume contexts are mounted into the build pod by the controller
+	contextURL, contextDir := cib.Spec.Context, ""
+	vc, err := v1alpha1.ParseVolumeContext(cib.Spec.Context)
+	if err != nil {
+		return nil, err
+	}
+	if vc != nil && j.inWorker {
+		return nil, errors.New("volume contexts can only be mounted into build jobs")
+	}
+	if vc != nil {
+		contextURL, contextDir = "", config.VolumeContextPath
+		if vc.Kind == v1alpha1.VolumeContextConfigMap {
+			// config map volumes expose keys as symlinks into this directory, which holds the regular files
+			contextDir = filepath.Join(contextDir, "..data")
+		}
+	}
+
+	var shmSize int64
+	if cib.Spec.ShmSize != nil {
+		shmSize = cib.Spec.ShmSize.Value()
+	}
+
+	cache := config.CacheOptions{
+		Backend: j.layerCache.Backend,
+		Local:   j.layerCache.Local,
+		S3:      j.layerCache.S3,
+	}
+	var cacheFrom []string
+	if c := cib.Spec.Cache; c != nil {
+		cache.Enabled = c.Enabled
+		cache.Mode = c.Mode
+		cache.Repository = c.Repository
+		cache.Key = c.Key
+		cache.FallbackKeys = c.FallbackKeys
+		if c.Backend != "" {
+			cache.Backend = c.Backend
+		}
+		cacheFrom = c.ImportRefs
+	}
+
 	opts := &config.BuildOptions{
-		ContextURL:              cib.Spec.Context,
+		ContextURL:              contextURL,
+		ContextDir:              contextDir,
 		ContextTimeout:          time.Duration(cib.Spec.ContextTimeoutSeconds) * time.Second,
+		ContextLimits:           j.contextLimits,
+		ContextDigest:           digest.Digest(cib.Spec.ContextDigest),
+		ContextAuth:             contextAuth,
+		GitAuth:                 gitAuth,
+		DockerfilePath:          cib.Spec.DockerfilePath,
+		Dockerfile:              dockerfile,
+		InlineFiles:             inlineFiles,
+		AdditionalContexts:      cib.Spec.AdditionalContexts,
+		Target:                  cib.Spec.Target,
+		ExtraHosts:              cib.Spec.ExtraHosts,
+		NetworkMode:             cib.Spec.NetworkMode,
+		ShmSize:                 shmSize,
+		Platforms:               cib.Spec.Platforms,
 		ImageName:               cib.Spec.ImageName,
+		Tags:                    cib.Spec.Tags,
+		TagPolicy:               cib.Spec.TagPolicy,
+		BuildName:               cib.Name,
+		BuildNamespace:          cib.Namespace,
 		ImageSizeLimit:          cib.Spec.ImageSizeLimit,
 		Labels:                  cib.Spec.Labels,
 		BuildArgs:               cib.Spec.BuildArgs,
+		Secrets:                 secrets,
+		SSHKeys:                 sshKeys,
 		DisableBuildCache:       cib.Spec.DisableBuildCache,
 		DisableLayerCacheExport: cib.Spec.DisableLayerCacheExport,
+		Cache:                   cache,
+		CacheFrom:               cacheFrom,
 		PushRegistries:          cib.Spec.PushRegistries,
+		BuildOnly:               !cib.Spec.PushEnabled(),
+		PushBestEffort:          cib.Spec.PushFailurePolicy == v1alpha1.PushFailurePolicyBestEffort,
+		PushConcurrency:         j.pushConcurrency,
 		PluginData:              cib.Spec.PluginData,
 		Timeout:                 time.Duration(cib.Spec.TimeoutSeconds) * time.Second,
 		Registries:              registries,
@@ -261,7 +423,7 @@ func (j *Job) buildRegistryConfigs(ctx context.Context, apiRegs []v1alpha1.Regis
 			}
 
 		case apiReg.DynamicCloudCredentials:
-			authConfigs, err := j.getDockerAuthsFromFS()
+			authConfigs, err := j.getDynamicDockerAuths(ctx)
 			if err != nil {
 				return nil, err
 			}
@@ -297,6 +459,26 @@ func (j *Job) buildRegistryConfigs(ctx context.Context, apiRegs []v1alpha1.Regis
 	return registryConfigs, nil
 }
 
+// workers read the credentials generated by the controller directly since they are not mounted into their pods
+func (j *Job) getDynamicDockerAuths(ctx context.Context) (credentials.AuthConfigs, error) {
+	if !j.inWorker {
+		return j.getDockerAuthsFromFS()
+	}
+
+	name := config.DynamicCredentialsSecretName(j.name)
+	secret, err := j.clientk8s.CoreV1().Secrets(j.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot find dynamic cloud credentials secret %q", name)
+	}
+
+	authConfigs, err := credentials.ExtractAuthConfigs(secret.Data[corev1.DockerConfigJsonKey])
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot extract dynamic cloud credentials from provided data")
+	}
+
+	return authConfigs, nil
+}
+
 func (j *Job) getDockerAuthsFromFS() (credentials.AuthConfigs, error) {
 	if _, err := os.Stat(config.DynamicCredentialsFilepath); os.IsNotExist(err) {
 		return nil, errors.Wrap(err, "cannot find dynamic cloud credentials in the filesystem")
@@ -315,6 +497,160 @@ func (j *Job) getDockerAuthsFromFS() (credentials.AuthConfigs, error) {
 	return authConfigs, nil
 }
 
+// loads the values referenced by build secrets so they can be served to the build session from memory
+func (j *Job) buildSecrets(ctx context.Context, namespace string, apiSecrets []v1alpha1.BuildSecret) (map[string][]byte, error) {
+	if len(apiSecrets) == 0 {
+		return nil, nil
+	}
+
+	secrets := map[string][]byte{}
+	for _, apiSecret := range apiSecrets {
+		secret, err := j.clientk8s.CoreV1().Secrets(namespace).Get(ctx, apiSecret.SecretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch secret %q for build secret %q", apiSecret.SecretName, apiSecret.ID)
+		}
+
+		value, ok := secret.Data[apiSecret.Key]
+		if !ok {
+			return nil, fmt.Errorf("secret %q does not contain key %q for build secret %q", apiSecret.SecretName, apiSecret.Key, apiSecret.ID)
+		}
+
+		j.log.Info("configured build secret", "ID", apiSecret.ID, "Source", fmt.Sprintf("key (%s) in secret (%s)", apiSecret.Key, apiSecret.SecretName))
+		secrets[apiSecret.ID] = value
+	}
+
+	return secrets, nil
+}
+
+// merges files from the referenced config map with inline files, the latter take precedence
+func (j *Job) buildInlineFiles(ctx context.Context, namespace string, spec *v1alpha1.ContainerImageBuildSpec) (map[string][]byte, error) {
+	files := map[string][]byte{}
+
+	if spec.FilesConfigMap != "" {
+		cm, err := j.clientk8s.CoreV1().ConfigMaps(namespace).Get(ctx, spec.FilesConfigMap, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch files config map %q", spec.FilesConfigMap)
+		}
+
+		for name, contents := range cm.BinaryData {
+			files[name] = contents
+		}
+		for name, contents := range cm.Data {
+			files[name] = []byte(contents)
+		}
+	}
+
+	for name, contents := range spec.Files {
+		files[name] = []byte(contents)
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+	return files, nil
+}
+
+// loads the credentials and CA bundle used to download remote build context archives
+func (j *Job) buildContextAuth(ctx context.Context, namespace string, apiAuth *v1alpha1.ContextAuth) (*archive.Auth, error) {
+	if apiAuth == nil {
+		return nil, nil
+	}
+
+	secret, err := j.clientk8s.CoreV1().Secrets(namespace).Get(ctx, apiAuth.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch context auth secret %q", apiAuth.SecretName)
+	}
+
+	auth := &archive.Auth{
+		BearerToken: string(secret.Data[contextTokenKey]),
+		Username:    string(secret.Data[corev1.BasicAuthUsernameKey]),
+		Password:    string(secret.Data[corev1.BasicAuthPasswordKey]),
+		CABundle:    secret.Data[contextCABundleKey],
+	}
+	for key, value := range secret.Data {
+		if name := strings.TrimPrefix(key, contextHeaderPrefix); name != key && name != "" {
+			if auth.Headers == nil {
+				auth.Headers = map[string]string{}
+			}
+			auth.Headers[name] = string(value)
+		}
+	}
+
+	if auth.BearerToken != "" && auth.Password != "" {
+		return nil, fmt.Errorf("context auth secret %q cannot contain both %q and %q", apiAuth.SecretName, contextTokenKey, corev1.BasicAuthPasswordKey)
+	}
+	if auth.BearerToken == "" && auth.Password == "" && len(auth.Headers) == 0 && len(auth.CABundle) == 0 {
+		return nil, fmt.Errorf("context auth secret %q does not contain any credentials", apiAuth.SecretName)
+	}
+
+	j.log.Info("configured context credentials", "Source", fmt.Sprintf("secret (%s)", apiAuth.SecretName))
+	return auth, nil
+}
+
+// loads the credentials used to fetch git repository build contexts
+func (j *Job) buildGitAuth(ctx context.Context, namespace string, apiAuth *v1alpha1.GitAuth) (*git.Auth, error) {
+	if apiAuth == nil {
+		return nil, nil
+	}
+
+	secret, err := j.clientk8s.CoreV1().Secrets(namespace).Get(ctx, apiAuth.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch git auth secret %q", apiAuth.SecretName)
+	}
+
+	auth := &git.Auth{
+		Username:      string(secret.Data[corev1.BasicAuthUsernameKey]),
+		Password:      string(secret.Data[corev1.BasicAuthPasswordKey]),
+		SSHPrivateKey: secret.Data[corev1.SSHAuthPrivateKey],
+		SSHKnownHosts: secret.Data[knownHostsKey],
+	}
+	if auth.Password == "" && len(auth.SSHPrivateKey) == 0 {
+		return nil, fmt.Errorf("git auth secret %q must contain either %q or %q", apiAuth.SecretName, corev1.BasicAuthPasswordKey, corev1.SSHAuthPrivateKey)
+	}
+
+	j.log.Info("configured git credentials", "Source", fmt.Sprintf("secret (%s)", apiAuth.SecretName))
+	return auth, nil
+}
+
+// loads private keys grouped by ssh agent id along with known hosts entries keyed by their build secret id
+func (j *Job) buildSSHAgents(ctx context.Context, namespace string, agents []v1alpha1.SSHAgent) (map[string][][]byte, map[string][]byte, error) {
+	if len(agents) == 0 {
+		return nil, nil, nil
+	}
+
+	keys := map[string][][]byte{}
+	knownHosts := map[string][]byte{}
+	for _, agent := range agents {
+		secret, err := j.clientk8s.CoreV1().Secrets(namespace).Get(ctx, agent.SecretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to fetch secret %q for ssh agent %q", agent.SecretName, agent.AgentID())
+		}
+
+		key, ok := secret.Data[agent.PrivateKey()]
+		if !ok {
+			return nil, nil, fmt.Errorf("secret %q does not contain key %q for ssh agent %q", agent.SecretName, agent.PrivateKey(), agent.AgentID())
+		}
+		keys[agent.AgentID()] = append(keys[agent.AgentID()], key)
+
+		if agent.KnownHostsKey != "" {
+			hosts, ok := secret.Data[agent.KnownHostsKey]
+			if !ok {
+				return nil, nil, fmt.Errorf("secret %q does not contain key %q for ssh agent %q", agent.SecretName, agent.KnownHostsKey, agent.AgentID())
+			}
+
+			id := agent.KnownHostsSecretID()
+			if existing := knownHosts[id]; len(existing) != 0 && !bytes.HasSuffix(existing, []byte("\n")) {
+				knownHosts[id] = append(existing, '\n')
+			}
+			knownHosts[id] = append(knownHosts[id], hosts...)
+		}
+
+		j.log.Info("configured ssh agent", "ID", agent.AgentID(), "Source", fmt.Sprintf("secret (%s)", agent.SecretName))
+	}
+
+	return keys, knownHosts, nil
+}
+
 func (j *Job) getDockerAuthsFromSecret(ctx context.Context, secretName string, secretNamespace string) (credentials.AuthConfigs, error) {
 	secret, err := j.clientk8s.CoreV1().Secrets(secretNamespace).Get(ctx, secretName, metav1.GetOptions{})
 	if err != nil {
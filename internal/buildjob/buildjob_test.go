@@ -6,13 +6,149 @@ import (
 	"testing"
 
 	"github.com/dominodatalab/forge/api/forge/v1alpha1"
+	"github.com/dominodatalab/forge/internal/archive"
+	"github.com/dominodatalab/forge/internal/builder/types"
+	testForgeClient "github.com/dominodatalab/forge/internal/clientset/fake"
 	"github.com/dominodatalab/forge/internal/config"
+	"github.com/go-logr/logr"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	testK8sClient "k8s.io/client-go/kubernetes/fake"
 )
 
+type fakeBuilder struct {
+	image *types.Image
+	err   error
+}
+
+func (b *fakeBuilder) SetLogger(logr.Logger) {}
+
+func (b *fakeBuilder) BuildAndPush(context.Context, *config.BuildOptions) (*types.Image, error) {
+	return b.image, b.err
+}
+
+func TestRun(t *testing.T) {
+	testCases := []struct {
+		name   string
+		err    error
+		state  v1alpha1.BuildState
+		reason string
+	}{
+		{"completed", nil, v1alpha1.BuildStateCompleted, ""},
+		{"failed", fmt.Errorf("boom"), v1alpha1.BuildStateFailed, ""},
+		{"timed_out", fmt.Errorf("%w after 1s: boom", types.ErrBuildTimeout), v1alpha1.BuildStateTimedOut, v1alpha1.BuildReasonDeadlineExceeded},
+		{"digest_mismatch", fmt.Errorf("fetching context: %w", &archive.DigestMismatchError{Expected: "sha256:aa", Actual: "sha256:bb"}), v1alpha1.BuildStateFailed, v1alpha1.BuildReasonContextDigestMismatch},
+		{"push_failed", &types.PushError{Image: &types.Image{}, Err: fmt.Errorf("boom")}, v1alpha1.BuildStateFailed, v1alpha1.BuildReasonPushFailed},
+		{"tag_exists", &types.TagExistsError{Images: []string{"registry.io/app:v1"}}, v1alpha1.BuildStateFailed, v1alpha1.BuildReasonTagExists},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cib := &v1alpha1.ContainerImageBuild{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cib", Namespace: "test-ns"},
+				Spec:       v1alpha1.ContainerImageBuildSpec{Context: "https://example.com/context.tgz", PushRegistries: []string{"registry.io"}},
+			}
+			client := testForgeClient.NewSimpleClientset()
+			_, err := client.ForgeV1alpha1().ContainerImageBuilds(cib.Namespace).Create(context.Background(), cib, metav1.CreateOptions{})
+			require.NoError(t, err)
+
+			job := &Job{
+				log:         NewLogger(),
+				name:        cib.Name,
+				namespace:   cib.Namespace,
+				clientforge: client.ForgeV1alpha1(),
+				builder:     &fakeBuilder{image: &types.Image{}, err: tc.err},
+			}
+
+			err = job.Run()
+			if tc.err != nil {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			actual, err := client.ForgeV1alpha1().ContainerImageBuilds(cib.Namespace).Get(context.Background(), cib.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.state, actual.Status.State)
+			assert.Equal(t, tc.reason, actual.Status.Reason)
+			assert.NotNil(t, actual.Status.BuildCompletedAt)
+		})
+	}
+}
+
+func TestRunPartiallyPushed(t *testing.T) {
+	cib := &v1alpha1.ContainerImageBuild{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cib", Namespace: "test-ns"},
+		Spec: v1alpha1.ContainerImageBuildSpec{
+			Context:           "https://example.com/context.tgz",
+			PushRegistries:    []string{"registry-a.io", "registry-b.io"},
+			PushFailurePolicy: v1alpha1.PushFailurePolicyBestEffort,
+		},
+	}
+	client := testForgeClient.NewSimpleClientset()
+	_, err := client.ForgeV1alpha1().ContainerImageBuilds(cib.Namespace).Create(context.Background(), cib, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	image := &types.Image{
+		URLs: []string{"registry-a.io/app:latest"},
+		Pushes: []types.PushResult{
+			{Registry: "registry-a.io", URL: "registry-a.io/app:latest"},
+			{Registry: "registry-b.io", URL: "registry-b.io/app:latest", Error: "unauthorized"},
+		},
+	}
+	job := &Job{
+		log:         NewLogger(),
+		name:        cib.Name,
+		namespace:   cib.Namespace,
+		clientforge: client.ForgeV1alpha1(),
+		builder:     &fakeBuilder{err: &types.PushError{Image: image, Err: fmt.Errorf("boom")}},
+	}
+	assert.Error(t, job.Run())
+
+	actual, err := client.ForgeV1alpha1().ContainerImageBuilds(cib.Namespace).Get(context.Background(), cib.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, v1alpha1.BuildStatePartiallyPushed, actual.Status.State)
+	assert.Equal(t, v1alpha1.BuildReasonPushFailed, actual.Status.Reason)
+	assert.Equal(t, []string{"registry-a.io/app:latest"}, actual.Status.ImageURLs)
+	assert.Len(t, actual.Status.Pushes, 2)
+}
+
+func TestSetImageStatus(t *testing.T) {
+	status := &v1alpha1.ContainerImageBuildStatus{}
+	setImageStatus(status, &types.Image{
+		URLs:         []string{"registry-a.io/app:latest"},
+		Digest:       "sha256:manifest",
+		ConfigDigest: "sha256:config",
+		Size:         42,
+		Platforms:    []types.PlatformImage{{Platform: "linux/amd64", Digest: "sha256:manifest", ConfigDigest: "sha256:config", Size: 42}},
+		Pushes: []types.PushResult{
+			{Registry: "registry-a.io", URL: "registry-a.io/app:latest", Reference: "registry-a.io/app@sha256:manifest"},
+			{Registry: "registry-b.io", URL: "registry-b.io/app:latest", Reference: "registry-b.io/app@sha256:manifest", Error: "unauthorized"},
+		},
+		ContextDigest: "sha256:context",
+		AdditionalContexts: []types.AdditionalContext{
+			{Name: "assets", Digest: "sha256:assets"},
+			{Name: "shared", GitCommit: "abc123"},
+		},
+	})
+
+	assert.Equal(t, "sha256:manifest", status.ImageDigest)
+	assert.Equal(t, "sha256:config", status.ConfigDigest)
+	assert.Equal(t, []string{"registry-a.io/app@sha256:manifest"}, status.ImageReferences)
+	assert.Equal(t, []v1alpha1.RegistryPush{
+		{Registry: "registry-a.io", ImageURL: "registry-a.io/app:latest", Reference: "registry-a.io/app@sha256:manifest", State: v1alpha1.PushStatePushed},
+		{Registry: "registry-b.io", ImageURL: "registry-b.io/app:latest", Reference: "registry-b.io/app@sha256:manifest", State: v1alpha1.PushStateFailed, Error: "unauthorized"},
+	}, status.Pushes)
+	assert.Equal(t, "sha256:config", status.Platforms[0].ConfigDigest)
+	assert.Equal(t, "sha256:context", status.ContextDigest)
+	assert.Equal(t, []v1alpha1.AdditionalContextStatus{
+		{Name: "assets", Digest: "sha256:assets"},
+		{Name: "shared", GitCommit: "abc123"},
+	}, status.AdditionalContexts)
+}
+
 func TestBuildRegistryConfigs(t *testing.T) {
 	noAuthHost := "noauth-test.com"
 
@@ -233,3 +369,218 @@ func TestBuildRegistryConfigs(t *testing.T) {
 		assert.ElementsMatch(t, registriesReverseOrder, []config.Registry{secretRegistry1, secretRegistry2})
 	})
 }
+
+func TestBuildSecrets(t *testing.T) {
+	client := testK8sClient.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "tokens", Namespace: "build-ns"},
+		Data: map[string][]byte{
+			"npm":  []byte("npm-token"),
+			"pypi": []byte("pypi-token"),
+		},
+	})
+	job := &Job{log: NewLogger(), clientk8s: client}
+
+	t.Run("none", func(t *testing.T) {
+		secrets, err := job.buildSecrets(context.Background(), "build-ns", nil)
+		assert.NoError(t, err)
+		assert.Nil(t, secrets)
+	})
+
+	t.Run("resolved", func(t *testing.T) {
+		secrets, err := job.buildSecrets(context.Background(), "build-ns", []v1alpha1.BuildSecret{
+			{ID: "npmrc", SecretName: "tokens", Key: "npm"},
+			{ID: "pip", SecretName: "tokens", Key: "pypi"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]byte{"npmrc": []byte("npm-token"), "pip": []byte("pypi-token")}, secrets)
+	})
+
+	t.Run("missing_key", func(t *testing.T) {
+		_, err := job.buildSecrets(context.Background(), "build-ns", []v1alpha1.BuildSecret{
+			{ID: "npmrc", SecretName: "tokens", Key: "missing"},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("other_namespace", func(t *testing.T) {
+		_, err := job.buildSecrets(context.Background(), "other-ns", []v1alpha1.BuildSecret{
+			{ID: "npmrc", SecretName: "tokens", Key: "npm"},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestBuildSSHAgents(t *testing.T) {
+	client := testK8sClient.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "deploy-key", Namespace: "build-ns"},
+			Type:       corev1.SecretTypeSSHAuth,
+			Data: map[string][]byte{
+				corev1.SSHAuthPrivateKey: []byte("key-1"),
+				"known_hosts":            []byte("github.com ssh-ed25519 AAAA"),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "other-key", Namespace: "build-ns"},
+			Data: map[string][]byte{
+				"id_rsa":      []byte("key-2"),
+				"known_hosts": []byte("gitlab.com ssh-ed25519 BBBB"),
+			},
+		},
+	)
+	job := &Job{log: NewLogger(), clientk8s: client}
+
+	t.Run("grouped", func(t *testing.T) {
+		keys, knownHosts, err := job.buildSSHAgents(context.Background(), "build-ns", []v1alpha1.SSHAgent{
+			{SecretName: "deploy-key", KnownHostsKey: "known_hosts"},
+			{SecretName: "other-key", PrivateKeyKey: "id_rsa", KnownHostsKey: "known_hosts"},
+			{ID: "github", SecretName: "deploy-key"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, map[string][][]byte{
+			"default": {[]byte("key-1"), []byte("key-2")},
+			"github":  {[]byte("key-1")},
+		}, keys)
+		assert.Equal(t, map[string][]byte{
+			"default-known-hosts": []byte("github.com ssh-ed25519 AAAA\ngitlab.com ssh-ed25519 BBBB"),
+		}, knownHosts)
+	})
+
+	t.Run("missing_key", func(t *testing.T) {
+		_, _, err := job.buildSSHAgents(context.Background(), "build-ns", []v1alpha1.SSHAgent{
+			{SecretName: "other-key"},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("missing_known_hosts", func(t *testing.T) {
+		_, _, err := job.buildSSHAgents(context.Background(), "build-ns", []v1alpha1.SSHAgent{
+			{SecretName: "deploy-key", KnownHostsKey: "missing"},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestBuildGitAuth(t *testing.T) {
+	client := testK8sClient.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "https-creds", Namespace: "build-ns"},
+			Type:       corev1.SecretTypeBasicAuth,
+			Data: map[string][]byte{
+				corev1.BasicAuthUsernameKey: []byte("forge"),
+				corev1.BasicAuthPasswordKey: []byte("token"),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "ssh-creds", Namespace: "build-ns"},
+			Type:       corev1.SecretTypeSSHAuth,
+			Data: map[string][]byte{
+				corev1.SSHAuthPrivateKey: []byte("key"),
+				"known_hosts":            []byte("github.com ssh-ed25519 AAAA"),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "empty", Namespace: "build-ns"},
+		},
+	)
+	job := &Job{log: NewLogger(), clientk8s: client}
+
+	auth, err := job.buildGitAuth(context.Background(), "build-ns", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, auth)
+
+	auth, err = job.buildGitAuth(context.Background(), "build-ns", &v1alpha1.GitAuth{SecretName: "https-creds"})
+	require.NoError(t, err)
+	assert.Equal(t, "forge", auth.Username)
+	assert.Equal(t, "token", auth.Password)
+
+	auth, err = job.buildGitAuth(context.Background(), "build-ns", &v1alpha1.GitAuth{SecretName: "ssh-creds"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("key"), auth.SSHPrivateKey)
+	assert.Equal(t, []byte("github.com ssh-ed25519 AAAA"), auth.SSHKnownHosts)
+
+	_, err = job.buildGitAuth(context.Background(), "build-ns", &v1alpha1.GitAuth{SecretName: "empty"})
+	assert.Error(t, err)
+}
+
+func TestBuildContextAuth(t *testing.T) {
+	client := testK8sClient.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "token", Namespace: "build-ns"},
+			Data: map[string][]byte{
+				"token":            []byte("s3cr3t"),
+				"header.X-Tenant":  []byte("forge"),
+				"header.":          []byte("ignored"),
+				"ca.crt":           []byte("pem"),
+				"unrelated-config": []byte("value"),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "basic", Namespace: "build-ns"},
+			Type:       corev1.SecretTypeBasicAuth,
+			Data: map[string][]byte{
+				corev1.BasicAuthUsernameKey: []byte("forge"),
+				corev1.BasicAuthPasswordKey: []byte("pass"),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "conflict", Namespace: "build-ns"},
+			Data: map[string][]byte{
+				"token":                     []byte("s3cr3t"),
+				corev1.BasicAuthPasswordKey: []byte("pass"),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "empty", Namespace: "build-ns"},
+		},
+	)
+	job := &Job{log: NewLogger(), clientk8s: client}
+
+	auth, err := job.buildContextAuth(context.Background(), "build-ns", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, auth)
+
+	auth, err = job.buildContextAuth(context.Background(), "build-ns", &v1alpha1.ContextAuth{SecretName: "token"})
+	require.NoError(t, err)
+	assert.Equal(t, "s3cr3t", auth.BearerToken)
+	assert.Equal(t, map[string]string{"X-Tenant": "forge"}, auth.Headers)
+	assert.Equal(t, []byte("pem"), auth.CABundle)
+
+	auth, err = job.buildContextAuth(context.Background(), "build-ns", &v1alpha1.ContextAuth{SecretName: "basic"})
+	require.NoError(t, err)
+	assert.Equal(t, "forge", auth.Username)
+	assert.Equal(t, "pass", auth.Password)
+
+	for _, name := range []string{"conflict", "empty", "missing"} {
+		_, err = job.buildContextAuth(context.Background(), "build-ns", &v1alpha1.ContextAuth{SecretName: name})
+		assert.Error(t, err, name)
+	}
+}
+
+func TestBuildInlineFiles(t *testing.T) {
+	client := testK8sClient.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "build-files", Namespace: "build-ns"},
+		Data:       map[string]string{"requirements.txt": "numpy", "app.py": "print('configmap')"},
+		BinaryData: map[string][]byte{"model.bin": {0x1, 0x2}},
+	})
+	job := &Job{log: NewLogger(), clientk8s: client}
+
+	files, err := job.buildInlineFiles(context.Background(), "build-ns", &v1alpha1.ContainerImageBuildSpec{})
+	assert.NoError(t, err)
+	assert.Nil(t, files)
+
+	files, err = job.buildInlineFiles(context.Background(), "build-ns", &v1alpha1.ContainerImageBuildSpec{
+		FilesConfigMap: "build-files",
+		Files:          map[string]string{"app.py": "print('inline')", "conf/app.yaml": "key: value"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		"requirements.txt": []byte("numpy"),
+		"app.py":           []byte("print('inline')"),
+		"model.bin":        {0x1, 0x2},
+		"conf/app.yaml":    []byte("key: value"),
+	}, files)
+
+	_, err = job.buildInlineFiles(context.Background(), "build-ns", &v1alpha1.ContainerImageBuildSpec{FilesConfigMap: "missing"})
+	assert.Error(t, err)
+}
@@ -1,6 +1,13 @@
 package buildjob
 
-import "github.com/dominodatalab/forge/internal/message"
+import (
+	"time"
+
+	"github.com/dominodatalab/forge/internal/archive"
+	"github.com/dominodatalab/forge/internal/config"
+	"github.com/dominodatalab/forge/internal/layercache"
+	"github.com/dominodatalab/forge/internal/message"
+)
 
 type Config struct {
 	ResourceName        string
@@ -8,5 +15,28 @@ type Config struct {
 	BrokerOpts          *message.Options
 	PreparerPluginsPath string
 	EnableLayerCaching  bool
+	ContextLimits       archive.Limits
+	LayerCache          layercache.Options
+	PushConcurrency     int
+	StateGCKeepStorage  int64
+	Builder             string
+	RemoteBuildkit      config.RemoteBuildkitOptions
 	Debug               bool
 }
+
+// WorkerConfig configures a long-running worker that runs the builds dispatched to it by the controller. The resource
+// fields of the embedded build config are ignored.
+type WorkerConfig struct {
+	Config
+
+	// Name of the worker pod, the controller dispatches builds to it using this name.
+	Name string
+	// PodNamespace is the namespace of the worker pod.
+	PodNamespace string
+	// Namespace restricts the builds processed by the worker, every namespace is searched when empty.
+	Namespace string
+	// Concurrency is the number of builds run at once, each one uses a separate builder.
+	Concurrency int
+	// PollInterval is the delay between searches for dispatched builds.
+	PollInterval time.Duration
+}
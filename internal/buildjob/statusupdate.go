@@ -9,18 +9,27 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	apiv1alpha1 "github.com/dominodatalab/forge/api/forge/v1alpha1"
+	"github.com/dominodatalab/forge/internal/archive"
 	"github.com/dominodatalab/forge/internal/builder/types"
 )
 
 type StatusUpdate struct {
-	Name          string            `json:"name"`
-	Annotations   map[string]string `json:"annotations"`
-	ObjectLink    string            `json:"objectLink"`
-	PreviousState string            `json:"previousState"`
-	CurrentState  string            `json:"currentState"`
-	ErrorMessage  string            `json:"errorMessage"`
-	ImageURLs     []string          `json:"imageURLs"`
-	ImageSize     uint64            `json:"imageSize"`
+	Name               string                                `json:"name"`
+	Annotations        map[string]string                     `json:"annotations"`
+	ObjectLink         string                                `json:"objectLink"`
+	PreviousState      string                                `json:"previousState"`
+	CurrentState       string                                `json:"currentState"`
+	ErrorMessage       string                                `json:"errorMessage"`
+	Reason             string                                `json:"reason"`
+	ImageURLs          []string                              `json:"imageURLs"`
+	ImageReferences    []string                              `json:"imageReferences"`
+	ImageDigest        string                                `json:"imageDigest"`
+	ConfigDigest       string                                `json:"configDigest"`
+	ImageSize          uint64                                `json:"imageSize"`
+	Pushes             []apiv1alpha1.RegistryPush            `json:"pushes"`
+	GitCommit          string                                `json:"gitCommit"`
+	ContextDigest      string                                `json:"contextDigest"`
+	AdditionalContexts []apiv1alpha1.AdditionalContextStatus `json:"additionalContexts"`
 }
 
 func (j *Job) transitionToBuilding(ctx context.Context, cib *apiv1alpha1.ContainerImageBuild) (*apiv1alpha1.ContainerImageBuild, error) {
@@ -32,8 +41,10 @@ func (j *Job) transitionToBuilding(ctx context.Context, cib *apiv1alpha1.Contain
 
 func (j *Job) transitionToComplete(ctx context.Context, cib *apiv1alpha1.ContainerImageBuild, image *types.Image) error {
 	cib.Status.SetState(apiv1alpha1.BuildStateCompleted)
-	cib.Status.ImageURLs = image.URLs
-	cib.Status.ImageSize = image.Size
+	setImageStatus(&cib.Status, image)
+	if image.Skipped {
+		cib.Status.Reason = apiv1alpha1.BuildReasonTagExists
+	}
 	cib.Status.BuildCompletedAt = &metav1.Time{Time: time.Now()}
 
 	_, err := j.updateStatus(ctx, cib)
@@ -41,7 +52,91 @@ func (j *Job) transitionToComplete(ctx context.Context, cib *apiv1alpha1.Contain
 }
 
 func (j *Job) transitionToFailure(ctx context.Context, cib *apiv1alpha1.ContainerImageBuild, err error) error {
-	cib.Status.SetState(apiv1alpha1.BuildStateFailed)
+	state := apiv1alpha1.BuildStateFailed
+	cib.Status.ErrorMessage = err.Error()
+
+	var mismatch *archive.DigestMismatchError
+	if errors.As(err, &mismatch) {
+		cib.Status.Reason = apiv1alpha1.BuildReasonContextDigestMismatch
+		cib.Status.ContextDigest = mismatch.Actual.String()
+	}
+
+	var tagExists *types.TagExistsError
+	if errors.As(err, &tagExists) {
+		cib.Status.Reason = apiv1alpha1.BuildReasonTagExists
+	}
+
+	// record the registries that received the image, best effort builds are only partially failed when some did
+	var pushErr *types.PushError
+	if errors.As(err, &pushErr) {
+		cib.Status.Reason = apiv1alpha1.BuildReasonPushFailed
+		setImageStatus(&cib.Status, pushErr.Image)
+
+		if cib.Spec.PushFailurePolicy == apiv1alpha1.PushFailurePolicyBestEffort && len(pushErr.Image.URLs) > 0 {
+			state = apiv1alpha1.BuildStatePartiallyPushed
+		}
+	}
+	cib.Status.SetState(state)
+	cib.Status.BuildCompletedAt = &metav1.Time{Time: time.Now()}
+
+	_, err = j.updateStatus(ctx, cib)
+	return err
+}
+
+// records the digests, sizes and push outcomes of an image
+func setImageStatus(status *apiv1alpha1.ContainerImageBuildStatus, image *types.Image) {
+	status.ImageURLs = image.URLs
+	status.ImageDigest = image.Digest
+	status.ConfigDigest = image.ConfigDigest
+	status.ImageSize = image.Size
+	status.GitCommit = image.GitCommit
+	status.ContextDigest = image.ContextDigest
+
+	status.AdditionalContexts = nil
+	for _, ac := range image.AdditionalContexts {
+		status.AdditionalContexts = append(status.AdditionalContexts, apiv1alpha1.AdditionalContextStatus{
+			Name:      ac.Name,
+			Digest:    ac.Digest,
+			GitCommit: ac.GitCommit,
+		})
+	}
+
+	status.Platforms = nil
+	for _, pi := range image.Platforms {
+		status.Platforms = append(status.Platforms, apiv1alpha1.PlatformImage{
+			Platform:     pi.Platform,
+			Digest:       pi.Digest,
+			ConfigDigest: pi.ConfigDigest,
+			Size:         pi.Size,
+		})
+	}
+
+	status.ImageReferences = nil
+	status.Pushes = nil
+	for _, push := range image.Pushes {
+		rp := apiv1alpha1.RegistryPush{
+			Registry:  push.Registry,
+			ImageURL:  push.URL,
+			Reference: push.Reference,
+			State:     apiv1alpha1.PushStatePushed,
+		}
+		switch {
+		case push.Error != "":
+			rp.State = apiv1alpha1.PushStateFailed
+			rp.Error = push.Error
+		case push.Skipped:
+			rp.State = apiv1alpha1.PushStateSkipped
+		}
+		if rp.State != apiv1alpha1.PushStateFailed && push.Reference != "" {
+			status.ImageReferences = append(status.ImageReferences, push.Reference)
+		}
+		status.Pushes = append(status.Pushes, rp)
+	}
+}
+
+func (j *Job) transitionToTimedOut(ctx context.Context, cib *apiv1alpha1.ContainerImageBuild, err error) error {
+	cib.Status.SetState(apiv1alpha1.BuildStateTimedOut)
+	cib.Status.Reason = apiv1alpha1.BuildReasonDeadlineExceeded
 	cib.Status.ErrorMessage = err.Error()
 	cib.Status.BuildCompletedAt = &metav1.Time{Time: time.Now()}
 
@@ -57,13 +152,22 @@ func (j *Job) updateStatus(ctx context.Context, cib *apiv1alpha1.ContainerImageB
 
 	if j.producer != nil {
 		update := &StatusUpdate{
-			Name:          cib.Name,
-			Annotations:   cib.Annotations,
-			ObjectLink:    strings.TrimSuffix(cib.GetSelfLink(), "/status"),
-			PreviousState: string(cib.Status.PreviousState),
-			CurrentState:  string(cib.Status.State),
-			ImageURLs:     cib.Status.ImageURLs,
-			ErrorMessage:  cib.Status.ErrorMessage,
+			Name:               cib.Name,
+			Annotations:        cib.Annotations,
+			ObjectLink:         strings.TrimSuffix(cib.GetSelfLink(), "/status"),
+			PreviousState:      string(cib.Status.PreviousState),
+			CurrentState:       string(cib.Status.State),
+			ImageURLs:          cib.Status.ImageURLs,
+			ImageReferences:    cib.Status.ImageReferences,
+			ImageDigest:        cib.Status.ImageDigest,
+			ConfigDigest:       cib.Status.ConfigDigest,
+			ImageSize:          cib.Status.ImageSize,
+			Pushes:             cib.Status.Pushes,
+			ErrorMessage:       cib.Status.ErrorMessage,
+			Reason:             cib.Status.Reason,
+			GitCommit:          cib.Status.GitCommit,
+			ContextDigest:      cib.Status.ContextDigest,
+			AdditionalContexts: cib.Status.AdditionalContexts,
 		}
 		if err := j.producer.Push(update); err != nil {
 			return nil, errors.Wrap(err, "unable to publish message")
@@ -0,0 +1,372 @@
+package buildjob
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/dominodatalab/forge/api/forge/v1alpha1"
+	"github.com/dominodatalab/forge/internal/builder"
+	buildertypes "github.com/dominodatalab/forge/internal/builder/types"
+	forgev1alpha1 "github.com/dominodatalab/forge/internal/clientset/typed/forge/v1alpha1"
+	"github.com/dominodatalab/forge/internal/config"
+	"github.com/dominodatalab/forge/internal/message"
+	"github.com/dominodatalab/forge/plugins/preparer"
+)
+
+// Worker runs the builds that the controller dispatches to its pod. Builders are kept between builds so their caches
+// stay warm and every build runs as a regular Job, resulting in the same status updates and messages.
+type Worker struct {
+	log logr.Logger
+	cfg WorkerConfig
+
+	clientk8s   kubernetes.Interface
+	clientforge forgev1alpha1.ForgeV1alpha1Interface
+
+	// preparer plugin processes of every slot, they are killed on cleanup
+	plugins []*preparer.Plugin
+
+	// idle builders, there is one for every concurrent build
+	slots chan *workerSlot
+
+	mu        sync.Mutex
+	producers map[string]message.Producer
+	running   map[string]bool
+	wg        sync.WaitGroup
+}
+
+type workerSlot struct {
+	// builders keyed by backend, the embedded builder is omitted when remote builds are the default
+	builders map[string]builder.OCIImageBuilder
+	plugins  []*preparer.Plugin
+	workDir  string
+}
+
+// creates the builders of a slot
+var newBuilder = builder.New
+
+func newWorkerSlot(cfg WorkerConfig, idx int, log logr.Logger) (slot *workerSlot, err error) {
+	// plugins keep the resources they prepared for a build until they are cleaned up, so every slot runs its own
+	preparerPlugins, err := loadPlugins(cfg.PreparerPluginsPath, log)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			killPlugins(preparerPlugins, log)
+		}
+	}()
+
+	opts := config.BuilderOptions{
+		CacheImageLayers:   cfg.EnableLayerCaching,
+		StateGCKeepStorage: cfg.StateGCKeepStorage,
+		StateDir:           filepath.Join(config.GetStateDir(), "workers", strconv.Itoa(idx)),
+		WorkDir:            filepath.Join(config.BuildContextPath, strconv.Itoa(idx)),
+	}
+	slot = &workerSlot{builders: map[string]builder.OCIImageBuilder{}, plugins: preparerPlugins, workDir: opts.WorkDir}
+
+	if cfg.Builder != v1alpha1.BuilderRemote {
+		embedded, err := newBuilder(preparerPlugins, opts, log)
+		if err != nil {
+			return nil, err
+		}
+		slot.builders[v1alpha1.BuilderEmbedded] = embedded
+	}
+
+	if cfg.RemoteBuildkit.Address != "" {
+		opts.Remote = &cfg.RemoteBuildkit
+		remote, err := newBuilder(preparerPlugins, opts, log)
+		if err != nil {
+			return nil, err
+		}
+		slot.builders[v1alpha1.BuilderRemote] = remote
+	}
+
+	return slot, nil
+}
+
+// fails the builds selecting a backend that the worker is not configured for
+type unavailableBuilder struct {
+	backend string
+}
+
+func (b unavailableBuilder) SetLogger(logr.Logger) {}
+
+func (b unavailableBuilder) BuildAndPush(context.Context, *config.BuildOptions) (*buildertypes.Image, error) {
+	return nil, fmt.Errorf("builder backend %q is not available on this worker", b.backend)
+}
+
+func NewWorker(cfg WorkerConfig) (*Worker, error) {
+	if cfg.Concurrency < 1 {
+		return nil, fmt.Errorf("worker concurrency must be at least 1, got %d", cfg.Concurrency)
+	}
+
+	log := NewLogger().WithValues("worker", cfg.Name)
+
+	clientsk8s, clientforge, err := newClients(log)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Worker{
+		log:         log,
+		cfg:         cfg,
+		clientk8s:   clientsk8s,
+		clientforge: clientforge,
+		slots:       make(chan *workerSlot, cfg.Concurrency),
+		producers:   map[string]message.Producer{},
+		running:     map[string]bool{},
+	}
+
+	// builders lock their state directory, so each one is given its own
+	log.Info("Initializing OCI image builders", "Concurrency", cfg.Concurrency)
+	for i := 0; i < cfg.Concurrency; i++ {
+		slot, err := newWorkerSlot(cfg, i, log)
+		if err != nil {
+			w.Cleanup()
+			return nil, errors.Wrap(err, "image builder initialization failed")
+		}
+		w.plugins = append(w.plugins, slot.plugins...)
+		w.slots <- slot
+	}
+
+	return w, nil
+}
+
+// Run processes dispatched builds until the context is done, then waits for running builds to finish.
+func (w *Worker) Run(ctx context.Context) error {
+	if err := w.register(ctx); err != nil {
+		return err
+	}
+	if err := w.failInterruptedBuilds(ctx); err != nil {
+		return err
+	}
+
+	w.log.Info("Waiting for dispatched builds")
+
+	ticker := time.NewTicker(w.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.dispatch(ctx); err != nil {
+			w.log.Error(err, "Cannot process dispatched builds")
+		}
+
+		select {
+		case <-ctx.Done():
+			w.log.Info("Waiting for running builds to finish")
+			w.wg.Wait()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) Cleanup() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for queue, producer := range w.producers {
+		w.log.Info("Closing message producer", "Queue", queue)
+		producer.Close()
+	}
+	killPlugins(w.plugins, w.log)
+}
+
+// advertises the number of concurrent builds on the worker pod so that the controller does not exceed it
+func (w *Worker) register(ctx context.Context) error {
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, v1alpha1.WorkerConcurrencyAnnotation, strconv.Itoa(w.cfg.Concurrency))
+
+	_, err := w.clientk8s.CoreV1().Pods(w.cfg.PodNamespace).Patch(ctx, w.cfg.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	return errors.Wrapf(err, "cannot register worker pod %s/%s", w.cfg.PodNamespace, w.cfg.Name)
+}
+
+// builds that were running when the worker stopped cannot be resumed and are failed
+func (w *Worker) failInterruptedBuilds(ctx context.Context) error {
+	builds, err := w.dispatchedBuilds(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range builds {
+		cib := &builds[i]
+		if cib.Status.State == "" || cib.Status.State.IsFinished() {
+			continue
+		}
+
+		job, err := w.newJob(cib, nil)
+		if err != nil {
+			return err
+		}
+
+		job.log.Info("Failing build interrupted by a worker restart")
+		cib.Status.Reason = v1alpha1.BuildReasonWorkerLost
+		if err := job.transitionToFailure(ctx, cib, errors.New("build was interrupted by a restart of its worker")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// launches dispatched builds that have not been started yet while there are idle builders
+func (w *Worker) dispatch(ctx context.Context) error {
+	builds, err := w.dispatchedBuilds(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range builds {
+		cib := &builds[i]
+		if cib.Status.State != "" || cib.DeletionTimestamp != nil {
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%s", cib.Namespace, cib.Name)
+		w.mu.Lock()
+		running := w.running[key]
+		w.mu.Unlock()
+		if running {
+			continue
+		}
+
+		var slot *workerSlot
+		select {
+		case slot = <-w.slots:
+		default:
+			return nil
+		}
+
+		job, err := w.newJob(cib, slot)
+		if err != nil {
+			w.slots <- slot
+			return err
+		}
+
+		w.mu.Lock()
+		w.running[key] = true
+		w.mu.Unlock()
+
+		w.wg.Add(1)
+		go w.run(key, job, slot)
+	}
+
+	return nil
+}
+
+func (w *Worker) run(key string, job *Job, slot *workerSlot) {
+	defer w.wg.Done()
+	defer func() {
+		w.mu.Lock()
+		delete(w.running, key)
+		w.mu.Unlock()
+
+		w.slots <- slot
+	}()
+
+	// build jobs start out with an empty working directory, so workers do too
+	defer func() {
+		if err := os.RemoveAll(slot.workDir); err != nil {
+			job.log.Error(err, "Cannot remove build working directory")
+		}
+	}()
+
+	job.log.Info("Running dispatched build")
+	if err := job.Run(); err != nil {
+		job.log.Error(err, "Build did not complete")
+		return
+	}
+	job.log.Info("Build completed")
+}
+
+func (w *Worker) dispatchedBuilds(ctx context.Context) ([]v1alpha1.ContainerImageBuild, error) {
+	list, err := w.clientforge.ContainerImageBuilds(w.cfg.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", v1alpha1.WorkerLabel, w.cfg.Name),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list dispatched builds")
+	}
+	return list.Items, nil
+}
+
+// creates a job running a single build using the builder of a slot
+func (w *Worker) newJob(cib *v1alpha1.ContainerImageBuild, slot *workerSlot) (*Job, error) {
+	producer, err := w.producerFor(cib)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		log:             w.log.WithValues("build", fmt.Sprintf("%s/%s", cib.Namespace, cib.Name)),
+		clientk8s:       w.clientk8s,
+		clientforge:     w.clientforge,
+		producer:        producer,
+		contextLimits:   w.cfg.ContextLimits,
+		layerCache:      w.cfg.LayerCache,
+		pushConcurrency: w.cfg.PushConcurrency,
+		name:            cib.Name,
+		namespace:       cib.Namespace,
+		inWorker:        true,
+	}
+	if slot != nil {
+		job.plugins = slot.plugins
+
+		backend := cib.Spec.Builder
+		if backend == "" {
+			backend = w.defaultBuilder()
+		}
+
+		var ok bool
+		if job.builder, ok = slot.builders[backend]; !ok {
+			job.builder = unavailableBuilder{backend: backend}
+		}
+	}
+
+	return job, nil
+}
+
+func (w *Worker) defaultBuilder() string {
+	if w.cfg.Builder == "" {
+		return v1alpha1.BuilderEmbedded
+	}
+	return w.cfg.Builder
+}
+
+// returns the producer publishing to the queue of a build. builds can override the queue, so producers are created
+// on demand and shared by every build using the same queue.
+func (w *Worker) producerFor(cib *v1alpha1.ContainerImageBuild) (message.Producer, error) {
+	if w.cfg.BrokerOpts == nil {
+		return nil, nil
+	}
+
+	opts := *w.cfg.BrokerOpts
+	if cib.Spec.MessageQueueName != "" {
+		opts.AmqpQueue = cib.Spec.MessageQueueName
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if producer, ok := w.producers[opts.AmqpQueue]; ok {
+		return producer, nil
+	}
+
+	w.log.Info("Initializing status update message publisher", "Queue", opts.AmqpQueue)
+	producer, err := message.NewProducer(&opts, w.log)
+	if err != nil {
+		return nil, err
+	}
+	w.producers[opts.AmqpQueue] = producer
+
+	return producer, nil
+}
@@ -0,0 +1,293 @@
+package buildjob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	testK8sClient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/dominodatalab/forge/api/forge/v1alpha1"
+	"github.com/dominodatalab/forge/internal/builder"
+	"github.com/dominodatalab/forge/internal/builder/types"
+	testForgeClient "github.com/dominodatalab/forge/internal/clientset/fake"
+	"github.com/dominodatalab/forge/internal/config"
+	"github.com/dominodatalab/forge/internal/message"
+	"github.com/dominodatalab/forge/plugins/preparer"
+)
+
+func newTestWorker(t *testing.T, concurrency int, builds ...*v1alpha1.ContainerImageBuild) *Worker {
+	forgeClient := testForgeClient.NewSimpleClientset()
+	forgeClient.PrependReactor("list", "containerimagebuilds", listBuildsReactor(forgeClient))
+	for _, cib := range builds {
+		_, err := forgeClient.ForgeV1alpha1().ContainerImageBuilds(cib.Namespace).Create(context.Background(), cib, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	w := &Worker{
+		log: NewLogger(),
+		cfg: WorkerConfig{Name: "worker-0", PodNamespace: "forge", Concurrency: concurrency},
+		clientk8s: testK8sClient.NewSimpleClientset(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker-0", Namespace: "forge"},
+		}),
+		clientforge: forgeClient.ForgeV1alpha1(),
+		slots:       make(chan *workerSlot, concurrency),
+		producers:   map[string]message.Producer{},
+		running:     map[string]bool{},
+	}
+	for i := 0; i < concurrency; i++ {
+		w.slots <- &workerSlot{
+			builders: map[string]builder.OCIImageBuilder{v1alpha1.BuilderEmbedded: &fakeBuilder{image: &types.Image{}}},
+			workDir:  t.TempDir(),
+		}
+	}
+
+	return w
+}
+
+// the generated fake client lists builds using the wrong api group, so they are listed from its tracker instead
+func listBuildsReactor(client *testForgeClient.Clientset) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gvk := v1alpha1.SchemeGroupVersion.WithKind("ContainerImageBuild")
+		obj, err := client.Tracker().List(action.GetResource(), gvk, action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+
+		selector := action.(k8stesting.ListAction).GetListRestrictions().Labels
+		list := &v1alpha1.ContainerImageBuildList{}
+		for _, cib := range obj.(*v1alpha1.ContainerImageBuildList).Items {
+			if selector.Matches(labels.Set(cib.Labels)) {
+				list.Items = append(list.Items, cib)
+			}
+		}
+		return true, list, nil
+	}
+}
+
+func dispatchedBuild(name, worker string, state v1alpha1.BuildState) *v1alpha1.ContainerImageBuild {
+	return &v1alpha1.ContainerImageBuild{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "test-ns",
+			Labels:    map[string]string{v1alpha1.WorkerLabel: worker},
+		},
+		Spec:   v1alpha1.ContainerImageBuildSpec{Context: "https://example.com/context.tgz", PushRegistries: []string{"registry.io"}},
+		Status: v1alpha1.ContainerImageBuildStatus{State: state},
+	}
+}
+
+func buildState(t *testing.T, w *Worker, name string) *v1alpha1.ContainerImageBuildStatus {
+	cib, err := w.clientforge.ContainerImageBuilds("test-ns").Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return &cib.Status
+}
+
+func TestWorker_register(t *testing.T) {
+	w := newTestWorker(t, 3)
+	require.NoError(t, w.register(context.Background()))
+
+	pod, err := w.clientk8s.CoreV1().Pods("forge").Get(context.Background(), "worker-0", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "3", pod.Annotations[v1alpha1.WorkerConcurrencyAnnotation])
+}
+
+func TestWorker_failInterruptedBuilds(t *testing.T) {
+	w := newTestWorker(t, 1,
+		dispatchedBuild("interrupted", "worker-0", v1alpha1.BuildStateBuilding),
+		dispatchedBuild("pending", "worker-0", ""),
+		dispatchedBuild("other", "worker-1", v1alpha1.BuildStateBuilding),
+	)
+	require.NoError(t, w.failInterruptedBuilds(context.Background()))
+
+	status := buildState(t, w, "interrupted")
+	assert.Equal(t, v1alpha1.BuildStateFailed, status.State)
+	assert.Equal(t, v1alpha1.BuildReasonWorkerLost, status.Reason)
+	assert.NotNil(t, status.BuildCompletedAt)
+
+	assert.Empty(t, buildState(t, w, "pending").State)
+	assert.Equal(t, v1alpha1.BuildStateBuilding, buildState(t, w, "other").State)
+}
+
+func TestWorker_dispatch(t *testing.T) {
+	w := newTestWorker(t, 2,
+		dispatchedBuild("build-1", "worker-0", ""),
+		dispatchedBuild("build-2", "worker-0", ""),
+		dispatchedBuild("build-3", "worker-0", ""),
+		dispatchedBuild("done", "worker-0", v1alpha1.BuildStateCompleted),
+		dispatchedBuild("other", "worker-1", ""),
+	)
+
+	// only as many builds as there are builders are started at once
+	require.NoError(t, w.dispatch(context.Background()))
+	w.wg.Wait()
+
+	completed := 0
+	for _, name := range []string{"build-1", "build-2", "build-3"} {
+		if buildState(t, w, name).State == v1alpha1.BuildStateCompleted {
+			completed++
+		}
+	}
+	assert.Equal(t, 2, completed)
+	assert.Len(t, w.slots, 2, "builders are returned after every build")
+
+	require.NoError(t, w.dispatch(context.Background()))
+	w.wg.Wait()
+
+	for _, name := range []string{"build-1", "build-2", "build-3"} {
+		assert.Equal(t, v1alpha1.BuildStateCompleted, buildState(t, w, name).State)
+	}
+	assert.Empty(t, buildState(t, w, "other").State)
+	assert.Empty(t, w.running)
+}
+
+// remembers the build it prepared until it is cleaned up, like plugins preparing credentials or mounts do
+type statefulPreparer struct {
+	mu       sync.Mutex
+	prepared string
+	cleaned  []string
+}
+
+func (p *statefulPreparer) Prepare(contextPath string, _ map[string]string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.prepared = contextPath
+	return nil
+}
+
+func (p *statefulPreparer) Cleanup() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.cleaned = append(p.cleaned, p.prepared)
+	p.prepared = ""
+	return nil
+}
+
+// prepares the build context of every build with its plugins and waits for the other builds to be prepared as well
+type preparingBuilder struct {
+	fakeBuilder
+	plugins  []*preparer.Plugin
+	prepared *sync.WaitGroup
+}
+
+func (b *preparingBuilder) BuildAndPush(ctx context.Context, opts *config.BuildOptions) (*types.Image, error) {
+	for _, plugin := range b.plugins {
+		if err := plugin.Prepare(opts.BuildName, opts.PluginData); err != nil {
+			return nil, err
+		}
+	}
+	b.prepared.Done()
+	b.prepared.Wait()
+
+	for _, plugin := range b.plugins {
+		if err := plugin.Cleanup(); err != nil {
+			return nil, err
+		}
+	}
+	return b.fakeBuilder.BuildAndPush(ctx, opts)
+}
+
+func TestWorker_concurrentSlotPlugins(t *testing.T) {
+	pluginsPath := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(pluginsPath, "plugin"), nil, 0755))
+
+	var preparers []*statefulPreparer
+	preparer.DefaultPluginLoader = func(string) (*preparer.Plugin, error) {
+		p := &statefulPreparer{}
+		preparers = append(preparers, p)
+		return &preparer.Plugin{Preparer: p}, nil
+	}
+	defer func() { preparer.DefaultPluginLoader = preparer.NewPreparerPlugin }()
+
+	prepared := &sync.WaitGroup{}
+	prepared.Add(2)
+	newBuilder = func(plugins []*preparer.Plugin, _ config.BuilderOptions, _ logr.Logger) (builder.OCIImageBuilder, error) {
+		return &preparingBuilder{fakeBuilder: fakeBuilder{image: &types.Image{}}, plugins: plugins, prepared: prepared}, nil
+	}
+	defer func() { newBuilder = builder.New }()
+
+	w := newTestWorker(t, 2, dispatchedBuild("build-1", "worker-0", ""), dispatchedBuild("build-2", "worker-0", ""))
+	w.cfg.PreparerPluginsPath = pluginsPath
+	for i := 0; i < 2; i++ {
+		<-w.slots
+		slot, err := newWorkerSlot(w.cfg, i, w.log)
+		require.NoError(t, err)
+		slot.workDir = t.TempDir()
+		w.slots <- slot
+	}
+	require.Len(t, preparers, 2, "every slot loads its own plugins")
+
+	// both builds are prepared before either one is cleaned up
+	require.NoError(t, w.dispatch(context.Background()))
+	w.wg.Wait()
+
+	var cleaned []string
+	for _, p := range preparers {
+		require.Len(t, p.cleaned, 1)
+		cleaned = append(cleaned, p.cleaned...)
+	}
+	assert.ElementsMatch(t, []string{"build-1", "build-2"}, cleaned)
+	for _, name := range []string{"build-1", "build-2"} {
+		assert.Equal(t, v1alpha1.BuildStateCompleted, buildState(t, w, name).State)
+	}
+}
+
+func TestWorker_newJobBuilder(t *testing.T) {
+	w := newTestWorker(t, 1)
+	slot := <-w.slots
+	remote := &fakeBuilder{}
+	slot.builders[v1alpha1.BuilderRemote] = remote
+
+	cib := dispatchedBuild("build", "worker-0", "")
+	job, err := w.newJob(cib, slot)
+	require.NoError(t, err)
+	assert.Equal(t, slot.builders[v1alpha1.BuilderEmbedded], job.builder)
+
+	cib.Spec.Builder = v1alpha1.BuilderRemote
+	job, err = w.newJob(cib, slot)
+	require.NoError(t, err)
+	assert.Equal(t, remote, job.builder)
+
+	// builds selecting a backend that is not configured fail when they run
+	delete(slot.builders, v1alpha1.BuilderRemote)
+	job, err = w.newJob(cib, slot)
+	require.NoError(t, err)
+	_, err = job.builder.BuildAndPush(context.Background(), &config.BuildOptions{})
+	assert.EqualError(t, err, `builder backend "remote" is not available on this worker`)
+}
+
+func TestJob_getDynamicDockerAuths_worker(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: config.DynamicCredentialsSecretName("test-cib"), Namespace: "test-ns"},
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"registry.io":{"username":"user","password":"pass"}}}`),
+		},
+	}
+	job := &Job{
+		log:       NewLogger(),
+		name:      "test-cib",
+		namespace: "test-ns",
+		clientk8s: testK8sClient.NewSimpleClientset(secret),
+		inWorker:  true,
+	}
+
+	auths, err := job.getDynamicDockerAuths(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "user", auths["registry.io"].Username)
+
+	job.name = "missing"
+	_, err = job.getDynamicDockerAuths(context.Background())
+	assert.Error(t, err)
+}
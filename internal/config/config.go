@@ -1,8 +1,15 @@
 package config
 
 import (
+	"fmt"
 	"path/filepath"
 	"time"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/dominodatalab/forge/internal/archive"
+	"github.com/dominodatalab/forge/internal/git"
+	"github.com/dominodatalab/forge/internal/layercache"
 )
 
 const (
@@ -12,11 +19,20 @@ const (
 	DynamicCredentialsFilename = "config.json"
 	// BuildContextPath is the path where the build context directory will be mounted.
 	BuildContextPath = "/mnt/build"
+	// VolumeContextPath is the path where volumes serving the build context are mounted read-only.
+	VolumeContextPath = "/mnt/context"
+	// LayerCachePath is the path where the volume holding local layer caches is mounted.
+	LayerCachePath = "/mnt/layer-cache"
 )
 
 // DynamicCredentialsFilepath is the full path to the dynamic cloud registry credentials.
 var DynamicCredentialsFilepath = filepath.Join(DynamicCredentialsPath, DynamicCredentialsFilename)
 
+// DynamicCredentialsSecretName returns the name of the secret housing the dynamic cloud registry credentials of a build.
+func DynamicCredentialsSecretName(build string) string {
+	return fmt.Sprintf("%s-dynamic-cloud-credentials", build)
+}
+
 type Registry struct {
 	Host     string
 	NonSSL   bool
@@ -26,16 +42,75 @@ type Registry struct {
 
 type BuildOptions struct {
 	ContextURL              string
+	ContextDir              string
 	ContextTimeout          time.Duration
+	ContextLimits           archive.Limits
+	ContextDigest           digest.Digest
+	ContextAuth             *archive.Auth
+	GitAuth                 *git.Auth
+	DockerfilePath          string
+	Dockerfile              []byte
+	InlineFiles             map[string][]byte
+	AdditionalContexts      map[string]string
+	Target                  string
+	ExtraHosts              []string
+	NetworkMode             string
+	ShmSize                 int64
+	Platforms               []string
 	ImageName               string
+	Tags                    []string
+	TagPolicy               string
+	BuildName               string
+	BuildNamespace          string
 	ImageSizeLimit          uint64
 	Labels                  map[string]string
 	BuildArgs               []string
+	Secrets                 map[string][]byte
+	SSHKeys                 map[string][][]byte
 	DisableBuildCache       bool
 	DisableLayerCacheExport bool
 	Timeout                 time.Duration
 	Registries              []Registry
 	PushRegistries          []string
+	BuildOnly               bool
+	PushBestEffort          bool
+	PushConcurrency         int
 	PluginData              map[string]string
+	Cache                   CacheOptions
 	CacheFrom               []string
 }
+
+// BuilderOptions configures an image builder. Empty directories default to the ones mounted into build jobs, builders
+// running side by side in one process must use separate directories.
+type BuilderOptions struct {
+	CacheImageLayers   bool
+	StateGCKeepStorage int64
+	StateDir           string
+	WorkDir            string
+
+	// Remote delegates builds to an external buildkit daemon instead of running buildkit inside the builder.
+	Remote *RemoteBuildkitOptions
+}
+
+// RemoteBuildkitOptions configures the connection to an external buildkit daemon. The address uses the "tcp://" or
+// "unix://" scheme. TLS is enabled by a CA certificate and client certificates enable mutual TLS, all of them are file
+// paths.
+type RemoteBuildkitOptions struct {
+	Address    string
+	CACert     string
+	Cert       string
+	Key        string
+	ServerName string
+}
+
+// CacheOptions configures the layer cache of a build. Keys are tag templates until they are rendered by the builder.
+type CacheOptions struct {
+	Enabled      *bool
+	Backend      string
+	Mode         string
+	Repository   string
+	Key          string
+	FallbackKeys []string
+	Local        layercache.LocalOptions
+	S3           layercache.S3Options
+}
@@ -0,0 +1,178 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/go-logr/logr"
+
+	"github.com/dominodatalab/forge/internal/util"
+)
+
+// matches full and abbreviated commit hashes
+var commitSHA = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// Auth contains the credentials used to fetch private repositories.
+type Auth struct {
+	// Username and Password are sent using basic authentication to http(s) remotes.
+	Username string
+	Password string
+
+	// SSHPrivateKey is used to authenticate with ssh remotes. Host keys are verified against SSHKnownHosts when
+	// provided.
+	SSHPrivateKey []byte
+	SSHKnownHosts []byte
+}
+
+// Checkout is the result of fetching a git source.
+type Checkout struct {
+	// Commit is the full SHA of the commit that was checked out.
+	Commit string
+	// ContentsDir is the directory containing the build context.
+	ContentsDir string
+}
+
+// Fetch checks out a shallow copy of the source into the destination directory and returns the resolved commit. The
+// ".git" directory is removed once the commit has been resolved.
+func Fetch(ctx context.Context, log logr.Logger, src *Source, dest string, auth *Auth) (*Checkout, error) {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return nil, err
+	}
+
+	tmpDir, err := ioutil.TempDir("", "forge-git-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	env, err := authEnv(log, src, auth, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+
+	run := func(args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dest
+		cmd.Env = append(os.Environ(), env...)
+
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			msg := strings.ReplaceAll(strings.TrimSpace(stderr.String()), src.Remote, src.String())
+			return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, msg)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+
+	log.Info("Fetching git repository", "source", src.String())
+
+	if _, err := run("init", "-q"); err != nil {
+		return nil, err
+	}
+	if _, err := run("remote", "add", "origin", src.Remote); err != nil {
+		return nil, err
+	}
+
+	ref := src.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	checkout := "FETCH_HEAD"
+	if _, err := run("fetch", "-q", "--depth", "1", "origin", ref); err != nil {
+		// servers may refuse to serve unadvertised commits, fall back to fetching the full history
+		if !commitSHA.MatchString(ref) {
+			return nil, err
+		}
+		log.Info("Shallow fetch of commit failed, fetching full history", "commit", ref)
+
+		if _, err := run("fetch", "-q", "--tags", "origin"); err != nil {
+			return nil, err
+		}
+		checkout = ref
+	}
+
+	if _, err := run("checkout", "-q", checkout); err != nil {
+		return nil, err
+	}
+
+	commit, err := run("rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Checked out git repository", "source", src.String(), "commit", commit)
+
+	if err := os.RemoveAll(filepath.Join(dest, ".git")); err != nil {
+		return nil, err
+	}
+
+	// symlinks committed to the repository must not turn directories outside of the checkout into the build context
+	contentsDir, err := util.ResolveInRoot(dest, src.Subdir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid git subdirectory %q: %w", src.Subdir, err)
+	}
+	if err := util.AssertDir(contentsDir); err != nil {
+		return nil, fmt.Errorf("invalid git subdirectory %q: %w", src.Subdir, err)
+	}
+
+	return &Checkout{
+		Commit:      commit,
+		ContentsDir: contentsDir,
+	}, nil
+}
+
+// builds the environment used to run git commands. credentials are passed using environment variables and files
+// outside the checkout so they never appear in process arguments or the build context.
+func authEnv(log logr.Logger, src *Source, auth *Auth, tmpDir string) ([]string, error) {
+	env := []string{"GIT_TERMINAL_PROMPT=0"}
+	if auth == nil {
+		return env, nil
+	}
+
+	if src.IsHTTP() && auth.Password != "" {
+		username := auth.Username
+		if username == "" {
+			username = "git"
+		}
+		creds := base64.StdEncoding.EncodeToString([]byte(username + ":" + auth.Password))
+
+		env = append(env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+creds,
+		)
+	}
+
+	if src.IsSSH() && len(auth.SSHPrivateKey) != 0 {
+		keyFile := filepath.Join(tmpDir, "id")
+		if err := ioutil.WriteFile(keyFile, auth.SSHPrivateKey, 0600); err != nil {
+			return nil, err
+		}
+
+		knownHosts, hostKeyChecking := "/dev/null", "no"
+		if len(auth.SSHKnownHosts) != 0 {
+			knownHosts, hostKeyChecking = filepath.Join(tmpDir, "known_hosts"), "yes"
+			if err := ioutil.WriteFile(knownHosts, auth.SSHKnownHosts, 0600); err != nil {
+				return nil, err
+			}
+		} else {
+			log.Info("No known hosts provided, ssh host keys will not be verified", "source", src.String())
+		}
+
+		env = append(env, fmt.Sprintf(
+			"GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes -o UserKnownHostsFile=%s -o StrictHostKeyChecking=%s",
+			keyFile, knownHosts, hostKeyChecking,
+		))
+	}
+
+	return env, nil
+}
@@ -0,0 +1,206 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/dominodatalab/forge/internal/util"
+)
+
+var logger = log.NullLogger{}
+
+// creates a bare repository with two commits on "main", a "v1" tag on the first commit and a "feature" branch
+func setupRepository(t *testing.T) (string, map[string]string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	root := t.TempDir()
+	work := filepath.Join(root, "work")
+	bare := filepath.Join(root, "repo.git")
+
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=forge", "GIT_AUTHOR_EMAIL=forge@example.com",
+			"GIT_COMMITTER_NAME=forge", "GIT_COMMITTER_EMAIL=forge@example.com",
+			"GIT_CONFIG_NOSYSTEM=1", "HOME="+root,
+		)
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+		return strings.TrimSpace(string(out))
+	}
+	write := func(name, content string) {
+		fp := filepath.Join(work, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0755))
+		require.NoError(t, ioutil.WriteFile(fp, []byte(content), 0644))
+	}
+
+	require.NoError(t, os.MkdirAll(work, 0755))
+	git(work, "init", "-q", "-b", "main")
+
+	write("Dockerfile", "FROM scratch\n")
+	write("app/Dockerfile", "FROM scratch\nCOPY . .\n")
+	git(work, "add", ".")
+	git(work, "commit", "-q", "-m", "first")
+	first := git(work, "rev-parse", "HEAD")
+	git(work, "tag", "v1")
+
+	write("VERSION", "2\n")
+	git(work, "add", ".")
+	git(work, "commit", "-q", "-m", "second")
+	second := git(work, "rev-parse", "HEAD")
+
+	git(work, "checkout", "-q", "-b", "feature")
+	write("FEATURE", "yes\n")
+	git(work, "add", ".")
+	git(work, "commit", "-q", "-m", "feature")
+	feature := git(work, "rev-parse", "HEAD")
+	git(work, "checkout", "-q", "main")
+
+	// symlinked directories on the "links" branch, only one of them stays inside the repository
+	git(work, "checkout", "-q", "-b", "links")
+	require.NoError(t, os.Symlink("app", filepath.Join(work, "inside")))
+	require.NoError(t, os.Symlink(root, filepath.Join(work, "absolute")))
+	require.NoError(t, os.Symlink("../..", filepath.Join(work, "relative")))
+	git(work, "add", ".")
+	git(work, "commit", "-q", "-m", "links")
+	git(work, "checkout", "-q", "main")
+
+	git(root, "clone", "-q", "--bare", work, bare)
+
+	return bare, map[string]string{"first": first, "second": second, "feature": feature}
+}
+
+func TestFetch(t *testing.T) {
+	bare, commits := setupRepository(t)
+
+	cases := []struct {
+		name    string
+		context string
+		commit  string
+		files   []string
+		missing []string
+	}{
+		{"default_branch", "git+file://" + bare, commits["second"], []string{"Dockerfile", "VERSION"}, []string{"FEATURE", ".git"}},
+		{"branch", "git+file://" + bare + "#feature", commits["feature"], []string{"FEATURE"}, nil},
+		{"tag", "git+file://" + bare + "#v1", commits["first"], []string{"Dockerfile"}, []string{"VERSION"}},
+		{"commit", "git+file://" + bare + "#" + commits["first"], commits["first"], []string{"Dockerfile"}, []string{"VERSION"}},
+		{"short_commit", "git+file://" + bare + "#" + commits["first"][:10], commits["first"], []string{"Dockerfile"}, []string{"VERSION"}},
+		{"subdir", "git+file://" + bare + "#main:app", commits["second"], []string{"Dockerfile"}, []string{"VERSION"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			src, err := ParseSource(tc.context)
+			require.NoError(t, err)
+
+			checkout, err := Fetch(context.Background(), logger, src, filepath.Join(t.TempDir(), "repository"), nil)
+			require.NoError(t, err)
+			assert.Equal(t, tc.commit, checkout.Commit)
+
+			for _, f := range tc.files {
+				assert.FileExists(t, filepath.Join(checkout.ContentsDir, f))
+			}
+			for _, f := range tc.missing {
+				_, err := os.Stat(filepath.Join(checkout.ContentsDir, f))
+				assert.True(t, os.IsNotExist(err), "%s should not exist", f)
+			}
+		})
+	}
+
+	t.Run("unknown_ref", func(t *testing.T) {
+		src, err := ParseSource("git+file://" + bare + "#does-not-exist")
+		require.NoError(t, err)
+
+		_, err = Fetch(context.Background(), logger, src, filepath.Join(t.TempDir(), "repository"), nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown_subdir", func(t *testing.T) {
+		src, err := ParseSource("git+file://" + bare + "#main:nope")
+		require.NoError(t, err)
+
+		_, err = Fetch(context.Background(), logger, src, filepath.Join(t.TempDir(), "repository"), nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestFetch_symlinkedSubdir(t *testing.T) {
+	bare, _ := setupRepository(t)
+
+	fetch := func(subdir string) (*Checkout, string, error) {
+		src, err := ParseSource("git+file://" + bare + "#links:" + subdir)
+		require.NoError(t, err)
+
+		dest := filepath.Join(t.TempDir(), "repository")
+		checkout, err := Fetch(context.Background(), logger, src, dest, nil)
+		return checkout, dest, err
+	}
+
+	checkout, dest, err := fetch("inside")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dest, "app"), checkout.ContentsDir)
+	assert.FileExists(t, filepath.Join(checkout.ContentsDir, "Dockerfile"))
+
+	for _, subdir := range []string{"absolute", "relative"} {
+		_, _, err := fetch(subdir)
+		assert.ErrorIs(t, err, util.ErrOutsideRoot, subdir)
+	}
+}
+
+func TestFetch_http(t *testing.T) {
+	bare, commits := setupRepository(t)
+
+	gitPath, err := exec.LookPath("git")
+	require.NoError(t, err)
+
+	backend := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + filepath.Dir(bare),
+			"GIT_HTTP_EXPORT_ALL=1",
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "forge" || password != "s3cr3t" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	src, err := ParseSource(fmt.Sprintf("%s/%s#main", srv.URL, filepath.Base(bare)))
+	require.NoError(t, err)
+
+	t.Run("authenticated", func(t *testing.T) {
+		auth := &Auth{Username: "forge", Password: "s3cr3t"}
+		checkout, err := Fetch(context.Background(), logger, src, filepath.Join(t.TempDir(), "repository"), auth)
+		require.NoError(t, err)
+		assert.Equal(t, commits["second"], checkout.Commit)
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		_, err := Fetch(context.Background(), logger, src, filepath.Join(t.TempDir(), "repository"), nil)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,108 @@
+package git
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// matches scp-like ssh addresses such as git@github.com:org/repo.git
+var scpLikeURL = regexp.MustCompile(`^[A-Za-z0-9_.-]+@[A-Za-z0-9_.-]+:[^/]`)
+
+// Source describes a git repository build context.
+type Source struct {
+	// Remote is the repository address passed to git.
+	Remote string
+	// Ref is a branch, tag or commit to check out. The remote HEAD is used when empty.
+	Ref string
+	// Subdir is the directory inside the repository used as the build context root.
+	Subdir string
+}
+
+// IsSSH returns true when the repository is fetched over ssh.
+func (s *Source) IsSSH() bool {
+	return strings.HasPrefix(s.Remote, "ssh://") || scpLikeURL.MatchString(s.Remote)
+}
+
+// IsHTTP returns true when the repository is fetched over http(s).
+func (s *Source) IsHTTP() bool {
+	return strings.HasPrefix(s.Remote, "https://") || strings.HasPrefix(s.Remote, "http://")
+}
+
+// String returns the repository address without credentials, followed by the ref and subdirectory when present.
+func (s *Source) String() string {
+	remote := s.Remote
+	if u, err := url.Parse(remote); err == nil && u.User != nil {
+		u.User = nil
+		remote = u.String()
+	}
+
+	if s.Ref == "" && s.Subdir == "" {
+		return remote
+	}
+	return fmt.Sprintf("%s#%s:%s", remote, s.Ref, s.Subdir)
+}
+
+// IsSource returns true when a build context references a git repository.
+//
+// Supported formats are "git://", "git+https://", "git+http://", "git+ssh://" and "git+file://" URLs, scp-like ssh
+// addresses (git@host:org/repo.git) and http(s) URLs ending with ".git". A "#ref:subdir" fragment may be appended to
+// select a branch, tag or commit and a subdirectory, matching the format used by "docker build".
+func IsSource(context string) bool {
+	remote := strings.SplitN(context, "#", 2)[0]
+
+	switch {
+	case strings.HasPrefix(remote, "git://"), strings.HasPrefix(remote, "git+"):
+		return true
+	case scpLikeURL.MatchString(remote):
+		return true
+	case strings.HasPrefix(remote, "https://"), strings.HasPrefix(remote, "http://"):
+		u, err := url.Parse(remote)
+		return err == nil && strings.HasSuffix(u.Path, ".git")
+	}
+
+	return false
+}
+
+// ParseSource parses a git build context.
+func ParseSource(context string) (*Source, error) {
+	if !IsSource(context) {
+		return nil, fmt.Errorf("%q is not a git repository", context)
+	}
+
+	parts := strings.SplitN(context, "#", 2)
+	src := &Source{Remote: strings.TrimPrefix(parts[0], "git+")}
+
+	switch {
+	case src.IsHTTP(), src.IsSSH(), strings.HasPrefix(src.Remote, "git://"), strings.HasPrefix(src.Remote, "file://"):
+	default:
+		return nil, fmt.Errorf("unsupported git repository address %q", parts[0])
+	}
+
+	if len(parts) == 2 {
+		fragment := strings.SplitN(parts[1], ":", 2)
+		src.Ref = fragment[0]
+		if len(fragment) == 2 {
+			src.Subdir = fragment[1]
+		}
+	}
+
+	if strings.HasPrefix(src.Ref, "-") {
+		return nil, fmt.Errorf("invalid git ref %q", src.Ref)
+	}
+
+	if src.Subdir != "" {
+		clean := path.Clean(src.Subdir)
+		if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return nil, fmt.Errorf("git subdirectory %q must be relative to the repository root", src.Subdir)
+		}
+		if clean == "." {
+			clean = ""
+		}
+		src.Subdir = clean
+	}
+
+	return src, nil
+}
@@ -0,0 +1,90 @@
+// Package layercache stores BuildKit layer caches outside of image registries.
+//
+// Caches are kept in OCI image layouts, one per image repository, where every cache key is a tag in the layout index.
+// Layouts are either stored on a local volume or synced with an S3-compatible object store around each build.
+package layercache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Options configures the layer cache backends available to builds.
+type Options struct {
+	// Backend used by builds that do not select one.
+	Backend string
+	Local   LocalOptions
+	S3      S3Options
+}
+
+// LocalOptions configures caches stored in a local directory, typically a mounted persistent volume.
+type LocalOptions struct {
+	// Directory containing the cache layouts.
+	Dir string
+	// Total size in bytes of the caches kept after a build. Zero disables the limit.
+	MaxSize int64
+	// Age after which unused caches are removed. Zero disables the limit.
+	MaxAge time.Duration
+}
+
+// S3Options configures caches stored in an S3-compatible bucket.
+type S3Options struct {
+	// Endpoint of the object store, e.g. "http://minio.forge:9000". Defaults to the AWS endpoint of the region.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix added to every object key.
+	Prefix string
+}
+
+// Configured returns true when a bucket was provided.
+func (o S3Options) Configured() bool {
+	return o.Bucket != ""
+}
+
+// LayoutDir returns the directory holding the cache layout of an image repository, e.g. "registry.io/org/app".
+func LayoutDir(root, repository string) string {
+	return filepath.Join(root, filepath.FromSlash(repository))
+}
+
+func blobPath(dir string, dgst digest.Digest) string {
+	return filepath.Join(dir, "blobs", dgst.Algorithm().String(), dgst.Hex())
+}
+
+// returns the blobs referenced by a cache manifest, including the manifest itself. cache manifests are indexes that
+// list every layer and the cache configuration.
+func referencedBlobs(manifest ocispec.Descriptor, read func(digest.Digest) ([]byte, error)) ([]ocispec.Descriptor, error) {
+	refs := []ocispec.Descriptor{manifest}
+	if !isIndex(manifest.MediaType) {
+		return refs, nil
+	}
+
+	bs, err := read(manifest.Digest)
+	if err != nil {
+		return nil, err
+	}
+	var idx ocispec.Index
+	if err := json.Unmarshal(bs, &idx); err != nil {
+		return nil, err
+	}
+	return append(refs, idx.Manifests...), nil
+}
+
+func isIndex(mediaType string) bool {
+	return mediaType == ocispec.MediaTypeImageIndex || strings.HasSuffix(mediaType, "manifest.list.v2+json")
+}
+
+func readLayoutBlob(dir string) func(digest.Digest) ([]byte, error) {
+	return func(dgst digest.Digest) ([]byte, error) {
+		if err := dgst.Validate(); err != nil {
+			return nil, err
+		}
+		return os.ReadFile(blobPath(dir, dgst))
+	}
+}
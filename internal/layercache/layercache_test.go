@@ -0,0 +1,75 @@
+package layercache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/client/ociindex"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// writes a cache the way buildkit exports it into a local layout and returns the digests of its blobs
+func writeTestCache(t *testing.T, dir, tag string, created time.Time, layers ...string) []digest.Digest {
+	t.Helper()
+
+	writeBlob := func(bs []byte) ocispec.Descriptor {
+		dgst := digest.FromBytes(bs)
+		fp := blobPath(dir, dgst)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fp), 0755))
+		require.NoError(t, os.WriteFile(fp, bs, 0644))
+		return ocispec.Descriptor{Digest: dgst, Size: int64(len(bs)), MediaType: ocispec.MediaTypeImageLayerGzip}
+	}
+
+	var idx ocispec.Index
+	idx.SchemaVersion = 2
+	for _, layer := range layers {
+		idx.Manifests = append(idx.Manifests, writeBlob([]byte(layer)))
+	}
+	config := writeBlob([]byte(`{"layers":[]}`))
+	config.MediaType = "application/vnd.buildkit.cacheconfig.v0"
+	idx.Manifests = append(idx.Manifests, config)
+
+	bs, err := json.Marshal(idx)
+	require.NoError(t, err)
+	manifest := writeBlob(bs)
+	manifest.MediaType = ocispec.MediaTypeImageIndex
+	manifest.Annotations = map[string]string{ocispec.AnnotationCreated: created.UTC().Format(time.RFC3339)}
+	require.NoError(t, ociindex.PutDescToIndexJSONFileLocked(filepath.Join(dir, "index.json"), manifest, tag))
+
+	dgsts := []digest.Digest{manifest.Digest}
+	for _, desc := range idx.Manifests {
+		dgsts = append(dgsts, desc.Digest)
+	}
+	return dgsts
+}
+
+func indexTags(t *testing.T, dir string) []string {
+	t.Helper()
+
+	idx, err := ociindex.ReadIndexJSONFileLocked(filepath.Join(dir, "index.json"))
+	require.NoError(t, err)
+
+	var tags []string
+	for _, m := range idx.Manifests {
+		tags = append(tags, m.Annotations[ocispec.AnnotationRefName])
+	}
+	return tags
+}
+
+// makes blobs eligible for removal
+func ageBlobs(t *testing.T, dir string) {
+	t.Helper()
+
+	old := time.Now().Add(-2 * blobGracePeriod)
+	require.NoError(t, filepath.Walk(filepath.Join(dir, "blobs"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		return os.Chtimes(path, old, old)
+	}))
+}
@@ -0,0 +1,195 @@
+package layercache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/moby/buildkit/client/ociindex"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// blobs written within this period are never removed since concurrent builds may still reference them
+const blobGracePeriod = time.Hour
+
+type cacheEntry struct {
+	layout  string
+	tag     string
+	created time.Time
+	blobs   []ocispec.Descriptor
+}
+
+// Prune enforces the size and age limits of the caches stored below a local cache directory. Caches older than the
+// max age are removed first, followed by the least recently exported caches until the remaining ones fit into the max
+// size. Blobs that are no longer referenced by any cache are deleted afterwards.
+func Prune(opts LocalOptions) error {
+	layouts, err := findLayouts(opts.Dir)
+	if err != nil {
+		return err
+	}
+
+	var entries []*cacheEntry
+	for _, layout := range layouts {
+		le, err := layoutEntries(layout)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, le...)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].created.Before(entries[j].created) })
+
+	now := time.Now()
+	var keep []*cacheEntry
+	for _, entry := range entries {
+		if opts.MaxAge > 0 && now.Sub(entry.created) > opts.MaxAge {
+			if err := removeTag(entry); err != nil {
+				return err
+			}
+			continue
+		}
+		keep = append(keep, entry)
+	}
+
+	for opts.MaxSize > 0 && len(keep) > 0 && totalSize(keep) > opts.MaxSize {
+		if err := removeTag(keep[0]); err != nil {
+			return err
+		}
+		keep = keep[1:]
+	}
+
+	for _, layout := range layouts {
+		if err := removeUnreferencedBlobs(layout, keep, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns every directory below root that holds a cache layout
+func findLayouts(root string) ([]string, error) {
+	var layouts []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() && info.Name() == "blobs" {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && info.Name() == "index.json" {
+			layouts = append(layouts, filepath.Dir(path))
+		}
+		return nil
+	})
+	return layouts, err
+}
+
+func layoutEntries(layout string) ([]*cacheEntry, error) {
+	idx, err := ociindex.ReadIndexJSONFileLocked(filepath.Join(layout, "index.json"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read cache index in %q: %w", layout, err)
+	}
+
+	var entries []*cacheEntry
+	for _, m := range idx.Manifests {
+		entry := &cacheEntry{layout: layout, tag: m.Annotations[ocispec.AnnotationRefName]}
+		if created, err := time.Parse(time.RFC3339, m.Annotations[ocispec.AnnotationCreated]); err == nil {
+			entry.created = created
+		}
+		// caches with missing blobs cannot be imported and only hold on to space
+		if entry.blobs, err = referencedBlobs(m, readLayoutBlob(layout)); err != nil {
+			entry.blobs = nil
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// returns the size of the distinct blobs referenced by a set of caches
+func totalSize(entries []*cacheEntry) int64 {
+	seen := map[string]bool{}
+	var size int64
+	for _, entry := range entries {
+		for _, blob := range entry.blobs {
+			key := blobPath(entry.layout, blob.Digest)
+			if !seen[key] {
+				seen[key] = true
+				size += blob.Size
+			}
+		}
+	}
+	return size
+}
+
+func removeTag(entry *cacheEntry) error {
+	path := filepath.Join(entry.layout, "index.json")
+	lockPath := path + ociindex.IndexJSONLockFileSuffix
+	lock := flock.New(lockPath)
+	if locked, err := lock.TryLock(); err != nil || !locked {
+		return fmt.Errorf("cannot lock cache index %q: %v", path, err)
+	}
+	defer func() {
+		lock.Unlock()
+		os.RemoveAll(lockPath)
+	}()
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var idx ocispec.Index
+	if err := json.Unmarshal(bs, &idx); err != nil {
+		return fmt.Errorf("cannot read cache index %q: %w", path, err)
+	}
+
+	var manifests []ocispec.Descriptor
+	for _, m := range idx.Manifests {
+		if m.Annotations[ocispec.AnnotationRefName] != entry.tag {
+			manifests = append(manifests, m)
+		}
+	}
+	idx.Manifests = manifests
+
+	if bs, err = json.Marshal(idx); err != nil {
+		return err
+	}
+	return os.WriteFile(path, bs, 0644)
+}
+
+func removeUnreferencedBlobs(layout string, entries []*cacheEntry, now time.Time) error {
+	referenced := map[digest.Digest]bool{}
+	for _, entry := range entries {
+		if entry.layout != layout {
+			continue
+		}
+		for _, blob := range entry.blobs {
+			referenced[blob.Digest] = true
+		}
+	}
+
+	blobsDir := filepath.Join(layout, "blobs")
+	return filepath.Walk(blobsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == blobsDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() || now.Sub(info.ModTime()) < blobGracePeriod {
+			return nil
+		}
+
+		alg := filepath.Base(filepath.Dir(path))
+		if dgst := digest.NewDigestFromEncoded(digest.Algorithm(alg), info.Name()); !referenced[dgst] {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
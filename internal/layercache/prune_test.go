@@ -0,0 +1,63 @@
+package layercache
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrune(t *testing.T) {
+	root := t.TempDir()
+	app := LayoutDir(root, "registry.io/org/app")
+	api := LayoutDir(root, "registry.io/org/api")
+
+	now := time.Now()
+	stale := writeTestCache(t, app, "stale", now.Add(-48*time.Hour), "stale-layer")
+	oldest := writeTestCache(t, app, "main", now.Add(-2*time.Hour), "shared-layer", "main-layer")
+	newest := writeTestCache(t, api, "main", now.Add(-time.Hour), "api-layer")
+	ageBlobs(t, app)
+	ageBlobs(t, api)
+
+	// leave room for the newest cache only
+	var budget int64
+	for _, dgst := range newest {
+		fi, err := os.Stat(blobPath(api, dgst))
+		require.NoError(t, err)
+		budget += fi.Size()
+	}
+	require.NoError(t, Prune(LocalOptions{Dir: root, MaxAge: 24 * time.Hour, MaxSize: budget}))
+
+	assert.Empty(t, indexTags(t, app))
+	assert.Equal(t, []string{"main"}, indexTags(t, api))
+
+	for _, dgst := range append(stale, oldest...) {
+		assert.NoFileExists(t, blobPath(app, dgst))
+	}
+	for _, dgst := range newest {
+		assert.FileExists(t, blobPath(api, dgst))
+	}
+}
+
+func TestPrune_gracePeriod(t *testing.T) {
+	root := t.TempDir()
+	app := LayoutDir(root, "registry.io/org/app")
+	blobs := writeTestCache(t, app, "main", time.Now().Add(-48*time.Hour), "layer")
+
+	require.NoError(t, Prune(LocalOptions{Dir: root, MaxAge: time.Hour}))
+
+	// recently written blobs may be referenced by a concurrent build
+	assert.Empty(t, indexTags(t, app))
+	for _, dgst := range blobs {
+		assert.FileExists(t, blobPath(app, dgst))
+	}
+}
+
+func TestPrune_missingDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Remove(dir))
+
+	assert.NoError(t, Prune(LocalOptions{Dir: dir, MaxSize: 1}))
+}
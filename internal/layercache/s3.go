@@ -0,0 +1,239 @@
+package layercache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/moby/buildkit/client/ociindex"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// S3Store syncs cache layouts with an S3-compatible bucket.
+//
+// Blobs are shared by every repository and stored as "<prefix>/blobs/<alg>/<hex>" while the cache manifest descriptor
+// of every key is stored as "<prefix>/caches/<repository>/<key>.json". Requests use path-style addressing so that
+// object stores like MinIO work without additional DNS configuration. Objects are uploaded in parts, which lifts the
+// 5 GiB limit of single uploads for large layers.
+type S3Store struct {
+	opts     S3Options
+	client   *s3.Client
+	uploader *manager.Uploader
+}
+
+// NewS3Store creates a store using the default AWS credential chain, i.e. environment variables, shared
+// configuration files or web identity tokens.
+func NewS3Store(ctx context.Context, opts S3Options) (*S3Store, error) {
+	if !opts.Configured() {
+		return nil, errors.New("the s3 layer cache backend requires a bucket")
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(opts.Region))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot load aws configuration")
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = true
+		if opts.Endpoint != "" {
+			o.EndpointResolver = s3.EndpointResolverFromURL(opts.Endpoint)
+		}
+	})
+
+	return &S3Store{
+		opts:     opts,
+		client:   client,
+		uploader: manager.NewUploader(client),
+	}, nil
+}
+
+// Download fetches the caches of an image repository into a local layout. Keys that do not exist in the bucket are
+// skipped and blobs already present in the layout are not downloaded again.
+func (s *S3Store) Download(ctx context.Context, repository string, keys []string, dir string) error {
+	for _, key := range keys {
+		bs, err := s.get(ctx, s.cacheKey(repository, key))
+		if err != nil {
+			return err
+		}
+		if bs == nil {
+			continue
+		}
+
+		var desc ocispec.Descriptor
+		if err := json.Unmarshal(bs, &desc); err != nil {
+			return errors.Wrapf(err, "invalid cache %q", key)
+		}
+		blobs, err := referencedBlobs(desc, func(dgst digest.Digest) ([]byte, error) {
+			if err := s.downloadBlob(ctx, dir, dgst); err != nil {
+				return nil, err
+			}
+			return readLayoutBlob(dir)(dgst)
+		})
+		if err != nil {
+			return err
+		}
+		for _, blob := range blobs {
+			if err := s.downloadBlob(ctx, dir, blob.Digest); err != nil {
+				return err
+			}
+		}
+
+		if err := ociindex.PutDescToIndexJSONFileLocked(filepath.Join(dir, "index.json"), desc, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Upload stores a cache from a local layout in the bucket. Blobs that already exist in the bucket are not uploaded
+// again.
+func (s *S3Store) Upload(ctx context.Context, repository, key, dir string) error {
+	idx, err := ociindex.ReadIndexJSONFileLocked(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return err
+	}
+
+	var desc *ocispec.Descriptor
+	for i, m := range idx.Manifests {
+		if m.Annotations[ocispec.AnnotationRefName] == key {
+			desc = &idx.Manifests[i]
+		}
+	}
+	if desc == nil {
+		return fmt.Errorf("cache %q was not exported", key)
+	}
+
+	blobs, err := referencedBlobs(*desc, readLayoutBlob(dir))
+	if err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		if err := s.uploadBlob(ctx, dir, blob.Digest); err != nil {
+			return err
+		}
+	}
+
+	bs, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+	return s.put(ctx, s.cacheKey(repository, key), bytes.NewReader(bs))
+}
+
+func (s *S3Store) downloadBlob(ctx context.Context, dir string, dgst digest.Digest) error {
+	if err := dgst.Validate(); err != nil {
+		return err
+	}
+	fp := blobPath(dir, dgst)
+	if _, err := os.Stat(fp); err == nil {
+		return nil
+	}
+
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    aws.String(s.blobKey(dgst)),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "cannot download cache blob %s", dgst)
+	}
+	defer out.Body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fp), ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	verifier := dgst.Verifier()
+	_, err = io.Copy(io.MultiWriter(tmp, verifier), out.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cannot download cache blob %s", dgst)
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("cache blob %s does not match its digest", dgst)
+	}
+	return os.Rename(tmp.Name(), fp)
+}
+
+func (s *S3Store) uploadBlob(ctx context.Context, dir string, dgst digest.Digest) error {
+	key := s.blobKey(dgst)
+
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return nil
+	}
+	if !isNotFound(err) {
+		return errors.Wrapf(err, "cannot check cache blob %s", dgst)
+	}
+
+	f, err := os.Open(blobPath(dir, dgst))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return s.put(ctx, key, f)
+}
+
+// returns the contents of an object or nil when it does not exist
+func (s *S3Store) get(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get object %q", key)
+	}
+	defer out.Body.Close()
+
+	return io.ReadAll(out.Body)
+}
+
+// uploads an object, bodies larger than the part size of the uploader are sent as multipart uploads
+func (s *S3Store) put(ctx context.Context, key string, body io.Reader) error {
+	_, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	})
+	return errors.Wrapf(err, "cannot put object %q", key)
+}
+
+func (s *S3Store) blobKey(dgst digest.Digest) string {
+	return path.Join(s.opts.Prefix, "blobs", dgst.Algorithm().String(), dgst.Hex())
+}
+
+func (s *S3Store) cacheKey(repository, key string) string {
+	return path.Join(s.opts.Prefix, "caches", repository, key+".json")
+}
+
+// reports whether a request failed because the object does not exist
+func isNotFound(err error) bool {
+	var re *awshttp.ResponseError
+	return errors.As(err, &re) && re.HTTPStatusCode() == http.StatusNotFound
+}
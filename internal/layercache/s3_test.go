@@ -0,0 +1,151 @@
+package layercache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stands in for a MinIO instance serving a single bucket
+type fakeS3 struct {
+	mu        sync.Mutex
+	objects   map[string][]byte
+	parts     map[string]map[int][]byte
+	puts      int
+	multipart int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test-key/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.parts[r.URL.Path] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", r.URL.Path)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		bs, _ := io.ReadAll(r.Body)
+		f.parts[r.URL.Path][n] = bs
+		w.Header().Set("ETag", strconv.Quote(digest.FromBytes(bs).Hex()))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var bs []byte
+		for n := 1; n <= len(f.parts[r.URL.Path]); n++ {
+			bs = append(bs, f.parts[r.URL.Path][n]...)
+		}
+		delete(f.parts, r.URL.Path)
+		f.objects[r.URL.Path] = bs
+		f.puts++
+		f.multipart++
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodPut:
+		bs, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = bs
+		f.puts++
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		bs, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(bs)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestS3Store(t *testing.T) (*S3Store, *fakeS3) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test-secret")
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+
+	fake := &fakeS3{objects: map[string][]byte{}, parts: map[string]map[int][]byte{}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	store, err := NewS3Store(context.Background(), S3Options{Endpoint: srv.URL, Region: "us-east-1", Bucket: "cache", Prefix: "forge"})
+	require.NoError(t, err)
+	return store, fake
+}
+
+func TestS3Store(t *testing.T) {
+	store, fake := newTestS3Store(t)
+	ctx := context.Background()
+
+	src := t.TempDir()
+	blobs := writeTestCache(t, src, "main", time.Now(), "layer-1", "layer-2")
+	require.NoError(t, store.Upload(ctx, "registry.io/org/app", "main", src))
+
+	assert.Contains(t, fake.objects, "/cache/forge/caches/registry.io/org/app/main.json")
+	for _, dgst := range blobs {
+		assert.Contains(t, fake.objects, "/cache/forge/blobs/sha256/"+dgst.Hex())
+	}
+
+	// blobs that exist in the bucket are not uploaded again
+	puts := fake.puts
+	require.NoError(t, store.Upload(ctx, "registry.io/org/app", "main", src))
+	assert.Equal(t, puts+1, fake.puts)
+
+	dest := t.TempDir()
+	require.NoError(t, store.Download(ctx, "registry.io/org/app", []string{"feature", "main"}, dest))
+	assert.Equal(t, []string{"main"}, indexTags(t, dest))
+	for _, dgst := range blobs {
+		assert.FileExists(t, blobPath(dest, dgst))
+	}
+}
+
+func TestS3Store_multipartUpload(t *testing.T) {
+	store, fake := newTestS3Store(t)
+	ctx := context.Background()
+
+	// layers above the part size of the uploader are uploaded in parts
+	layer := strings.Repeat("x", int(manager.DefaultUploadPartSize)+1)
+	src := t.TempDir()
+	blobs := writeTestCache(t, src, "main", time.Now(), layer)
+	require.NoError(t, store.Upload(ctx, "app", "main", src))
+	assert.Equal(t, 1, fake.multipart)
+	assert.Equal(t, []byte(layer), fake.objects["/cache/forge/blobs/sha256/"+blobs[1].Hex()])
+
+	dest := t.TempDir()
+	require.NoError(t, store.Download(ctx, "app", []string{"main"}, dest))
+	assert.FileExists(t, blobPath(dest, blobs[1]))
+}
+
+func TestS3Store_corruptBlob(t *testing.T) {
+	store, fake := newTestS3Store(t)
+	ctx := context.Background()
+
+	src := t.TempDir()
+	blobs := writeTestCache(t, src, "main", time.Now(), "layer")
+	require.NoError(t, store.Upload(ctx, "app", "main", src))
+	fake.objects["/cache/forge/blobs/sha256/"+blobs[1].Hex()] = []byte("tampered")
+
+	assert.Error(t, store.Download(ctx, "app", []string{"main"}, t.TempDir()))
+}
+
+func TestS3Store_missingCache(t *testing.T) {
+	store, _ := newTestS3Store(t)
+
+	assert.Error(t, store.Upload(context.Background(), "app", "main", t.TempDir()))
+}
@@ -3,6 +3,7 @@ package amqp
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -35,6 +36,8 @@ type publisher struct {
 	uri       string
 	queueName string
 
+	// serializes pushes from concurrent builds, channels must not be shared while reconnecting
+	mu      sync.Mutex
 	conn    Connection
 	channel Channel
 	err     chan error
@@ -61,6 +64,9 @@ func NewPublisher(uri, queueName string, logger logr.Logger) (*publisher, error)
 // In the event that the underlying connection was closed after publisher creation, this function will attempt to
 // reconnection to the AMQP broker before performing these operations.
 func (p *publisher) Push(obj interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	select {
 	case <-p.err:
 		p.log.Info("attempting to reconnect to rabbitmq", "uri", p.uri)
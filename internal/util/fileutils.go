@@ -1,8 +1,12 @@
 package util
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 )
 
 func AssertDir(path string) error {
@@ -16,3 +20,75 @@ func AssertDir(path string) error {
 
 	return nil
 }
+
+// maximum number of symlinks followed while resolving a path, matches the linux kernel
+const maxSymlinks = 40
+
+var (
+	// ErrOutsideRoot is returned when a path resolves to a location outside of its root directory.
+	ErrOutsideRoot = errors.New("path resolves outside of the root directory")
+	// ErrTooManySymlinks is returned when resolving a path follows more symlinks than the operating system would.
+	ErrTooManySymlinks = errors.New("too many levels of symbolic links")
+)
+
+// ResolveInRoot resolves a path relative to root the way the operating system would, following every symlink along
+// the way. It fails with ErrOutsideRoot when the path, or any symlink it traverses, steps outside of root at any point.
+// The path is not cleaned beforehand since ".." must be applied to the resolved parent and not to its lexical one.
+// Components that do not exist are resolved lexically.
+func ResolveInRoot(root, path string) (string, error) {
+	root = filepath.Clean(root)
+	resolved := root
+	pending := splitPath(path)
+
+	for links := 0; len(pending) > 0; {
+		part := pending[0]
+		pending = pending[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if resolved == root {
+				return "", fmt.Errorf("%w: %q", ErrOutsideRoot, path)
+			}
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		fi, err := os.Lstat(next)
+		if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("%w: %q", ErrTooManySymlinks, path)
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			rel, err := filepath.Rel(root, target)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return "", fmt.Errorf("%w: %q links to %q", ErrOutsideRoot, path, target)
+			}
+			resolved, target = root, rel
+		}
+		pending = append(splitPath(target), pending...)
+	}
+
+	return resolved, nil
+}
+
+func splitPath(path string) []string {
+	return strings.Split(filepath.ToSlash(path), "/")
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -64,3 +65,62 @@ func TestAssertDir_notFound(t *testing.T) {
 
 	assert.IsType(t, &os.PathError{}, err)
 }
+
+func TestResolveInRoot(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	for _, dir := range []string{"dir", "..dir"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, fp := range []string{filepath.Join(parent, "secret"), filepath.Join(root, "dir", "file")} {
+		if err := os.WriteFile(fp, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"self":     ".",
+		"parent":   "self/..",
+		"nested":   "dir/../dir/file",
+		"absolute": filepath.Join(root, "dir"),
+		"outside":  filepath.Join(parent, "secret"),
+		"loop":     "loop",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+		escapes  bool
+	}{
+		{path: "dir/file", expected: filepath.Join(root, "dir", "file")},
+		{path: "..dir", expected: filepath.Join(root, "..dir")},
+		{path: "missing/../dir", expected: filepath.Join(root, "dir")},
+		{path: "dir/file/missing", expected: filepath.Join(root, "dir", "file", "missing")},
+		{path: "self/dir/file", expected: filepath.Join(root, "dir", "file")},
+		{path: "nested", expected: filepath.Join(root, "dir", "file")},
+		{path: "absolute/file", expected: filepath.Join(root, "dir", "file")},
+		{path: "..", escapes: true},
+		{path: "dir/../..", escapes: true},
+		{path: "self/..", escapes: true},
+		{path: "parent/secret", escapes: true},
+		{path: "outside", escapes: true},
+	}
+	for _, tc := range tests {
+		actual, err := ResolveInRoot(root, tc.path)
+		if tc.escapes {
+			assert.ErrorIs(t, err, ErrOutsideRoot, tc.path)
+			continue
+		}
+		assert.NoError(t, err, tc.path)
+		assert.Equal(t, tc.expected, actual, tc.path)
+	}
+
+	_, err := ResolveInRoot(root, "loop")
+	assert.ErrorIs(t, err, ErrTooManySymlinks)
+}